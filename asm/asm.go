@@ -0,0 +1,244 @@
+// Package asm translates between VM bytecode and a textual assembly.
+//
+// Every line holds at most one instruction, written as its mnemonic, and
+// everything after a ';' is a comment:
+//
+//	loop:            ; a label is the position of the next instruction
+//	    PUSH 3       ; decimal, 0x hex or 'c' character operands
+//	    BYTE 'F'
+//	    PUSH loop    ; a label as operand
+//	    JUMP
+//	    .byte 0xff   ; a raw byte
+//
+// PUSH and BYTE are the only instructions with an operand, which is a single
+// byte encoded before the instruction.
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/anthoai97/blockchain-from-scratch/core"
+)
+
+// directiveByte emits its operand as a raw byte.
+const directiveByte = ".byte"
+
+var instructions = map[string]core.Instruction{}
+
+func init() {
+	for i := 0; i < 256; i++ {
+		instr := core.Instruction(i)
+		if instr.IsValid() {
+			instructions[instr.String()] = instr
+		}
+	}
+}
+
+type line struct {
+	number   int
+	mnemonic string
+	operand  string
+}
+
+// size returns the number of bytes of the line once assembled.
+func (l line) size() int {
+	if instr, ok := instructions[l.mnemonic]; ok && instr.HasOperand() {
+		return 2
+	}
+	return 1
+}
+
+// Assemble returns the bytecode of the source.
+func Assemble(src string) ([]byte, error) {
+	var (
+		lines  []line
+		labels = map[string]int{}
+		pos    int
+	)
+
+	for i, text := range strings.Split(src, "\n") {
+		number := i + 1
+
+		text = strings.TrimSpace(stripComment(text))
+
+		if idx := strings.IndexByte(text, ':'); idx >= 0 && !strings.ContainsAny(text[:idx], " \t'") {
+			label := text[:idx]
+			if !isIdentifier(label) {
+				return nil, fmt.Errorf("line %d: invalid label %q", number, label)
+			}
+			if _, ok := labels[label]; ok {
+				return nil, fmt.Errorf("line %d: duplicate label %q", number, label)
+			}
+			labels[label] = pos
+			text = strings.TrimSpace(text[idx+1:])
+		}
+
+		if text == "" {
+			continue
+		}
+
+		l := line{
+			number:   number,
+			mnemonic: text,
+		}
+		if idx := strings.IndexFunc(text, unicode.IsSpace); idx >= 0 {
+			l.mnemonic = text[:idx]
+			l.operand = strings.TrimSpace(text[idx:])
+		}
+		if l.mnemonic != directiveByte {
+			l.mnemonic = strings.ToUpper(l.mnemonic)
+		}
+
+		lines = append(lines, l)
+		pos += l.size()
+	}
+
+	code := make([]byte, 0, pos)
+	for _, l := range lines {
+		b, err := l.assemble(labels)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", l.number, err)
+		}
+		code = append(code, b...)
+	}
+
+	return code, nil
+}
+
+// stripComment removes the comment of the line, a ';' character operand is
+// not a comment.
+func stripComment(text string) string {
+	quoted := false
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && quoted:
+			i++
+		case text[i] == '\'':
+			quoted = !quoted
+		case text[i] == ';' && !quoted:
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func (l line) assemble(labels map[string]int) ([]byte, error) {
+	if l.mnemonic == directiveByte {
+		if l.operand == "" {
+			return nil, fmt.Errorf("%s needs an operand", directiveByte)
+		}
+		b, err := parseOperand(l.operand, labels)
+		if err != nil {
+			return nil, err
+		}
+		return []byte{b}, nil
+	}
+
+	instr, ok := instructions[l.mnemonic]
+	if !ok {
+		return nil, fmt.Errorf("unknown instruction %q", l.mnemonic)
+	}
+
+	if !instr.HasOperand() {
+		if l.operand != "" {
+			return nil, fmt.Errorf("%s takes no operand", instr)
+		}
+		return []byte{byte(instr)}, nil
+	}
+
+	if l.operand == "" {
+		return nil, fmt.Errorf("%s needs an operand", instr)
+	}
+	b, err := parseOperand(l.operand, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte{b, byte(instr)}, nil
+}
+
+// parseOperand parses a label, a character or a number of one byte.
+func parseOperand(s string, labels map[string]int) (byte, error) {
+	if strings.HasPrefix(s, "'") {
+		r, err := strconv.Unquote(s)
+		if err != nil || len(r) != 1 {
+			return 0, fmt.Errorf("invalid character %s", s)
+		}
+		return r[0], nil
+	}
+
+	if isIdentifier(s) {
+		pos, ok := labels[s]
+		if !ok {
+			return 0, fmt.Errorf("undefined label %q", s)
+		}
+		if pos > 0xff {
+			return 0, fmt.Errorf("label %q at %d does not fit in a byte", s, pos)
+		}
+		return byte(pos), nil
+	}
+
+	v, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid operand %q", s)
+	}
+
+	return byte(v), nil
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Disassemble returns the assembly of the code, every line is annotated with
+// the position and the bytes of the instruction. Bytes that can not be
+// executed are written as .byte so that the text assembles back to the same
+// code.
+func Disassemble(code []byte) string {
+	var sb strings.Builder
+
+	for pos := 0; pos < len(code); {
+		var (
+			text string
+			size = 1
+		)
+
+		instr := core.Instruction(code[pos])
+
+		switch {
+		case pos+1 < len(code) && core.Instruction(code[pos+1]).HasOperand():
+			instr = core.Instruction(code[pos+1])
+			text = fmt.Sprintf("%s %s", instr, formatOperand(instr, code[pos]))
+			size = 2
+		case instr.IsValid() && !instr.HasOperand():
+			text = instr.String()
+		default:
+			text = fmt.Sprintf("%s 0x%02x", directiveByte, code[pos])
+		}
+
+		fmt.Fprintf(&sb, "%-16s ; %04x: % x\n", text, pos, code[pos:pos+size])
+		pos += size
+	}
+
+	return sb.String()
+}
+
+func formatOperand(instr core.Instruction, b byte) string {
+	if instr == core.InstrByte && b < unicode.MaxASCII && unicode.IsPrint(rune(b)) && b != '\'' && b != '\\' {
+		return fmt.Sprintf("'%c'", b)
+	}
+	return strconv.Itoa(int(b))
+}
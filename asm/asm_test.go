@@ -0,0 +1,122 @@
+package asm
+
+import (
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssemble(t *testing.T) {
+	src := `
+		; store 5 under FOO
+		PUSH 5
+		BYTE 'F'
+		BYTE 'O'
+		byte 0x4f ; the second O
+		PUSH 3
+		PACK
+		STORE
+	`
+
+	code, err := Assemble(src)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}, code)
+}
+
+func TestAssembleLabels(t *testing.T) {
+	src := `
+		PUSH 1
+	loop:	PUSH 1
+		ADD
+		PUSH end
+		JUMP
+		PUSH loop
+		JUMP
+	end:
+		BYTE ';'
+	`
+
+	code, err := Assemble(src)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{
+		0x01, 0x0a, // 0: push 1
+		0x01, 0x0a, // 2: push 1
+		0x0b,       // 4: add
+		0x0b, 0x0a, // 5: push 11
+		0x19,       // 7: jump
+		0x02, 0x0a, // 8: push 2
+		0x19,       // 10: jump
+		0x3b, 0x0c, // 11: byte ';'
+	}, code)
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []string{
+		"FOO",
+		"PUSH",
+		"PUSH 256",
+		"PUSH -1",
+		"ADD 1",
+		"PUSH nowhere",
+		"a:\na:",
+		"1a: ADD",
+		"BYTE 'ab'",
+		".byte",
+	}
+
+	for _, src := range tests {
+		_, err := Assemble(src)
+		assert.NotNil(t, err, src)
+	}
+}
+
+func TestRoundTripEveryInstruction(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		instr := core.Instruction(i)
+
+		code := []byte{byte(instr)}
+		if instr.HasOperand() {
+			code = []byte{'A', byte(instr)}
+		}
+
+		text := Disassemble(code)
+		decoded, err := Assemble(text)
+		assert.Nil(t, err, text)
+		assert.Equal(t, code, decoded, text)
+
+		if instr.IsValid() {
+			assert.Contains(t, text, instr.String())
+		}
+	}
+}
+
+func TestRoundTripOperands(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		code := []byte{
+			byte(i), byte(core.InstrPush),
+			byte(i), byte(core.InstrByte),
+		}
+
+		decoded, err := Assemble(Disassemble(code))
+		assert.Nil(t, err)
+		assert.Equal(t, code, decoded)
+	}
+}
+
+func TestRoundTripMalformedCode(t *testing.T) {
+	// A push without operand, an unknown byte and a trailing operand
+	code := []byte{byte(core.InstrPush), 0xff, byte(core.InstrAdd), 0x01}
+
+	decoded, err := Assemble(Disassemble(code))
+	assert.Nil(t, err)
+	assert.Equal(t, code, decoded)
+}
+
+func TestDisassemble(t *testing.T) {
+	text := Disassemble([]byte{0x05, 0x0a, 0x46, 0x0c, 0x0f})
+	assert.Equal(t, ""+
+		"PUSH 5           ; 0000: 05 0a\n"+
+		"BYTE 'F'         ; 0002: 46 0c\n"+
+		"STORE            ; 0004: 0f\n", text)
+}
@@ -0,0 +1,62 @@
+// Command asm assembles VM assembly into hex encoded bytecode, or with -d
+// disassembles hex encoded bytecode. It reads the file given as argument or
+// the standard input.
+//
+//	asm contract.asm
+//	echo 050a0f | asm -d
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/anthoai97/blockchain-from-scratch/asm"
+)
+
+func main() {
+	disassemble := flag.Bool("d", false, "disassemble hex encoded bytecode")
+	flag.Parse()
+
+	if err := run(*disassemble, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "asm:", err)
+		os.Exit(1)
+	}
+}
+
+func run(disassemble bool, args []string) error {
+	var r io.Reader = os.Stdin
+	if len(args) > 0 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	input, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if disassemble {
+		code, err := hex.DecodeString(strings.Join(strings.Fields(string(input)), ""))
+		if err != nil {
+			return err
+		}
+		fmt.Print(asm.Disassemble(code))
+		return nil
+	}
+
+	code, err := asm.Assemble(string(input))
+	if err != nil {
+		return err
+	}
+	fmt.Println(hex.EncodeToString(code))
+
+	return nil
+}
@@ -2,8 +2,7 @@ package core
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/gob"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,27 +10,67 @@ import (
 	"github.com/anthoai97/blockchain-from-scratch/types"
 )
 
+// ErrTxNotFound is returned by TxProof when the block does not contain the
+// transaction.
+var ErrTxNotFound = errors.New("transaction not found")
+
+// Versions of the header, the canonical encoding of a header depends on its
+// version.
+const (
+	// HeaderVersion1 is the header before logs blooms.
+	HeaderVersion1 uint32 = 1
+	// HeaderVersion2 adds LogsBloom to the header.
+	HeaderVersion2 uint32 = 2
+	// HeaderVersion3 adds the Commit to the block, the header is the same
+	// as in version 2.
+	HeaderVersion3 uint32 = 3
+	// HeaderVersion is the version of new headers.
+	HeaderVersion = HeaderVersion3
+)
+
 type Header struct {
 	Version       uint32
 	DataHash      types.Hash
 	PrevBlockHash types.Hash
+	StateRoot     types.Hash  // root of the state after executing the block
+	LogsBloom     types.Bloom // from HeaderVersion2, zero before
 	Timestamp     int64
 	Height        uint32
 }
 
+// HasLogsBloom tells if the version of the header has a logs bloom.
+func (h *Header) HasLogsBloom() bool {
+	return h.Version >= HeaderVersion2
+}
+
+// HasCommitField tells if the blocks of the version of the header can carry
+// a commit.
+func (h *Header) HasCommitField() bool {
+	return h.Version >= HeaderVersion3
+}
+
+// Bytes returns the canonical encoding of the header.
 func (h *Header) Bytes() []byte {
-	buf := &bytes.Buffer{}
-	enc := gob.NewEncoder(buf)
-	enc.Encode(h)
+	buf := bytes.NewBuffer(make([]byte, 0, headerSize))
+	cw := &canonicalWriter{w: buf}
+	cw.writeHeader(h)
 
 	return buf.Bytes()
 }
 
+// SigningDigest returns the digest signed by the validator of the block.
+func (h *Header) SigningDigest() types.Hash {
+	return crypto.Digest(headerSigningDomain, h.Bytes())
+}
+
 type Block struct {
 	*Header
 	Transactions []*Transaction
 	Validator    crypto.PublicKey
 	Signature    *crypto.Signature
+	// Commit is the multi-signature of the header by the validators, from
+	// HeaderVersion3. It is required on chains with a validator set.
+	Commit *Commit
 
 	// Cached version of the header hash
 	hash types.Hash
@@ -49,7 +88,7 @@ func NewBlockFromPrevHeader(prevHeader *Header, txx []*Transaction) (*Block, err
 	}
 
 	header := &Header{
-		Version:       1,
+		Version:       HeaderVersion,
 		Height:        prevHeader.Height + 1,
 		DataHash:      dataHash,
 		PrevBlockHash: BlockHasher{}.Hash(prevHeader),
@@ -64,7 +103,7 @@ func (b *Block) AddTransaction(tx *Transaction) {
 }
 
 func (b *Block) Sign(privKey crypto.PrivateKey) error {
-	sig, err := privKey.Sign(b.Header.Bytes())
+	sig, err := privKey.SignDigest(b.Header.SigningDigest())
 	if err != nil {
 		return err
 	}
@@ -79,7 +118,7 @@ func (b *Block) Verify() error {
 	if b.Signature == nil {
 		return fmt.Errorf("block hash no signature")
 	}
-	if !b.Signature.Verify(b.Validator, b.Header.Bytes()) {
+	if !b.Signature.VerifyDigest(b.Validator, b.Header.SigningDigest()) {
 		return fmt.Errorf("block hash hash invalid signature")
 	}
 
@@ -117,16 +156,67 @@ func (b *Block) Hash(hasher Hasher[*Header]) types.Hash {
 	return b.hash
 }
 
-func CalculateDataHash(txx []*Transaction) (hash types.Hash, err error) {
-	buf := &bytes.Buffer{}
+// TxProof returns the proof that the transaction with the given hash is
+// included in the block.
+func (b *Block) TxProof(hash types.Hash) (*TxProof, error) {
+	for i, tx := range b.Transactions {
+		if (TxHasher{}).Hash(tx) != hash {
+			continue
+		}
 
-	for _, tx := range txx {
-		if err = tx.Encode(NewGobTxEncoder(buf)); err != nil {
-			return
+		proof, err := NewMerkleProof(txLeaves(b.Transactions), i)
+		if err != nil {
+			return nil, err
 		}
+
+		return &TxProof{TxHash: hash, Proof: proof}, nil
+	}
+
+	return nil, fmt.Errorf("block (%s) does not contain transaction (%s): %w", b.Hash(BlockHasher{}), hash, ErrTxNotFound)
+}
+
+// SignedHeader returns the header of the block with its validator signature.
+func (b *Block) SignedHeader() *SignedHeader {
+	return &SignedHeader{
+		Header:    b.Header,
+		Validator: b.Validator,
+		Signature: b.Signature,
+	}
+}
+
+// SignedHeader is a block header without its transactions, it is what light
+// clients sync with.
+type SignedHeader struct {
+	*Header
+	Validator crypto.PublicKey
+	Signature *crypto.Signature
+}
+
+func (h *SignedHeader) Verify() error {
+	if h.Signature == nil {
+		return fmt.Errorf("header (%d) has no signature", h.Height)
+	}
+	if h.Validator.Key == nil {
+		return fmt.Errorf("header (%d) has no validator", h.Height)
+	}
+	if !h.Signature.VerifyDigest(h.Validator, h.Header.SigningDigest()) {
+		return fmt.Errorf("header (%d) has an invalid signature", h.Height)
 	}
 
-	hash = sha256.Sum256(buf.Bytes())
+	return nil
+}
+
+// CalculateDataHash returns the merkle root over the hashes of the given
+// transactions.
+func CalculateDataHash(txx []*Transaction) (hash types.Hash, err error) {
+	return MerkleRoot(txLeaves(txx)), nil
+}
+
+func txLeaves(txx []*Transaction) [][]byte {
+	leaves := make([][]byte, len(txx))
+	for i, tx := range txx {
+		leaves[i] = TxHasher{}.Hash(tx).ToSlice()
+	}
 
-	return
+	return leaves
 }
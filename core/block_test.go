@@ -37,6 +37,48 @@ func TestVerifyBlock(t *testing.T) {
 	assert.NotNil(t, b.Verify())
 }
 
+func TestBlockSignatureCoversHeader(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	b := randomBlock(t, 1, types.RandomHash())
+	assert.Nil(t, b.Sign(privKey))
+	assert.Nil(t, b.Verify())
+
+	tampers := []func(h *Header){
+		func(h *Header) { h.Version++ },
+		func(h *Header) { h.PrevBlockHash = types.RandomHash() },
+		func(h *Header) { h.StateRoot = types.RandomHash() },
+		func(h *Header) { h.LogsBloom[0] ^= 0x01 },
+		func(h *Header) { h.Timestamp++ },
+		func(h *Header) { h.Height++ },
+	}
+	for i, tamper := range tampers {
+		header := *b.Header
+		tamper(&header)
+		signed := &SignedHeader{Header: &header, Validator: b.Validator, Signature: b.Signature}
+		assert.NotNil(t, signed.Verify(), "tamper %d", i)
+	}
+
+	// A signature over the raw header bytes is not valid
+	sig, err := privKey.Sign(b.Header.Bytes())
+	assert.Nil(t, err)
+	b.Signature = sig
+	assert.NotNil(t, b.Verify())
+}
+
+func TestBlockSignatureDeterministic(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	b := randomBlock(t, 1, types.RandomHash())
+
+	encode := func() []byte {
+		assert.Nil(t, b.Sign(privKey))
+		buf := &bytes.Buffer{}
+		assert.Nil(t, b.Encode(NewCanonicalBlockEncoder(buf)))
+		return buf.Bytes()
+	}
+
+	assert.Equal(t, encode(), encode())
+}
+
 func TestDecodeEncodeBlock(t *testing.T) {
 	b := randomBlock(t, 1, types.Hash{})
 	buf := &bytes.Buffer{}
@@ -51,7 +93,7 @@ func randomBlock(t *testing.T, height uint32, previousBlockHash types.Hash) *Blo
 	privKey := crypto.GeneratePrivateKey()
 	tx := randomTxWithSignature(t)
 	header := &Header{
-		Version:       1,
+		Version:       HeaderVersion,
 		PrevBlockHash: previousBlockHash,
 		Height:        height,
 		Timestamp:     time.Now().UnixNano(),
@@ -66,3 +108,14 @@ func randomBlock(t *testing.T, height uint32, previousBlockHash types.Hash) *Blo
 
 	return b
 }
+
+func TestJSONEncodeDecodeBlock(t *testing.T) {
+	b := randomBlock(t, 1, types.RandomHash())
+	buf := &bytes.Buffer{}
+	assert.Nil(t, b.Encode(NewJSONBlockEncoder(buf)))
+
+	bDecode := new(Block)
+	assert.Nil(t, bDecode.Decode(NewJSONBlockDecoder(buf)))
+	assert.Equal(t, b.Hash(BlockHasher{}), bDecode.Hash(BlockHasher{}))
+	assert.Nil(t, bDecode.Verify())
+}
@@ -4,9 +4,14 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
 	"github.com/go-kit/log"
 )
 
+// maxHeadersPerRequest is the maximum number of headers returned by GetHeaders.
+const maxHeadersPerRequest = 500
+
 type Blockchain struct {
 	logger        log.Logger
 	store         Storage
@@ -14,18 +19,49 @@ type Blockchain struct {
 	headers       []*Header
 	validator     Validator
 	contractState *State
+	// states holds the state after each block, indexed by height. A state is
+	// never modified once the block is added, blocks execute on a copy.
+	states        []*State
+	receipts      [][]*Receipt
+	blockGasLimit uint64
+	executor      Executor
+	// signatureAlgorithms are the algorithms of the keys allowed to sign
+	// blocks and transactions, from the chain params of the genesis block.
+	signatureAlgorithms map[crypto.Algorithm]bool
+	// validatorSet commits the blocks, from the chain params of the genesis
+	// block, blocks need no commit when nil.
+	validatorSet *ValidatorSet
 }
 
+// NewBlockchain returns the chain starting at the genesis block, the
+// parameters of the chain are read from it, see ChainParams.
 func NewBlockchain(l log.Logger, genesis *Block) (*Blockchain, error) {
+	params, err := genesisParams(genesis)
+	if err != nil {
+		return nil, err
+	}
+	algs, err := params.signatureAlgorithms()
+	if err != nil {
+		return nil, err
+	}
+	vs, err := params.validatorSet()
+	if err != nil {
+		return nil, err
+	}
+
 	bc := &Blockchain{
-		contractState: NewState(),
-		headers:       []*Header{},
-		store:         NewMemoryStore(),
-		logger:        l,
+		contractState:       NewState(),
+		headers:             []*Header{},
+		store:               NewMemoryStore(),
+		logger:              l,
+		blockGasLimit:       DefaultBlockGasLimit,
+		executor:            StackExecutor{},
+		signatureAlgorithms: algs,
+		validatorSet:        vs,
 	}
 	bc.validator = NewBlockValidator(bc)
 
-	err := bc.addBlockWithoutValidation(genesis)
+	err = bc.addBlockWithoutValidation(genesis, bc.contractState, []*Receipt{})
 
 	return bc, err
 }
@@ -41,25 +77,253 @@ func (bc *Blockchain) GetHeader(height uint32) (*Header, error) {
 	return bc.headers[height], nil
 }
 
+func (bc *Blockchain) GetBlock(height uint32) (*Block, error) {
+	if height > bc.Height() {
+		return nil, fmt.Errorf("given height (%d) too hight", height)
+	}
+
+	return bc.store.Get(height)
+}
+
+// GetHeaders returns the signed headers from the given height up to and
+// including the to height, bounded by the current height of the chain.
+func (bc *Blockchain) GetHeaders(from, to uint32) ([]*SignedHeader, error) {
+	if from > bc.Height() {
+		return nil, fmt.Errorf("given height (%d) too hight", from)
+	}
+	if to > bc.Height() {
+		to = bc.Height()
+	}
+	if to >= from+maxHeadersPerRequest {
+		to = from + maxHeadersPerRequest - 1
+	}
+
+	headers := []*SignedHeader{}
+	for height := from; height <= to; height++ {
+		b, err := bc.GetBlock(height)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, b.SignedHeader())
+	}
+
+	return headers, nil
+}
+
+// GetTxProof returns the proof that the transaction is included in the block
+// at the given height.
+func (bc *Blockchain) GetTxProof(height uint32, hash types.Hash) (*TxProof, error) {
+	b, err := bc.GetBlock(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.TxProof(hash)
+}
+
+// GetStateProof returns the proof of the value of the key in the state after
+// executing the block at the given height.
+func (bc *Blockchain) GetStateProof(height uint32, key []byte) (*StateProof, error) {
+	state, err := bc.stateAt(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return state.Prove(key)
+}
+
+// StateRoot returns the root of the current state.
+func (bc *Blockchain) StateRoot() types.Hash {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.contractState.Root()
+}
+
+// GetReceipts returns the receipts of the transactions of the block at the
+// given height.
+func (bc *Blockchain) GetReceipts(height uint32) ([]*Receipt, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if int(height) >= len(bc.receipts) {
+		return nil, fmt.Errorf("given height (%d) too hight", height)
+	}
+
+	return bc.receipts[height], nil
+}
+
+// FilterLogs returns the logs of the blocks from the given height up to and
+// including the to height that match the address and the topics. A nil
+// address matches any address, a topic matches the topic at the same position
+// of a log and the zero hash matches any topic. Blocks whose logs bloom does
+// not contain the address and all the topics are skipped, blocks with a
+// header version without bloom are always searched.
+func (bc *Blockchain) FilterLogs(from, to uint32, address *types.Address, topics []types.Hash) ([]*Log, error) {
+	if from > to {
+		return nil, fmt.Errorf("invalid height range (%d) to (%d)", from, to)
+	}
+	if to > bc.Height() {
+		to = bc.Height()
+	}
+
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	logs := []*Log{}
+	for height := from; height <= to; height++ {
+		h := bc.headers[height]
+		if h.HasLogsBloom() && !bloomMatches(h.LogsBloom, address, topics) {
+			continue
+		}
+
+		for _, receipt := range bc.receipts[height] {
+			for _, l := range receipt.Logs {
+				if l.Matches(address, topics) {
+					logs = append(logs, l)
+				}
+			}
+		}
+	}
+
+	return logs, nil
+}
+
 func (bc *Blockchain) SetValidator(v Validator) {
 	bc.validator = v
 }
 
+// SetBlockGasLimit sets the maximum of the sum of the gas limits of the
+// transactions in a block.
+func (bc *Blockchain) SetBlockGasLimit(limit uint64) {
+	bc.blockGasLimit = limit
+}
+
+func (bc *Blockchain) BlockGasLimit() uint64 {
+	return bc.blockGasLimit
+}
+
+// SetExecutor sets the executor of the code of the transactions, it must be
+// set before adding blocks with code.
+func (bc *Blockchain) SetExecutor(executor Executor) {
+	bc.executor = executor
+}
+
+// ValidatorSet returns the validators whose commit every block must carry,
+// nil when the chain params of the genesis block have none.
+func (bc *Blockchain) ValidatorSet() *ValidatorSet {
+	return bc.validatorSet
+}
+
+// AllowsSignatureAlgorithm tells if keys of the algorithm may sign blocks and
+// transactions of the chain, see ChainParams.SignatureAlgorithms.
+func (bc *Blockchain) AllowsSignatureAlgorithm(alg crypto.Algorithm) bool {
+	return bc.signatureAlgorithms[alg]
+}
+
+// ExecuteBlock executes the transactions of the block on a copy of the
+// current state and returns the resulting state with the receipts of the
+// transactions. Block producers use it to fill in the state root before
+// signing. A failing transaction only reverts its own writes, the block is
+// invalid when its transactions may use more gas than the block gas limit.
+func (bc *Blockchain) ExecuteBlock(b *Block) (*State, []*Receipt, error) {
+	bc.lock.RLock()
+	state := bc.contractState.Copy()
+	bc.lock.RUnlock()
+
+	var (
+		receipts = make([]*Receipt, 0, len(b.Transactions))
+		gasLimit uint64
+	)
+
+	for _, tx := range b.Transactions {
+		if tx.Gas() > bc.blockGasLimit-gasLimit {
+			return nil, nil, fmt.Errorf("block (%s) exceeds the block gas limit (%d)", b.Hash(BlockHasher{}), bc.blockGasLimit)
+		}
+		gasLimit += tx.Gas()
+
+		bc.logger.Log("msg", "executing code", "hash", tx.Hash(TxHasher{}))
+
+		receipt, _, _ := bc.executeTx(tx, state, nil)
+		for _, l := range receipt.Logs {
+			l.Height = b.Height
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	state.commit()
+
+	return state, receipts, nil
+}
+
+// executeTx executes the transaction against the state and returns its
+// receipt with the return data of the code, the tracer may be nil. The
+// returned error is the reason of the failure of the transaction, it is also
+// set in the receipt.
+func (bc *Blockchain) executeTx(tx *Transaction, state *State, tracer Tracer) (*Receipt, []byte, error) {
+	snapshot := state.Snapshot()
+
+	receipt := &Receipt{
+		TxHash: tx.Hash(TxHasher{}),
+	}
+
+	var (
+		result ExecutionResult
+		err    error
+	)
+	switch tx.Type {
+	case TxTypeScript:
+		result, err = bc.executor.Execute(&Execution{
+			VMVersion: tx.VMVersion,
+			Code:      tx.Data,
+			State:     state,
+			GasLimit:  tx.Gas(),
+			Tracer:    tracer,
+		})
+	case TxTypeDeploy:
+		receipt.ContractAddress, result.GasUsed, err = deployContract(tx, state)
+	case TxTypeCall:
+		result, err = callContract(bc.executor, tx, state, tracer)
+	default:
+		err = fmt.Errorf("unknown transaction type %d", tx.Type)
+	}
+
+	receipt.GasUsed = result.GasUsed
+	receipt.Logs = result.Logs
+
+	if err != nil {
+		state.RevertToSnapshot(snapshot)
+		receipt.Err = err.Error()
+		receipt.Logs = nil
+	}
+
+	for _, l := range receipt.Logs {
+		l.TxHash = receipt.TxHash
+	}
+
+	return receipt, result.ReturnData, err
+}
+
 func (bc *Blockchain) AddBlock(b *Block) error {
 	// Validate before
 	if err := bc.validator.ValidateBlock(b); err != nil {
 		return err
 	}
 
-	for _, tx := range b.Transactions {
-		bc.logger.Log("msg", "executing code", "hash", tx.Hash(TxHasher{}))
-		vm := NewVM(tx.Data, bc.contractState)
-		if err := vm.Run(); err != nil {
-			return err
-		}
+	state, receipts, err := bc.ExecuteBlock(b)
+	if err != nil {
+		return err
+	}
+
+	if root := state.Root(); root != b.StateRoot {
+		return fmt.Errorf("block (%s) has an invalid state root (%s) expected (%s)", b.Hash(BlockHasher{}), b.StateRoot, root)
 	}
 
-	return bc.addBlockWithoutValidation(b)
+	if bloom := LogsBloom(receipts); b.HasLogsBloom() && bloom != b.LogsBloom {
+		return fmt.Errorf("block (%s) has an invalid logs bloom", b.Hash(BlockHasher{}))
+	}
+
+	return bc.addBlockWithoutValidation(b, state, receipts)
 }
 
 func (bc *Blockchain) HasBlock(heigth uint32) bool {
@@ -72,9 +336,23 @@ func (bc *Blockchain) Height() uint32 {
 	return uint32(len(bc.headers) - 1)
 }
 
-func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
+func (bc *Blockchain) stateAt(height uint32) (*State, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if int(height) >= len(bc.states) {
+		return nil, fmt.Errorf("given height (%d) too hight", height)
+	}
+
+	return bc.states[height], nil
+}
+
+func (bc *Blockchain) addBlockWithoutValidation(b *Block, state *State, receipts []*Receipt) error {
 	bc.lock.Lock()
 	bc.headers = append(bc.headers, b.Header)
+	bc.states = append(bc.states, state)
+	bc.receipts = append(bc.receipts, receipts)
+	bc.contractState = state
 	bc.lock.Unlock()
 
 	bc.logger.Log(
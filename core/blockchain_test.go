@@ -2,8 +2,10 @@ package core
 
 import (
 	"fmt"
+	"math/big"
 	"testing"
 
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
 	"github.com/anthoai97/blockchain-from-scratch/types"
 	"github.com/go-kit/log"
 	"github.com/stretchr/testify/assert"
@@ -22,6 +24,26 @@ func getPreviousBlockHash(t *testing.T, bc *Blockchain, height uint32) types.Has
 	return BlockHasher{}.Hash(previousHeader)
 }
 
+// addBlockWithTxx adds a valid block with the given transactions on top of the
+// chain.
+func addBlockWithTxx(t *testing.T, bc *Blockchain, privKey crypto.PrivateKey, txx ...*Transaction) *Block {
+	prevHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+
+	b, err := NewBlockFromPrevHeader(prevHeader, txx)
+	assert.Nil(t, err)
+
+	state, receipts, err := bc.ExecuteBlock(b)
+	assert.Nil(t, err)
+	b.StateRoot = state.Root()
+	b.LogsBloom = LogsBloom(receipts)
+
+	assert.Nil(t, b.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(b))
+
+	return b
+}
+
 func TestNewBlockchain(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
 	assert.NotNil(t, bc.validator)
@@ -70,3 +92,237 @@ func TestAddBlocToHigh(t *testing.T) {
 	assert.Nil(t, bc.AddBlock(randomBlock(t, 1, getPreviousBlockHash(t, bc, uint32(1)))))
 	assert.NotNil(t, bc.AddBlock(randomBlock(t, 3, types.Hash{})))
 }
+
+func TestAddBlockInvalidStateRoot(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	b := randomBlock(t, 1, getPreviousBlockHash(t, bc, 1))
+	b.StateRoot = types.RandomHash()
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+
+	assert.NotNil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(0), bc.Height())
+}
+
+func TestAddBlockHeaderVersions(t *testing.T) {
+	genesis, err := NewBlock(&Header{Version: HeaderVersion1}, nil)
+	assert.Nil(t, err)
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+	privKey := crypto.GeneratePrivateKey()
+
+	newBlock := func(version uint32, bloom types.Bloom) *Block {
+		prevHeader, err := bc.GetHeader(bc.Height())
+		assert.Nil(t, err)
+		b, err := NewBlockFromPrevHeader(prevHeader, []*Transaction{randomTxWithSignature(t)})
+		assert.Nil(t, err)
+		b.Version = version
+		state, _, err := bc.ExecuteBlock(b)
+		assert.Nil(t, err)
+		b.StateRoot = state.Root()
+		b.LogsBloom = bloom
+		assert.Nil(t, b.Sign(privKey))
+		return b
+	}
+
+	var bloom types.Bloom
+	bloom.Add([]byte("foo"))
+
+	// A version 1 header does not commit to a logs bloom
+	assert.NotNil(t, bc.AddBlock(newBlock(HeaderVersion1, bloom)))
+	assert.Nil(t, bc.AddBlock(newBlock(HeaderVersion1, types.Bloom{})))
+
+	assert.NotNil(t, bc.AddBlock(newBlock(HeaderVersion+1, types.Bloom{})))
+	assert.Nil(t, bc.AddBlock(newBlock(HeaderVersion2, types.Bloom{})))
+
+	// A version 2 block can not carry a commit
+	b := newBlock(HeaderVersion2, types.Bloom{})
+	b.Commit = goldenBlockV3().Commit
+	assert.NotNil(t, bc.AddBlock(b))
+	assert.Nil(t, bc.AddBlock(newBlock(HeaderVersion3, types.Bloom{})))
+
+	// The version never goes back
+	assert.NotNil(t, bc.AddBlock(newBlock(HeaderVersion2, types.Bloom{})))
+	assert.Equal(t, uint32(3), bc.Height())
+}
+
+func TestGetProofs(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+	tx := NewTransaction([]byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f})
+	assert.Nil(t, tx.Sign(privKey))
+
+	b := addBlockWithTxx(t, bc, privKey, tx, randomTxWithSignature(t))
+
+	txProof, err := bc.GetTxProof(1, tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, txProof.Verify(b.DataHash))
+
+	_, err = bc.GetTxProof(1, types.RandomHash())
+	assert.ErrorIs(t, err, ErrTxNotFound)
+
+	stateProof, err := bc.GetStateProof(1, StorageKey(types.Address{}, []byte("FOO")))
+	assert.Nil(t, err)
+	assert.Equal(t, NewWordValue(big.NewInt(5)).Serialize(), stateProof.Value)
+	assert.True(t, stateProof.Verify(b.StateRoot))
+
+	// The key did not exist before the block
+	_, err = bc.GetStateProof(0, StorageKey(types.Address{}, []byte("FOO")))
+	assert.NotNil(t, err)
+
+	headers, err := bc.GetHeaders(0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(headers))
+	assert.Nil(t, headers[1].Verify())
+}
+
+func TestAddBlockOutOfGasReverts(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	// push 5, byte 'F', byte 'O', byte 'O', push 3, pack, store
+	code := []byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
+
+	txOutOfGas := NewTransaction(code)
+	txOutOfGas.GasLimit = 50
+	assert.Nil(t, txOutOfGas.Sign(privKey))
+
+	b := addBlockWithTxx(t, bc, privKey, txOutOfGas)
+	assert.Equal(t, types.Hash{}, b.StateRoot)
+
+	receipts, err := bc.GetReceipts(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(receipts))
+	assert.Equal(t, uint64(50), receipts[0].GasUsed)
+	assert.Equal(t, ErrOutOfGas.Error(), receipts[0].Err)
+
+	tx := NewTransaction(code)
+	assert.Nil(t, tx.Sign(privKey))
+	addBlockWithTxx(t, bc, privKey, tx)
+
+	receipts, err = bc.GetReceipts(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "", receipts[0].Err)
+	assert.True(t, receipts[0].GasUsed > 50)
+	assert.True(t, receipts[0].GasUsed < DefaultTxGasLimit)
+}
+
+func TestAddBlockExceedsBlockGasLimit(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.SetBlockGasLimit(DefaultTxGasLimit)
+
+	prevHeader, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+
+	b, err := NewBlockFromPrevHeader(prevHeader, []*Transaction{randomTxWithSignature(t), randomTxWithSignature(t)})
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+
+	_, _, err = bc.ExecuteBlock(b)
+	assert.NotNil(t, err)
+	assert.NotNil(t, bc.AddBlock(b))
+}
+
+func TestAddBlockSignatureAlgorithms(t *testing.T) {
+	edKey, err := crypto.GenerateKey(crypto.Ed25519)
+	assert.Nil(t, err)
+	k1Key, err := crypto.GenerateKey(crypto.Secp256k1)
+	assert.Nil(t, err)
+
+	tx := &Transaction{Data: []byte("foo")}
+	assert.Nil(t, tx.Sign(k1Key))
+
+	addBlock := func(algs ...crypto.Algorithm) error {
+		genesis, err := NewGenesisBlock(&Header{Version: HeaderVersion}, ChainParams{SignatureAlgorithms: algs})
+		assert.Nil(t, err)
+		bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+		assert.Nil(t, err)
+
+		b, err := NewBlockFromPrevHeader(genesis.Header, []*Transaction{tx})
+		assert.Nil(t, err)
+		state, _, err := bc.ExecuteBlock(b)
+		assert.Nil(t, err)
+		b.StateRoot = state.Root()
+		assert.Nil(t, b.Sign(edKey))
+		assert.Nil(t, b.Verify())
+
+		return bc.AddBlock(b)
+	}
+
+	// Only P-256 by default
+	assert.NotNil(t, addBlock())
+	assert.NotNil(t, addBlock(crypto.P256, crypto.Ed25519))
+	assert.Nil(t, addBlock(crypto.Ed25519, crypto.Secp256k1))
+}
+
+func TestAddBlockChainParamsTransaction(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	data, err := ChainParams{SignatureAlgorithms: crypto.Algorithms()}.Bytes()
+	assert.Nil(t, err)
+	tx := &Transaction{Type: TxTypeChainParams, Data: data}
+	assert.Nil(t, tx.Sign(privKey))
+
+	prevHeader, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+	b, err := NewBlockFromPrevHeader(prevHeader, []*Transaction{tx})
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKey))
+
+	assert.NotNil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(0), bc.Height())
+}
+
+func TestAddBlockCounterContract(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	code := []byte{
+		0x43, 0x0c, 0x01, 0x0a, 0x0d, // byte 'C', push 1, pack
+		0x1b,       // get
+		0x01, 0x0a, // push 1
+		0x0b,                         // add
+		0x43, 0x0c, 0x01, 0x0a, 0x0d, // byte 'C', push 1, pack
+		0x0f, // store
+	}
+
+	for i := 1; i <= 3; i++ {
+		tx := NewTransaction(code)
+		assert.Nil(t, tx.Sign(privKey))
+		addBlockWithTxx(t, bc, privKey, tx)
+
+		receipts, err := bc.GetReceipts(uint32(i))
+		assert.Nil(t, err)
+		assert.Equal(t, "", receipts[0].Err)
+
+		proof, err := bc.GetStateProof(uint32(i), StorageKey(types.Address{}, []byte("C")))
+		assert.Nil(t, err)
+		assert.Equal(t, NewWordValue(big.NewInt(int64(i))).Serialize(), proof.Value)
+	}
+}
+
+func TestAddBlockVMVersions(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	// Pops take the oldest value in legacy code: push 3, byte 'F', byte 'O',
+	// byte 'O', pack, push 5, store
+	legacyTx := NewTransaction([]byte{0x03, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x0d, 0x05, 0x0a, 0x0f})
+	legacyTx.VMVersion = VMVersionLegacy
+	assert.Nil(t, legacyTx.Sign(privKey))
+
+	unknownTx := NewTransaction([]byte{0x01, 0x0a})
+	unknownTx.VMVersion = 0xff
+	assert.Nil(t, unknownTx.Sign(privKey))
+
+	addBlockWithTxx(t, bc, privKey, legacyTx, unknownTx)
+
+	receipts, err := bc.GetReceipts(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "", receipts[0].Err)
+	assert.NotEqual(t, "", receipts[1].Err)
+
+	proof, err := bc.GetStateProof(1, StorageKey(types.Address{}, []byte("FOO")))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), deserializeInt64(proof.Value))
+}
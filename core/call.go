@@ -0,0 +1,139 @@
+package core
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// CallResult is the outcome of a transaction simulated by Call.
+type CallResult struct {
+	// ReturnData is the value left on top of the stack by the code.
+	ReturnData []byte
+	// StateDiff holds the keys of the state changed by the transaction,
+	// ordered by key. It is empty when the transaction fails.
+	StateDiff       []StateChange
+	Logs            []*Log
+	GasUsed         uint64
+	ContractAddress types.Address
+	Err             error
+}
+
+// StateChange is the change of the value of a key of the state, Before or
+// After is nil when the key did not or does not exist.
+type StateChange struct {
+	Key    []byte
+	Before []byte
+	After  []byte
+}
+
+// Call executes the transaction against a copy of the state after the block
+// at the given height, the chain is not modified. The signature of the
+// transaction is not verified so that it can be simulated before signing.
+func (bc *Blockchain) Call(tx *Transaction, height uint32) (*CallResult, error) {
+	base, err := bc.stateAt(height)
+	if err != nil {
+		return nil, err
+	}
+	state := base.Copy()
+
+	receipt, returnData, err := bc.executeTx(tx, state, nil)
+	for _, l := range receipt.Logs {
+		l.Height = height + 1
+	}
+
+	return &CallResult{
+		ReturnData:      returnData,
+		StateDiff:       state.changes(0),
+		Logs:            receipt.Logs,
+		GasUsed:         receipt.GasUsed,
+		ContractAddress: receipt.ContractAddress,
+		Err:             err,
+	}, nil
+}
+
+// withGasLimit returns a copy of the transaction with the given gas limit, the
+// cached hash of tx is not carried over since it is the hash of another
+// transaction.
+func withGasLimit(tx *Transaction, gasLimit uint64) *Transaction {
+	cp := *tx
+	cp.GasLimit = gasLimit
+	cp.hash = types.Hash{}
+	return &cp
+}
+
+// EstimateGas returns the lowest gas limit with which the transaction
+// succeeds against the state after the block at the given height, found by
+// binary search up to the block gas limit. It fails with the error of the
+// transaction when it does not succeed with the block gas limit.
+func (bc *Blockchain) EstimateGas(tx *Transaction, height uint32) (uint64, error) {
+	succeeds := func(gasLimit uint64) (bool, error) {
+		result, err := bc.Call(withGasLimit(tx, gasLimit), height)
+		if err != nil {
+			return false, err
+		}
+		if gasLimit == bc.blockGasLimit && result.Err != nil {
+			return false, result.Err
+		}
+
+		return result.Err == nil, nil
+	}
+
+	// The transaction fails with every gas limit below lo and succeeds with
+	// hi
+	lo, hi := uint64(1), bc.blockGasLimit
+	if _, err := succeeds(hi); err != nil {
+		return 0, err
+	}
+
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		ok, err := succeeds(mid)
+		if err != nil {
+			return 0, err
+		}
+
+		if ok {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	return hi, nil
+}
+
+// changes returns the keys changed since the snapshot, ordered by key.
+func (s *State) changes(snapshot int) []StateChange {
+	seen := map[string]bool{}
+	changes := []StateChange{}
+
+	for _, entry := range s.journal[snapshot:] {
+		if seen[entry.key] {
+			continue
+		}
+		seen[entry.key] = true
+
+		change := StateChange{Key: []byte(entry.key)}
+		if entry.existed {
+			change.Before = append([]byte{}, entry.prev...)
+		}
+		after, exists := s.data[entry.key]
+		if exists {
+			change.After = append([]byte{}, after...)
+		}
+
+		if entry.existed == exists && bytes.Equal(change.Before, change.After) {
+			continue
+		}
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return bytes.Compare(changes[i].Key, changes[j].Key) < 0
+	})
+
+	return changes
+}
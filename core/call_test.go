@@ -0,0 +1,128 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCall(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	addr := deployWithKey(t, bc, privKey, counterCode)
+	deployHeight := bc.Height()
+	assert.Equal(t, "", call(t, bc, privKey, addr, []byte{2}).Err)
+
+	root := bc.StateRoot()
+	key := StorageKey(addr, []byte("C"))
+
+	result, err := bc.Call(NewCallTransaction(addr, []byte{3}), bc.Height())
+	assert.Nil(t, err)
+	assert.Nil(t, result.Err)
+	assert.Greater(t, result.GasUsed, uint64(0))
+	assert.Equal(t, []StateChange{{
+		Key:    key,
+		Before: NewWordValue(big.NewInt(2)).Serialize(),
+		After:  NewWordValue(big.NewInt(5)).Serialize(),
+	}}, result.StateDiff)
+
+	// An older height executes against the state before the first call
+	result, err = bc.Call(NewCallTransaction(addr, []byte{3}), deployHeight)
+	assert.Nil(t, err)
+	assert.Equal(t, []StateChange{{
+		Key:   key,
+		After: NewWordValue(big.NewInt(3)).Serialize(),
+	}}, result.StateDiff)
+
+	assert.Equal(t, root, bc.StateRoot())
+	assert.Equal(t, "2", storedWord(t, bc, addr, "C"))
+
+	_, err = bc.Call(NewCallTransaction(addr, []byte{3}), bc.Height()+1)
+	assert.NotNil(t, err)
+}
+
+func TestCallReturnDataAndLogs(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	// push 2, push 3, add
+	result, err := bc.Call(NewTransaction([]byte{0x02, 0x0a, 0x03, 0x0a, 0x0b}), 0)
+	assert.Nil(t, err)
+	assert.Nil(t, result.Err)
+	assert.Equal(t, NewWordValue(big.NewInt(5)).Serialize(), result.ReturnData)
+	assert.Equal(t, []StateChange{}, result.StateDiff)
+
+	addr := deployWithKey(t, bc, privKey, logCode)
+
+	result, err = bc.Call(NewCallTransaction(addr, []byte{4}), bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(result.Logs))
+	assert.Equal(t, addr, result.Logs[0].Address)
+	assert.Equal(t, []byte{4}, result.Logs[0].Data)
+}
+
+func TestCallFails(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	result, err := bc.Call(NewCallTransaction(types.Address{0x01}, []byte{1}), 0)
+	assert.Nil(t, err)
+	assert.NotNil(t, result.Err)
+
+	// The write of a failing transaction is not part of the diff: push 1,
+	// byte 'K', push 1, pack, store, push 0, jump to 0xff
+	tx := NewTransaction([]byte{0x01, 0x0a, 0x4b, 0x0c, 0x01, 0x0a, 0x0d, 0x0f, 0xff, 0x0a, 0x19})
+	result, err = bc.Call(tx, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrInvalidJump, result.Err)
+	assert.Equal(t, []StateChange{}, result.StateDiff)
+}
+
+func TestWithGasLimitHash(t *testing.T) {
+	tx := NewCallTransaction(types.Address{1}, []byte{2})
+	hash := tx.Hash(TxHasher{})
+
+	cp := withGasLimit(tx, 1000)
+	assert.NotEqual(t, hash, cp.Hash(TxHasher{}))
+
+	expected := NewCallTransaction(types.Address{1}, []byte{2})
+	expected.GasLimit = 1000
+	assert.Equal(t, expected.Hash(TxHasher{}), cp.Hash(TxHasher{}))
+	assert.Equal(t, hash, tx.Hash(TxHasher{}))
+}
+
+func TestEstimateGas(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	addr := deployWithKey(t, bc, privKey, counterCode)
+
+	tx := NewCallTransaction(addr, []byte{2})
+	gas, err := bc.EstimateGas(tx, bc.Height())
+	assert.Nil(t, err)
+
+	result, err := bc.Call(tx, bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, result.GasUsed, gas)
+
+	tx.GasLimit = gas
+	result, err = bc.Call(tx, bc.Height())
+	assert.Nil(t, err)
+	assert.Nil(t, result.Err)
+
+	tx.GasLimit = gas - 1
+	result, err = bc.Call(tx, bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, ErrOutOfGas, result.Err)
+
+	// Deploying costs gas per byte of code
+	gas, err = bc.EstimateGas(NewDeployTransaction(counterCode), bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(len(counterCode))*gasDeployPerByte, gas)
+
+	_, err = bc.EstimateGas(NewCallTransaction(types.Address{0x01}, []byte{1}), bc.Height())
+	assert.NotNil(t, err)
+}
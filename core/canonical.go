@@ -0,0 +1,467 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// The canonical encoding is what block hashes and signatures are computed
+// over, it must never change for a given version of the types. All integers
+// are big endian.
+//
+//	header      = version(u32) dataHash(32) prevBlockHash(32) stateRoot(32)
+//	              logsBloom(256) timestamp(i64) height(u32), logsBloom only
+//	              from HeaderVersion2 so that version 1 headers keep their
+//	              hash
+//	bytes       = length(u32) data
+//	algorithm   = u8, 0 for P-256, 1 for secp256k1 and 2 for Ed25519, see
+//	              crypto.Algorithm
+//	publicKey   = length(u8) algorithm key, length is the length of key and
+//	              0 without algorithm and key when there is no key, an ECDSA
+//	              key is compressed
+//	signature   = present(u8) algorithm r(32) s(32) recoveryID(u8),
+//	              algorithm, r and s only when present is 1 or 2, recoveryID
+//	              only when present is 2 for a recoverable signature, see
+//	              crypto.Signature.RecoverableBytes, an ECDSA s is at most
+//	              half the order of the curve
+//	unsignedTx  = type(u8) to(20) vmVersion(u8) bytes(data) gasLimit(u64)
+//	transaction = unsignedTx publicKey(from) signature, from is empty when the
+//	              signature is recoverable
+//	block       = header txCount(u32) transaction* publicKey(validator) signature
+//	              commit, commit only from HeaderVersion3
+//	commit      = present(u8) bytes(signers) multiSignature(65), the rest only
+//	              when present is 1, see crypto.MultiSignature.Bytes
+//	chainParams = algorithmCount(u8) algorithm* validatorCount(u32)
+//	              (publicKey multiSignature(65))*, each validator with its
+//	              proof of possession, the data of the chain params
+//	              transaction of a genesis block
+//
+// Transactions are signed over the digest of unsignedTx, blocks and commits
+// over the digest of header, each in its own domain, see crypto.Digest. The
+// hash of a transaction is the sha256 of transaction, so the data hash of a
+// block commits to the senders and signatures along with the execution fields.
+const (
+	txSigningDomain     = "blockchain-from-scratch/tx/v1"
+	headerSigningDomain = "blockchain-from-scratch/header/v1"
+	commitSigningDomain = "blockchain-from-scratch/commit/v1"
+)
+
+const (
+	headerSize = 4 + 32 + 32 + 32 + types.BloomSize + 8 + 4
+
+	// maxCanonicalBytes bounds the length prefixes so that a malformed
+	// input can not make the decoder allocate an arbitrary amount of memory.
+	maxCanonicalBytes = 1 << 24
+)
+
+type canonicalWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (cw *canonicalWriter) write(b []byte) {
+	if cw.err != nil {
+		return
+	}
+	_, cw.err = cw.w.Write(b)
+}
+
+func (cw *canonicalWriter) writeUint8(v uint8) {
+	cw.write([]byte{v})
+}
+
+func (cw *canonicalWriter) writeUint32(v uint32) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, v)
+	cw.write(buf)
+}
+
+func (cw *canonicalWriter) writeUint64(v uint64) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	cw.write(buf)
+}
+
+func (cw *canonicalWriter) writeBytes(b []byte) {
+	if len(b) > maxCanonicalBytes {
+		cw.err = fmt.Errorf("canonical: byte slice of length %d is too long", len(b))
+		return
+	}
+	cw.writeUint32(uint32(len(b)))
+	cw.write(b)
+}
+
+func (cw *canonicalWriter) writeHeader(h *Header) {
+	cw.writeUint32(h.Version)
+	cw.write(h.DataHash[:])
+	cw.write(h.PrevBlockHash[:])
+	cw.write(h.StateRoot[:])
+	if h.HasLogsBloom() {
+		cw.write(h.LogsBloom[:])
+	}
+	cw.writeUint64(uint64(h.Timestamp))
+	cw.writeUint32(h.Height)
+}
+
+func (cw *canonicalWriter) writePublicKey(k crypto.PublicKey) {
+	if k.Key == nil {
+		cw.writeUint8(0)
+		return
+	}
+
+	b := k.ToSlice()
+	cw.writeUint8(uint8(len(b)))
+	cw.writeUint8(uint8(k.Algorithm))
+	cw.write(b)
+}
+
+func (cw *canonicalWriter) writeSignature(sig *crypto.Signature) {
+	if sig == nil {
+		cw.writeUint8(0)
+		return
+	}
+
+	present, encode := uint8(1), sig.Bytes
+	if sig.Recoverable() {
+		present, encode = 2, sig.RecoverableBytes
+	}
+
+	b, err := encode()
+	if err != nil {
+		cw.err = fmt.Errorf("canonical: %s", err)
+		return
+	}
+
+	cw.writeUint8(present)
+	cw.writeUint8(uint8(sig.Algorithm))
+	cw.write(b)
+}
+
+func (cw *canonicalWriter) writeUnsignedTx(tx *Transaction) {
+	cw.writeUint8(uint8(tx.Type))
+	cw.write(tx.To[:])
+	cw.writeUint8(tx.VMVersion)
+	cw.writeBytes(tx.Data)
+	cw.writeUint64(tx.GasLimit)
+}
+
+func (cw *canonicalWriter) writeTransaction(tx *Transaction) {
+	cw.writeUnsignedTx(tx)
+	if tx.Signature != nil && tx.Signature.Recoverable() {
+		// The sender is recovered from the signature when decoding
+		cw.writePublicKey(crypto.PublicKey{})
+	} else {
+		cw.writePublicKey(tx.From)
+	}
+	cw.writeSignature(tx.Signature)
+}
+
+func (cw *canonicalWriter) writeBlock(b *Block) {
+	if b.Header == nil {
+		cw.err = fmt.Errorf("canonical: block has no header")
+		return
+	}
+
+	cw.writeHeader(b.Header)
+	cw.writeUint32(uint32(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		cw.writeTransaction(tx)
+	}
+	cw.writePublicKey(b.Validator)
+	cw.writeSignature(b.Signature)
+	if b.HasCommitField() {
+		cw.writeCommit(b.Commit)
+	} else if b.Commit != nil {
+		cw.err = fmt.Errorf("canonical: block of version %d can not have a commit", b.Version)
+	}
+}
+
+func (cw *canonicalWriter) writeCommit(c *Commit) {
+	if c == nil {
+		cw.writeUint8(0)
+		return
+	}
+	if c.Signature == nil {
+		cw.err = fmt.Errorf("canonical: commit has no signature")
+		return
+	}
+
+	b, err := c.Signature.Bytes()
+	if err != nil {
+		cw.err = fmt.Errorf("canonical: %s", err)
+		return
+	}
+
+	cw.writeUint8(1)
+	cw.writeBytes(c.Signers)
+	cw.write(b)
+}
+
+func (cw *canonicalWriter) writeChainParams(p ChainParams) {
+	if len(p.SignatureAlgorithms) > 255 {
+		cw.err = fmt.Errorf("canonical: chain params have %d signature algorithms", len(p.SignatureAlgorithms))
+		return
+	}
+
+	if len(p.ValidatorProofs) != len(p.Validators) {
+		cw.err = fmt.Errorf("canonical: chain params have %d proofs of possession for %d validators", len(p.ValidatorProofs), len(p.Validators))
+		return
+	}
+
+	cw.writeUint8(uint8(len(p.SignatureAlgorithms)))
+	for _, alg := range p.SignatureAlgorithms {
+		cw.writeUint8(uint8(alg))
+	}
+
+	cw.writeUint32(uint32(len(p.Validators)))
+	for i, key := range p.Validators {
+		cw.writePublicKey(key)
+
+		if p.ValidatorProofs[i] == nil {
+			cw.err = fmt.Errorf("canonical: validator %d has no proof of possession", i)
+			return
+		}
+		b, err := p.ValidatorProofs[i].Bytes()
+		if err != nil {
+			cw.err = fmt.Errorf("canonical: %s", err)
+			return
+		}
+		cw.write(b)
+	}
+}
+
+type canonicalReader struct {
+	r   io.Reader
+	err error
+}
+
+func (cr *canonicalReader) read(n int) []byte {
+	if cr.err != nil {
+		return nil
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(cr.r, b); err != nil {
+		cr.err = err
+		return nil
+	}
+
+	return b
+}
+
+func (cr *canonicalReader) readUint8() uint8 {
+	b := cr.read(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (cr *canonicalReader) readUint32() uint32 {
+	b := cr.read(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (cr *canonicalReader) readUint64() uint64 {
+	b := cr.read(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (cr *canonicalReader) readHash() types.Hash {
+	b := cr.read(32)
+	if b == nil {
+		return types.Hash{}
+	}
+	return types.HashFromBytes(b)
+}
+
+func (cr *canonicalReader) readAddress() types.Address {
+	b := cr.read(20)
+	if b == nil {
+		return types.Address{}
+	}
+	return types.AddressFromBytes(b)
+}
+
+func (cr *canonicalReader) readBytes() []byte {
+	n := cr.readUint32()
+	if cr.err != nil {
+		return nil
+	}
+	if n > maxCanonicalBytes {
+		cr.err = fmt.Errorf("canonical: byte slice of length %d is too long", n)
+		return nil
+	}
+	return cr.read(int(n))
+}
+
+func (cr *canonicalReader) readHeader(h *Header) {
+	h.Version = cr.readUint32()
+	h.DataHash = cr.readHash()
+	h.PrevBlockHash = cr.readHash()
+	h.StateRoot = cr.readHash()
+	if h.HasLogsBloom() {
+		copy(h.LogsBloom[:], cr.read(types.BloomSize))
+	}
+	h.Timestamp = int64(cr.readUint64())
+	h.Height = cr.readUint32()
+}
+
+func (cr *canonicalReader) readPublicKey() crypto.PublicKey {
+	n := cr.readUint8()
+	if n == 0 || cr.err != nil {
+		return crypto.PublicKey{}
+	}
+
+	alg := crypto.Algorithm(cr.readUint8())
+	b := cr.read(int(n))
+	if cr.err != nil {
+		return crypto.PublicKey{}
+	}
+
+	key, err := crypto.ParsePublicKey(alg, b)
+	if err != nil {
+		cr.err = fmt.Errorf("canonical: %s", err)
+	}
+
+	return key
+}
+
+func (cr *canonicalReader) readSignature() *crypto.Signature {
+	present := cr.readUint8()
+	switch {
+	case cr.err != nil || present == 0:
+		return nil
+	case present != 1 && present != 2:
+		cr.err = fmt.Errorf("canonical: invalid signature flag %x", present)
+		return nil
+	}
+
+	alg := crypto.Algorithm(cr.readUint8())
+	if present == 2 {
+		b := cr.read(crypto.RecoverableSignatureSize)
+		if cr.err != nil {
+			return nil
+		}
+
+		sig, err := crypto.ParseRecoverableSignature(alg, b)
+		if err != nil {
+			cr.err = fmt.Errorf("canonical: %s", err)
+			return nil
+		}
+		return sig
+	}
+
+	b := cr.read(crypto.SignatureSize)
+	if cr.err != nil {
+		return nil
+	}
+
+	sig, err := crypto.ParseSignature(alg, b)
+	if err != nil {
+		cr.err = fmt.Errorf("canonical: %s", err)
+		return nil
+	}
+
+	return sig
+}
+
+func (cr *canonicalReader) readTransaction(tx *Transaction) {
+	tx.Type = TxType(cr.readUint8())
+	tx.To = cr.readAddress()
+	tx.VMVersion = cr.readUint8()
+	tx.Data = cr.readBytes()
+	tx.GasLimit = cr.readUint64()
+	tx.From = cr.readPublicKey()
+	tx.Signature = cr.readSignature()
+
+	// A transaction whose sender can not be recovered is left without one,
+	// it fails to verify.
+	if cr.err == nil && tx.From.Key == nil && tx.Signature != nil && tx.Signature.Recoverable() {
+		if key, err := tx.SenderKey(); err == nil {
+			tx.From = key
+		}
+	}
+}
+
+func (cr *canonicalReader) readBlock(b *Block) {
+	b.Header = new(Header)
+	cr.readHeader(b.Header)
+
+	n := cr.readUint32()
+	b.Transactions = []*Transaction{}
+	for i := uint32(0); i < n && cr.err == nil; i++ {
+		tx := new(Transaction)
+		cr.readTransaction(tx)
+		b.Transactions = append(b.Transactions, tx)
+	}
+
+	b.Validator = cr.readPublicKey()
+	b.Signature = cr.readSignature()
+	if b.HasCommitField() {
+		b.Commit = cr.readCommit()
+	}
+}
+
+func (cr *canonicalReader) readCommit() *Commit {
+	present := cr.readUint8()
+	switch {
+	case cr.err != nil || present == 0:
+		return nil
+	case present != 1:
+		cr.err = fmt.Errorf("canonical: invalid commit flag %x", present)
+		return nil
+	}
+
+	signers := cr.readBytes()
+	b := cr.read(crypto.MultiSignatureSize)
+	if cr.err != nil {
+		return nil
+	}
+
+	sig, err := crypto.MultiSignatureFromBytes(b)
+	if err != nil {
+		cr.err = fmt.Errorf("canonical: %s", err)
+		return nil
+	}
+
+	return &Commit{Signers: signers, Signature: sig}
+}
+
+func (cr *canonicalReader) readChainParams() ChainParams {
+	p := ChainParams{}
+
+	n := cr.readUint8()
+	for i := 0; i < int(n) && cr.err == nil; i++ {
+		p.SignatureAlgorithms = append(p.SignatureAlgorithms, crypto.Algorithm(cr.readUint8()))
+	}
+
+	validators := cr.readUint32()
+	for i := uint32(0); i < validators && cr.err == nil; i++ {
+		key := cr.readPublicKey()
+		b := cr.read(crypto.MultiSignatureSize)
+		if cr.err != nil {
+			break
+		}
+
+		proof, err := crypto.MultiSignatureFromBytes(b)
+		if err != nil {
+			cr.err = fmt.Errorf("canonical: %s", err)
+			break
+		}
+		p.Validators = append(p.Validators, key)
+		p.ValidatorProofs = append(p.ValidatorProofs, proof)
+	}
+
+	return p
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// SignerBitmap tells which validators of a ValidatorSet signed a commit, bit
+// i of byte i/8 is set when the validator at index i signed.
+type SignerBitmap []byte
+
+func NewSignerBitmap(n int) SignerBitmap {
+	return make(SignerBitmap, (n+7)/8)
+}
+
+func (bm SignerBitmap) Set(i int) {
+	bm[i/8] |= 1 << uint(i%8)
+}
+
+func (bm SignerBitmap) Has(i int) bool {
+	return i/8 < len(bm) && bm[i/8]&(1<<uint(i%8)) != 0
+}
+
+// Count returns the number of signers.
+func (bm SignerBitmap) Count() int {
+	count := 0
+	for i := 0; i < 8*len(bm); i++ {
+		if bm.Has(i) {
+			count++
+		}
+	}
+	return count
+}
+
+// ValidatorSet is the ordered set of validators whose commits finalize blocks.
+type ValidatorSet struct {
+	keys []crypto.PublicKey
+}
+
+// NewValidatorSet returns the set of the keys, each with the proof of
+// possession of its private key, see crypto.ProvePossession.
+func NewValidatorSet(keys []crypto.PublicKey, proofs []*crypto.MultiSignature) (*ValidatorSet, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("validator set has no validator")
+	}
+	if len(proofs) != len(keys) {
+		return nil, fmt.Errorf("given %d proofs of possession for %d validators", len(proofs), len(keys))
+	}
+
+	for i, key := range keys {
+		if !crypto.VerifyPossession(key, proofs[i]) {
+			return nil, fmt.Errorf("invalid proof of possession of validator %d (%s)", i, key.Address())
+		}
+	}
+
+	return &ValidatorSet{keys: append([]crypto.PublicKey{}, keys...)}, nil
+}
+
+func (vs *ValidatorSet) Len() int {
+	return len(vs.keys)
+}
+
+func (vs *ValidatorSet) Key(i int) crypto.PublicKey {
+	return vs.keys[i]
+}
+
+// Quorum returns the minimum number of signers of a commit, more than two
+// thirds of the validators.
+func (vs *ValidatorSet) Quorum() int {
+	return 2*len(vs.keys)/3 + 1
+}
+
+// AggregateKey returns the aggregated key of the signers, the key their
+// commits verify against.
+func (vs *ValidatorSet) AggregateKey(signers SignerBitmap) (crypto.PublicKey, error) {
+	if len(signers) != len(NewSignerBitmap(len(vs.keys))) {
+		return crypto.PublicKey{}, fmt.Errorf("signer bitmap of length %d for %d validators", len(signers), len(vs.keys))
+	}
+
+	keys := []crypto.PublicKey{}
+	for i := 0; i < 8*len(signers); i++ {
+		if !signers.Has(i) {
+			continue
+		}
+		if i >= len(vs.keys) {
+			return crypto.PublicKey{}, fmt.Errorf("signer %d is not a validator", i)
+		}
+		keys = append(keys, vs.keys[i])
+	}
+
+	return crypto.AggregatePublicKeys(keys)
+}
+
+// Commit is the multi-signature of the header of a block by a quorum of the
+// validators.
+type Commit struct {
+	Signers   SignerBitmap
+	Signature *crypto.MultiSignature
+}
+
+// CommitDigest returns the digest the validators sign to commit the block.
+func (h *Header) CommitDigest() types.Hash {
+	return crypto.Digest(commitSigningDomain, h.Bytes())
+}
+
+// Verify verifies the commit of the header with one signature verification,
+// against the aggregated key of the signers.
+func (c *Commit) Verify(vs *ValidatorSet, h *Header) error {
+	if c.Signature == nil {
+		return fmt.Errorf("commit has no signature")
+	}
+	if count := c.Signers.Count(); count < vs.Quorum() {
+		return fmt.Errorf("commit has %d signers, the quorum is %d", count, vs.Quorum())
+	}
+
+	key, err := vs.AggregateKey(c.Signers)
+	if err != nil {
+		return err
+	}
+
+	if !c.Signature.Verify(key, h.CommitDigest()) {
+		return fmt.Errorf("invalid commit signature")
+	}
+
+	return nil
+}
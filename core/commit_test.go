@@ -0,0 +1,215 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// newValidatorParams returns the chain params of n new validators.
+func newValidatorParams(t testing.TB, n int) (ChainParams, []crypto.PrivateKey) {
+	keys := make([]crypto.PrivateKey, n)
+	params := ChainParams{
+		Validators:      make([]crypto.PublicKey, n),
+		ValidatorProofs: make([]*crypto.MultiSignature, n),
+	}
+	for i := range keys {
+		keys[i] = crypto.GeneratePrivateKey()
+		params.Validators[i] = keys[i].PublicKey()
+
+		proof, err := crypto.ProvePossession(keys[i])
+		assert.Nil(t, err)
+		params.ValidatorProofs[i] = proof
+	}
+
+	return params, keys
+}
+
+func newValidatorSet(t testing.TB, n int) (*ValidatorSet, []crypto.PrivateKey) {
+	params, keys := newValidatorParams(t, n)
+
+	vs, err := NewValidatorSet(params.Validators, params.ValidatorProofs)
+	assert.Nil(t, err)
+
+	return vs, keys
+}
+
+// signCommit runs the rounds of the multi-signature of the header by the
+// validators at the given indexes.
+func signCommit(t testing.TB, vs *ValidatorSet, keys []crypto.PrivateKey, h *Header, signers ...int) *Commit {
+	bitmap := NewSignerBitmap(vs.Len())
+	nonces := make([]*crypto.MultiSigNonce, len(signers))
+	commitments := make([]types.Hash, len(signers))
+	points := make([][]byte, len(signers))
+	for i, signer := range signers {
+		bitmap.Set(signer)
+
+		nonce, err := crypto.NewMultiSigNonce()
+		assert.Nil(t, err)
+		nonces[i] = nonce
+		commitments[i] = nonce.Commitment()
+		points[i] = nonce.Point()
+	}
+
+	aggKey, err := vs.AggregateKey(bitmap)
+	assert.Nil(t, err)
+	aggNonce, err := crypto.AggregateNonces(commitments, points)
+	assert.Nil(t, err)
+
+	partials := make([]*big.Int, len(signers))
+	for i, signer := range signers {
+		partials[i], err = keys[signer].SignMulti(nonces[i], aggNonce, aggKey, h.CommitDigest())
+		assert.Nil(t, err)
+	}
+
+	sig, err := crypto.AggregateSignatures(aggNonce, partials)
+	assert.Nil(t, err)
+
+	return &Commit{Signers: bitmap, Signature: sig}
+}
+
+func TestSignerBitmap(t *testing.T) {
+	bm := NewSignerBitmap(10)
+	assert.Len(t, bm, 2)
+
+	bm.Set(0)
+	bm.Set(9)
+	assert.True(t, bm.Has(0))
+	assert.True(t, bm.Has(9))
+	assert.False(t, bm.Has(1))
+	assert.False(t, bm.Has(16))
+	assert.Equal(t, 2, bm.Count())
+}
+
+func TestNewValidatorSetProofOfPossession(t *testing.T) {
+	vs, keys := newValidatorSet(t, 2)
+	assert.Equal(t, 2, vs.Len())
+
+	proof, err := crypto.ProvePossession(keys[0])
+	assert.Nil(t, err)
+
+	// A key with the proof of another
+	_, err = NewValidatorSet([]crypto.PublicKey{keys[0].PublicKey(), keys[1].PublicKey()}, []*crypto.MultiSignature{proof, proof})
+	assert.NotNil(t, err)
+}
+
+func TestCommitVerify(t *testing.T) {
+	vs, keys := newValidatorSet(t, 4)
+	assert.Equal(t, 3, vs.Quorum())
+	h := goldenHeader()
+
+	commit := signCommit(t, vs, keys, h, 0, 1, 3)
+	assert.Nil(t, commit.Verify(vs, h))
+
+	other := goldenHeader()
+	other.Height++
+	assert.NotNil(t, commit.Verify(vs, other))
+
+	// The bitmap must name the signers
+	tampered := &Commit{Signers: NewSignerBitmap(4), Signature: commit.Signature}
+	for _, i := range []int{0, 1, 2} {
+		tampered.Signers.Set(i)
+	}
+	assert.NotNil(t, tampered.Verify(vs, h))
+
+	// Below the quorum
+	assert.NotNil(t, signCommit(t, vs, keys, h, 0, 1).Verify(vs, h))
+
+	// A signer out of the set
+	outside := &Commit{Signers: SignerBitmap{0x1b}, Signature: commit.Signature}
+	assert.NotNil(t, outside.Verify(vs, h))
+}
+
+func TestAddBlockWithCommit(t *testing.T) {
+	params, keys := newValidatorParams(t, 4)
+	genesis, err := NewGenesisBlock(&Header{Version: HeaderVersion}, params)
+	assert.Nil(t, err)
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+	vs := bc.ValidatorSet()
+	assert.Equal(t, 4, vs.Len())
+
+	b, err := NewBlockFromPrevHeader(genesis.Header, []*Transaction{randomTxWithSignature(t)})
+	assert.Nil(t, err)
+	state, _, err := bc.ExecuteBlock(b)
+	assert.Nil(t, err)
+	b.StateRoot = state.Root()
+	assert.Nil(t, b.Sign(keys[0]))
+
+	assert.NotNil(t, bc.AddBlock(b))
+
+	b.Commit = signCommit(t, vs, keys, b.Header, 0, 1)
+	assert.NotNil(t, bc.AddBlock(b))
+
+	b.Commit = signCommit(t, vs, keys, b.Header, 1, 2, 3)
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, b.Encode(NewCanonicalBlockEncoder(buf)))
+	decoded := new(Block)
+	assert.Nil(t, decoded.Decode(NewCanonicalBlockDecoder(buf)))
+	assert.Equal(t, b.Commit, decoded.Commit)
+
+	assert.Nil(t, bc.AddBlock(decoded))
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+var benchmarkValidators = []int{4, 16, 64}
+
+// BenchmarkVerifyCommit verifies the commit of all the validators, one
+// signature verification against the aggregated key.
+func BenchmarkVerifyCommit(b *testing.B) {
+	h := goldenHeader()
+
+	for _, n := range benchmarkValidators {
+		vs, keys := newValidatorSet(b, n)
+		signers := make([]int, n)
+		for i := range signers {
+			signers[i] = i
+		}
+		commit := signCommit(b, vs, keys, h, signers...)
+
+		b.Run(fmt.Sprintf("N=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := commit.Verify(vs, h); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkVerifyECDSASignatures verifies one ECDSA signature of the header
+// by each validator, the cost of a commit without aggregation.
+func BenchmarkVerifyECDSASignatures(b *testing.B) {
+	h := goldenHeader()
+	digest := h.CommitDigest()
+
+	for _, n := range benchmarkValidators {
+		pubKeys := make([]crypto.PublicKey, n)
+		sigs := make([]*crypto.Signature, n)
+		for i := range sigs {
+			k := crypto.GeneratePrivateKey()
+			pubKeys[i] = k.PublicKey()
+
+			sig, err := k.SignDigest(digest)
+			assert.Nil(b, err)
+			sigs[i] = sig
+		}
+
+		b.Run(fmt.Sprintf("N=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j, sig := range sigs {
+					if !sig.VerifyDigest(pubKeys[j], digest) {
+						b.Fatal("invalid signature")
+					}
+				}
+			}
+		})
+	}
+}
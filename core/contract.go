@@ -0,0 +1,152 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+type TxType uint8
+
+const (
+	// TxTypeScript runs Data as code against the storage of the zero
+	// address, it is the type of the transactions from before contracts.
+	TxTypeScript TxType = 0
+	// TxTypeDeploy stores Data as the code of a new contract.
+	TxTypeDeploy TxType = 1
+	// TxTypeCall runs the code of the contract To with Data as call data.
+	TxTypeCall TxType = 2
+	// TxTypeChainParams holds the ChainParams of the chain in Data, it is
+	// only allowed in the genesis block and is never executed.
+	TxTypeChainParams TxType = 3
+)
+
+// Every key of the state belongs to an address, the code of a contract, its
+// storage and the deploy nonce of a sender are kept under a different prefix
+// so that a contract can never overwrite code or nonces.
+const (
+	codeKeyPrefix    byte = 0x01
+	storageKeyPrefix byte = 0x02
+	nonceKeyPrefix   byte = 0x03
+)
+
+// gasDeployPerByte is paid for every byte of deployed code
+const gasDeployPerByte = gasStorePerByte
+
+// ContractAddress returns the address of the contract deployed by the
+// deployer with the given nonce, the number of contracts it deployed before.
+func ContractAddress(deployer types.Address, nonce uint64) types.Address {
+	buf := make([]byte, len(deployer)+8)
+	copy(buf, deployer.ToSlice())
+	binary.BigEndian.PutUint64(buf[len(deployer):], nonce)
+	h := sha256.Sum256(buf)
+
+	return types.AddressFromBytes(h[len(h)-20:])
+}
+
+// NonceKey returns the key of the deploy nonce of the address in the state,
+// the nonce is stored as a big endian uint64.
+func NonceKey(addr types.Address) []byte {
+	return append([]byte{nonceKeyPrefix}, addr.ToSlice()...)
+}
+
+// DeployNonce returns the number of contracts deployed by the address.
+func DeployNonce(state *State, addr types.Address) uint64 {
+	b, err := state.Get(NonceKey(addr))
+	if err != nil || len(b) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// CodeKey returns the key of the code of the contract in the state.
+func CodeKey(addr types.Address) []byte {
+	return append([]byte{codeKeyPrefix}, addr.ToSlice()...)
+}
+
+// StorageKey returns the key in the state of the key of the contract storage.
+func StorageKey(addr types.Address, key []byte) []byte {
+	buf := make([]byte, 0, 1+len(addr)+len(key))
+	buf = append(buf, storageKeyPrefix)
+	buf = append(buf, addr.ToSlice()...)
+
+	return append(buf, key...)
+}
+
+// contractStorage is the storage of one contract, it is the only part of the
+// state its code can read and write.
+type contractStorage struct {
+	state *State
+	addr  types.Address
+}
+
+func (s contractStorage) Put(k, v []byte) error {
+	return s.state.Put(StorageKey(s.addr, k), v)
+}
+
+func (s contractStorage) Get(k []byte) ([]byte, error) {
+	return s.state.Get(StorageKey(s.addr, k))
+}
+
+func (s contractStorage) Delete(k []byte) error {
+	return s.state.Delete(StorageKey(s.addr, k))
+}
+
+// deployContract stores the code of a deploy transaction at the address
+// derived from the sender and its deploy nonce, then increments the nonce.
+// The code is prefixed with its VM version.
+func deployContract(tx *Transaction, state *State) (types.Address, uint64, error) {
+	if len(tx.Data) == 0 {
+		return types.Address{}, 0, ErrEmptyCode
+	}
+
+	gasUsed := uint64(len(tx.Data)) * gasDeployPerByte
+	if gasUsed > tx.Gas() {
+		return types.Address{}, tx.Gas(), ErrOutOfGas
+	}
+
+	sender := tx.Sender()
+	nonce := DeployNonce(state, sender)
+	addr := ContractAddress(sender, nonce)
+	if _, err := state.Get(CodeKey(addr)); err == nil {
+		return types.Address{}, gasUsed, fmt.Errorf("contract (%s) already deployed", addr)
+	}
+
+	code := append([]byte{tx.VMVersion}, tx.Data...)
+	if err := state.Put(CodeKey(addr), code); err != nil {
+		return types.Address{}, gasUsed, err
+	}
+
+	next := make([]byte, 8)
+	binary.BigEndian.PutUint64(next, nonce+1)
+	if err := state.Put(NonceKey(sender), next); err != nil {
+		return types.Address{}, gasUsed, err
+	}
+
+	return addr, gasUsed, nil
+}
+
+// callContract runs the code of the contract To of the transaction, or the
+// precompiled contract at To, with the data of the transaction as call data.
+func callContract(executor Executor, tx *Transaction, state *State, tracer Tracer) (ExecutionResult, error) {
+	if p, ok := Precompile(tx.To); ok {
+		return runPrecompile(p, tx.Data, tx.Gas())
+	}
+
+	code, err := state.Get(CodeKey(tx.To))
+	if err != nil {
+		return ExecutionResult{}, fmt.Errorf("no contract at address (%s)", tx.To)
+	}
+
+	return executor.Execute(&Execution{
+		VMVersion: code[0],
+		Code:      code[1:],
+		CallData:  tx.Data,
+		Address:   tx.To,
+		State:     state,
+		GasLimit:  tx.Gas(),
+		Tracer:    tracer,
+	})
+}
@@ -0,0 +1,340 @@
+package core
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// counterCode adds the call data to the word stored under C
+var counterCode = []byte{
+	0x43, 0x0c, 0x01, 0x0a, 0x0d, // byte 'C', push 1, pack
+	0x1b,                         // get
+	0x1e,                         // calldata
+	0x0b,                         // add
+	0x43, 0x0c, 0x01, 0x0a, 0x0d, // byte 'C', push 1, pack
+	0x0f, // store
+}
+
+func deployWithKey(t *testing.T, bc *Blockchain, privKey crypto.PrivateKey, code []byte) types.Address {
+	tx := NewDeployTransaction(code)
+	assert.Nil(t, tx.Sign(privKey))
+	addBlockWithTxx(t, bc, privKey, tx)
+
+	receipts, err := bc.GetReceipts(bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, "", receipts[0].Err)
+	// The nonce after the deploy is one past the nonce of the contract
+	sender := privKey.PublicKey().Address()
+	proof, err := bc.GetStateProof(bc.Height(), NonceKey(sender))
+	assert.Nil(t, err)
+	nonce := binary.BigEndian.Uint64(proof.Value)
+	assert.Equal(t, ContractAddress(sender, nonce-1), receipts[0].ContractAddress)
+
+	return receipts[0].ContractAddress
+}
+
+func call(t *testing.T, bc *Blockchain, privKey crypto.PrivateKey, to types.Address, callData []byte) *Receipt {
+	tx := NewCallTransaction(to, callData)
+	assert.Nil(t, tx.Sign(privKey))
+	addBlockWithTxx(t, bc, privKey, tx)
+
+	receipts, err := bc.GetReceipts(bc.Height())
+	assert.Nil(t, err)
+	return receipts[0]
+}
+
+func storedWord(t *testing.T, bc *Blockchain, addr types.Address, key string) string {
+	proof, err := bc.GetStateProof(bc.Height(), StorageKey(addr, []byte(key)))
+	assert.Nil(t, err)
+	return mustWord(t, NewBytesValue(proof.Value)).String()
+}
+
+func TestContractAddress(t *testing.T) {
+	deployer := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	assert.Equal(t, ContractAddress(deployer, 0), ContractAddress(deployer, 0))
+	assert.NotEqual(t, ContractAddress(deployer, 0), ContractAddress(deployer, 1))
+	assert.NotEqual(t, ContractAddress(deployer, 0), ContractAddress(types.Address{}, 0))
+}
+
+func TestRedeployContract(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	first := deployWithKey(t, bc, privKey, counterCode)
+	second := deployWithKey(t, bc, privKey, counterCode)
+	assert.NotEqual(t, first, second)
+
+	sender := privKey.PublicKey().Address()
+	assert.Equal(t, ContractAddress(sender, 0), first)
+	assert.Equal(t, ContractAddress(sender, 1), second)
+
+	// Both contracts have their own storage
+	assert.Equal(t, "", call(t, bc, privKey, first, []byte{0x02}).Err)
+	assert.Equal(t, "2", storedWord(t, bc, first, "C"))
+	assert.Equal(t, "", call(t, bc, privKey, second, []byte{0x05}).Err)
+	assert.Equal(t, "5", storedWord(t, bc, second, "C"))
+	assert.Equal(t, "2", storedWord(t, bc, first, "C"))
+}
+
+func TestDeployAndCallContract(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	addr := deployWithKey(t, bc, privKey, counterCode)
+
+	proof, err := bc.GetStateProof(bc.Height(), CodeKey(addr))
+	assert.Nil(t, err)
+	assert.Equal(t, append([]byte{LatestVMVersion}, counterCode...), proof.Value)
+
+	assert.Equal(t, "", call(t, bc, privKey, addr, []byte{2}).Err)
+	assert.Equal(t, "2", storedWord(t, bc, addr, "C"))
+
+	assert.Equal(t, "", call(t, bc, privKey, addr, []byte{3}).Err)
+	assert.Equal(t, "5", storedWord(t, bc, addr, "C"))
+}
+
+func TestContractStorageIsIsolated(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	// The same code deployed twice lives at two addresses
+	addrA := deployWithKey(t, bc, privKey, counterCode)
+	addrB := deployWithKey(t, bc, crypto.GeneratePrivateKey(), counterCode)
+	assert.NotEqual(t, addrA, addrB)
+
+	call(t, bc, privKey, addrA, []byte{1})
+	call(t, bc, privKey, addrB, []byte{7})
+
+	assert.Equal(t, "1", storedWord(t, bc, addrA, "C"))
+	assert.Equal(t, "7", storedWord(t, bc, addrB, "C"))
+
+	// A script writes to the storage of the zero address
+	script := NewTransaction(counterCode)
+	assert.Nil(t, script.Sign(privKey))
+	addBlockWithTxx(t, bc, privKey, script)
+
+	assert.Equal(t, "1", storedWord(t, bc, addrA, "C"))
+	assert.Equal(t, "0", storedWord(t, bc, types.Address{}, "C"))
+}
+
+func TestCallContractErrors(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	// No contract at the address
+	receipt := call(t, bc, privKey, types.Address{}, []byte{1})
+	assert.NotEqual(t, "", receipt.Err)
+
+	// Deploying no code does not use up the nonce
+	tx := NewDeployTransaction(counterCode)
+	assert.Nil(t, tx.Sign(privKey))
+	empty := NewDeployTransaction(nil)
+	assert.Nil(t, empty.Sign(privKey))
+	addBlockWithTxx(t, bc, privKey, empty, tx)
+
+	receipts, err := bc.GetReceipts(bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, ErrEmptyCode.Error(), receipts[0].Err)
+	assert.Equal(t, types.Address{}, receipts[0].ContractAddress)
+	assert.Equal(t, "", receipts[1].Err)
+	assert.Equal(t, ContractAddress(privKey.PublicKey().Address(), 0), receipts[1].ContractAddress)
+
+	// A call that fails reverts its writes
+	addr := receipts[1].ContractAddress
+	call(t, bc, privKey, addr, []byte{1})
+
+	receipt = call(t, bc, privKey, addr, make([]byte, 33))
+	assert.Equal(t, ErrTypeMismatch.Error(), receipt.Err)
+	assert.Equal(t, "1", storedWord(t, bc, addr, "C"))
+
+}
+
+// callerCode calls the contract at addr with the call data {5} and the gas
+// pushed by gasCode, then stores the success of the call under S and the
+// returned data under R.
+func callerCode(addr types.Address, gasCode ...byte) []byte {
+	code := []byte{}
+	for _, b := range addr {
+		code = append(code, b, 0x0c) // byte b
+	}
+	code = append(code, 0x14, 0x0a, 0x0d)             // push 20, pack
+	code = append(code, 0x05, 0x0c, 0x01, 0x0a, 0x0d) // byte 5, push 1, pack
+	code = append(code, gasCode...)
+	code = append(code,
+		0x22,                         // call
+		0x53, 0x0c, 0x01, 0x0a, 0x0d, // byte 'S', push 1, pack
+		0x0f,                         // store
+		0x52, 0x0c, 0x01, 0x0a, 0x0d, // byte 'R', push 1, pack
+		0x0f, // store
+	)
+
+	return code
+}
+
+// allGas pushes more gas than a transaction has
+var allGas = []byte{0xff, 0x0a, 0xff, 0x0a, 0x10, 0xff, 0x0a, 0x10} // push 255, push 255, mul, push 255, mul
+
+// revertCode stores 1 under X and reverts with E
+var revertCode = []byte{
+	0x01, 0x0a, // push 1
+	0x58, 0x0c, 0x01, 0x0a, 0x0d, // byte 'X', push 1, pack
+	0x0f,                         // store
+	0x45, 0x0c, 0x01, 0x0a, 0x0d, // byte 'E', push 1, pack
+	0x24, // revert
+}
+
+func storedBytes(t *testing.T, bc *Blockchain, addr types.Address, key string) []byte {
+	proof, err := bc.GetStateProof(bc.Height(), StorageKey(addr, []byte(key)))
+	assert.Nil(t, err)
+	return proof.Value
+}
+
+func TestCallBetweenContracts(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	// calldata, push 1, add, return
+	adder := deployWithKey(t, bc, privKey, []byte{0x1e, 0x01, 0x0a, 0x0b, 0x23})
+	caller := deployWithKey(t, bc, privKey, callerCode(adder, allGas...))
+
+	receipt := call(t, bc, privKey, caller, []byte{1})
+	assert.Equal(t, "", receipt.Err)
+	assert.Equal(t, "1", storedWord(t, bc, caller, "S"))
+	assert.Equal(t, "6", storedWord(t, bc, caller, "R"))
+
+	// The steps of the callee are traced one call deeper
+	tracer := NewStepLogger()
+	_, err := bc.TraceTransaction(receipt.TxHash, tracer)
+	assert.Nil(t, err)
+
+	depths := map[int]int{}
+	for _, step := range tracer.Trace().Steps {
+		depths[step.Depth]++
+	}
+	assert.Equal(t, 4, depths[1])
+	assert.Equal(t, receipt.GasUsed, tracer.Trace().GasUsed)
+}
+
+func TestCallRevert(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	reverter := deployWithKey(t, bc, privKey, revertCode)
+	caller := deployWithKey(t, bc, privKey, callerCode(reverter, allGas...))
+
+	// The caller continues with the revert data, the write of the callee is
+	// rolled back
+	assert.Equal(t, "", call(t, bc, privKey, caller, []byte{1}).Err)
+	assert.Equal(t, "0", storedWord(t, bc, caller, "S"))
+	assert.Equal(t, []byte("E"), storedBytes(t, bc, caller, "R"))
+
+	_, err := bc.GetStateProof(bc.Height(), StorageKey(reverter, []byte("X")))
+	assert.NotNil(t, err)
+
+	// A transaction that reverts fails with the revert data as return data
+	receipt := call(t, bc, privKey, reverter, []byte{1})
+	assert.Equal(t, ErrReverted.Error(), receipt.Err)
+
+	result, err := bc.Call(NewCallTransaction(reverter, []byte{1}), bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, ErrReverted, result.Err)
+	assert.Equal(t, []byte("E"), result.ReturnData)
+}
+
+func TestCallGasAndMissingContract(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	// The callee runs out of the 20 gas it is given, the caller pays them
+	reverter := deployWithKey(t, bc, privKey, revertCode)
+	caller := deployWithKey(t, bc, privKey, callerCode(reverter, 0x14, 0x0a)) // push 20
+
+	receipt := call(t, bc, privKey, caller, []byte{1})
+	assert.Equal(t, "", receipt.Err)
+	assert.Equal(t, "0", storedWord(t, bc, caller, "S"))
+	assert.Equal(t, []byte{}, storedBytes(t, bc, caller, "R"))
+
+	result, err := bc.Call(NewCallTransaction(caller, []byte{1}), bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, receipt.GasUsed, result.GasUsed)
+
+	// A call to an address without contract fails
+	caller = deployWithKey(t, bc, privKey, callerCode(types.Address{0x01}, allGas...))
+	assert.Equal(t, "", call(t, bc, privKey, caller, []byte{1}).Err)
+	assert.Equal(t, "0", storedWord(t, bc, caller, "S"))
+}
+
+// reentrantCode adds 1 to the word stored under N and calls the contract at
+// the address given as call data with the same call data.
+var reentrantCode = append([]byte{
+	0x4e, 0x0c, 0x01, 0x0a, 0x0d, // byte 'N', push 1, pack
+	0x1b,       // get
+	0x01, 0x0a, // push 1
+	0x0b,                         // add
+	0x4e, 0x0c, 0x01, 0x0a, 0x0d, // byte 'N', push 1, pack
+	0x0f, // store
+	0x1e, // calldata
+	0x1e, // calldata
+}, append(allGas, 0x22)...) // call
+
+func TestCallDepthLimit(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	addr := deployWithKey(t, bc, privKey, reentrantCode)
+
+	tx := NewCallTransaction(addr, addr.ToSlice())
+	tx.GasLimit = 1_000_000
+	assert.Nil(t, tx.Sign(privKey))
+	addBlockWithTxx(t, bc, privKey, tx)
+
+	receipts, err := bc.GetReceipts(bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, "", receipts[0].Err)
+
+	// The transaction runs at depth 0 and every call one deeper
+	assert.Equal(t, "65", storedWord(t, bc, addr, "N"))
+}
+
+func TestCallReentrancyRevert(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	// Adds 1 to N, then reverts once N reaches 5 and otherwise calls the
+	// address given as call data again
+	code := []byte{
+		0x4e, 0x0c, 0x01, 0x0a, 0x0d, // 0: byte 'N', push 1, pack
+		0x1b,       // 5: get
+		0x01, 0x0a, // 6: push 1
+		0x0b,       // 8: add
+		0x00, 0x0a, // 9: push 0
+		0x21,       // 11: mstore
+		0x00, 0x0a, // 12: push 0
+		0x20,                         // 14: mload
+		0x4e, 0x0c, 0x01, 0x0a, 0x0d, // 15: byte 'N', push 1, pack
+		0x0f,       // 20: store
+		0x00, 0x0a, // 21: push 0
+		0x20,       // 23: mload
+		0x05, 0x0a, // 24: push 5
+		0x14,       // 26: lt
+		0x24, 0x0a, // 27: push 36
+		0x1a,                         // 29: jumpi
+		0x45, 0x0c, 0x01, 0x0a, 0x0d, // 30: byte 'E', push 1, pack
+		0x24, // 35: revert
+		0x1e, // 36: calldata
+		0x1e, // 37: calldata
+	}
+	code = append(code, allGas...)
+	code = append(code, 0x22) // call
+
+	addr := deployWithKey(t, bc, privKey, code)
+	assert.Equal(t, "", call(t, bc, privKey, addr, addr.ToSlice()).Err)
+
+	// Only the write of the innermost call that reverted is rolled back
+	assert.Equal(t, "4", storedWord(t, bc, addr, "N"))
+}
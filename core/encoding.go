@@ -1,8 +1,8 @@
 package core
 
 import (
-	"crypto/elliptic"
 	"encoding/gob"
+	"encoding/json"
 	"io"
 )
 
@@ -70,6 +70,126 @@ func (dec *GobBlockDecoder) Decode(b *Block) error {
 	return gob.NewDecoder(dec.r).Decode(b)
 }
 
-func init() {
-	gob.Register(elliptic.P256())
+// CanonicalTxEncoder writes transactions in the canonical binary encoding.
+type CanonicalTxEncoder struct {
+	w io.Writer
+}
+
+func NewCanonicalTxEncoder(w io.Writer) *CanonicalTxEncoder {
+	return &CanonicalTxEncoder{
+		w: w,
+	}
+}
+
+func (e *CanonicalTxEncoder) Encode(tx *Transaction) error {
+	cw := &canonicalWriter{w: e.w}
+	cw.writeTransaction(tx)
+	return cw.err
+}
+
+type CanonicalTxDecoder struct {
+	r io.Reader
+}
+
+func NewCanonicalTxDecoder(r io.Reader) *CanonicalTxDecoder {
+	return &CanonicalTxDecoder{
+		r: r,
+	}
+}
+
+func (d *CanonicalTxDecoder) Decode(tx *Transaction) error {
+	cr := &canonicalReader{r: d.r}
+	cr.readTransaction(tx)
+	return cr.err
+}
+
+// CanonicalBlockEncoder writes blocks in the canonical binary encoding.
+type CanonicalBlockEncoder struct {
+	w io.Writer
+}
+
+func NewCanonicalBlockEncoder(w io.Writer) *CanonicalBlockEncoder {
+	return &CanonicalBlockEncoder{
+		w: w,
+	}
+}
+
+func (enc *CanonicalBlockEncoder) Encode(b *Block) error {
+	cw := &canonicalWriter{w: enc.w}
+	cw.writeBlock(b)
+	return cw.err
+}
+
+type CanonicalBlockDecoder struct {
+	r io.Reader
+}
+
+func NewCanonicalBlockDecoder(r io.Reader) *CanonicalBlockDecoder {
+	return &CanonicalBlockDecoder{
+		r: r,
+	}
+}
+
+func (dec *CanonicalBlockDecoder) Decode(b *Block) error {
+	cr := &canonicalReader{r: dec.r}
+	cr.readBlock(b)
+	return cr.err
+}
+
+// JSONTxEncoder writes transactions as JSON, hashes, keys and signatures are
+// hex encoded.
+type JSONTxEncoder struct {
+	w io.Writer
+}
+
+func NewJSONTxEncoder(w io.Writer) *JSONTxEncoder {
+	return &JSONTxEncoder{
+		w: w,
+	}
+}
+
+func (e *JSONTxEncoder) Encode(tx *Transaction) error {
+	return json.NewEncoder(e.w).Encode(tx)
+}
+
+type JSONTxDecoder struct {
+	r io.Reader
+}
+
+func NewJSONTxDecoder(r io.Reader) *JSONTxDecoder {
+	return &JSONTxDecoder{
+		r: r,
+	}
+}
+
+func (d *JSONTxDecoder) Decode(tx *Transaction) error {
+	return json.NewDecoder(d.r).Decode(tx)
+}
+
+type JSONBlockEncoder struct {
+	w io.Writer
+}
+
+func NewJSONBlockEncoder(w io.Writer) *JSONBlockEncoder {
+	return &JSONBlockEncoder{
+		w: w,
+	}
+}
+
+func (enc *JSONBlockEncoder) Encode(b *Block) error {
+	return json.NewEncoder(enc.w).Encode(b)
+}
+
+type JSONBlockDecoder struct {
+	r io.Reader
+}
+
+func NewJSONBlockDecoder(r io.Reader) *JSONBlockDecoder {
+	return &JSONBlockDecoder{
+		r: r,
+	}
+}
+
+func (dec *JSONBlockDecoder) Decode(b *Block) error {
+	return json.NewDecoder(dec.r).Decode(b)
 }
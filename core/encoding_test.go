@@ -0,0 +1,281 @@
+package core
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// The golden vectors below pin the canonical encoding, if one of them breaks
+// the hashes of existing blocks changed.
+const (
+	goldenHeaderHex = "0000000201010101010101010101010101010101010101010101010101010101" +
+		"0101010102020202020202020202020202020202020202020202020202020202" +
+		"0202020203030303030303030303030303030303030303030303030303030303" +
+		"0303030304040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040417360643d3c200000000002a"
+
+	goldenHeaderHash = "1c7763fe0be1571879c116aa56717924f37462b733a07379bbb344cbb7b0f78d"
+
+	// goldenHeaderV1Hex is goldenHeader at HeaderVersion1, without the
+	// logs bloom.
+	goldenHeaderV1Hex = "0000000101010101010101010101010101010101010101010101010101010101" +
+		"0101010102020202020202020202020202020202020202020202020202020202" +
+		"0202020203030303030303030303030303030303030303030303030303030303" +
+		"0303030317360643d3c200000000002a"
+
+	goldenHeaderV1Hash = "02f383ed10d8a1eb2dff873c22c9c21409f97f6cf1987bef513b0fa2ffce9abb"
+
+	// goldenTxHash is the hash of the encoding of goldenTx.
+	goldenTxHash = "791694d3ff1713e032830b2f0139bb69b48f2cc0b49067e4f1e894b6772ae8bf"
+	// goldenDataHash is the data hash of the transactions of goldenBlock.
+	goldenDataHash = "76ccacc31fc52e1747134a9304810a46b37c98df718298137950222ebc574931"
+
+	goldenTxHex = "0205050505050505050505050505050505050505050100000003666f6f000000" +
+		"00000052082100036b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0" +
+		"f4a13945d898c296010000000000000000000000000000000000000000000000" +
+		"0000000000000000000100000000000000000000000000000000000000000000" +
+		"00000000000000000002"
+
+	goldenBlockHex = "0000000201010101010101010101010101010101010101010101010101010101" +
+		"0101010102020202020202020202020202020202020202020202020202020202" +
+		"0202020203030303030303030303030303030303030303030303030303030303" +
+		"0303030304040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040417360643d3c200000000002a00000001020505050505050505050505" +
+		"0505050505050505050100000003666f6f00000000000052082100036b17d1f2" +
+		"e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c29601000000" +
+		"0000000000000000000000000000000000000000000000000000000000010000" +
+		"0000000000000000000000000000000000000000000000000000000000022100" +
+		"036b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c2" +
+		"9601000000000000000000000000000000000000000000000000000000000000" +
+		"0000030000000000000000000000000000000000000000000000000000000000" +
+		"000004"
+
+	// goldenBlockV3Hex is goldenBlockV3, goldenBlock at HeaderVersion3
+	// with a commit.
+	goldenBlockV3Hex = "0000000301010101010101010101010101010101010101010101010101010101" +
+		"0101010102020202020202020202020202020202020202020202020202020202" +
+		"0202020203030303030303030303030303030303030303030303030303030303" +
+		"0303030304040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040404040404040404040404040404040404040404040404040404040404" +
+		"0404040417360643d3c200000000002a00000001020505050505050505050505" +
+		"0505050505050505050100000003666f6f00000000000052082100036b17d1f2" +
+		"e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c29601000000" +
+		"0000000000000000000000000000000000000000000000000000000000010000" +
+		"0000000000000000000000000000000000000000000000000000000000022100" +
+		"036b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c2" +
+		"9601000000000000000000000000000000000000000000000000000000000000" +
+		"0000030000000000000000000000000000000000000000000000000000000000" +
+		"00000401000000010b036b17d1f2e12c4247f8bce6e563a440f277037d812deb" +
+		"33a0f4a13945d898c29600000000000000000000000000000000000000000000" +
+		"00000000000000000005"
+)
+
+func goldenHeader() *Header {
+	var bloom types.Bloom
+	copy(bloom[:], bytes.Repeat([]byte{0x04}, types.BloomSize))
+
+	return &Header{
+		Version:       HeaderVersion2,
+		DataHash:      types.HashFromBytes(bytes.Repeat([]byte{0x01}, 32)),
+		PrevBlockHash: types.HashFromBytes(bytes.Repeat([]byte{0x02}, 32)),
+		StateRoot:     types.HashFromBytes(bytes.Repeat([]byte{0x03}, 32)),
+		LogsBloom:     bloom,
+		Timestamp:     1672531200000000000,
+		Height:        42,
+	}
+}
+
+// goldenPublicKey is the public key of the private key 1, the generator of P256.
+func goldenPublicKey() crypto.PublicKey {
+	curve := elliptic.P256()
+	return crypto.PublicKey{
+		Key: elliptic.MarshalCompressed(curve, curve.Params().Gx, curve.Params().Gy),
+	}
+}
+
+func goldenTx() *Transaction {
+	var to types.Address
+	for i := range to {
+		to[i] = 0x05
+	}
+
+	return &Transaction{
+		Type:      TxTypeCall,
+		To:        to,
+		VMVersion: VMVersion1,
+		Data:      []byte("foo"),
+		GasLimit:  21000,
+		From:      goldenPublicKey(),
+		Signature: &crypto.Signature{R: big.NewInt(1), S: big.NewInt(2)},
+	}
+}
+
+func goldenBlock() *Block {
+	return &Block{
+		Header:       goldenHeader(),
+		Transactions: []*Transaction{goldenTx()},
+		Validator:    goldenPublicKey(),
+		Signature:    &crypto.Signature{R: big.NewInt(3), S: big.NewInt(4)},
+	}
+}
+
+// goldenBlockV3 is goldenBlock at HeaderVersion3 with a commit of the
+// validators 0, 1 and 3 whose multi-signature has the generator of P256 as R.
+func goldenBlockV3() *Block {
+	b := goldenBlock()
+	b.Version = HeaderVersion3
+	b.Commit = &Commit{
+		Signers:   SignerBitmap{0x0b},
+		Signature: &crypto.MultiSignature{R: goldenPublicKey().Key, S: big.NewInt(5)},
+	}
+
+	return b
+}
+
+func TestCanonicalHeaderGolden(t *testing.T) {
+	h := goldenHeader()
+	assert.Equal(t, goldenHeaderHex, hex.EncodeToString(h.Bytes()))
+	assert.Equal(t, goldenHeaderHash, BlockHasher{}.Hash(h).String())
+
+	decoded := new(Header)
+	cr := &canonicalReader{r: bytes.NewReader(h.Bytes())}
+	cr.readHeader(decoded)
+	assert.Nil(t, cr.err)
+	assert.Equal(t, h, decoded)
+}
+
+func TestCanonicalHeaderV1Golden(t *testing.T) {
+	h := goldenHeader()
+	h.Version = HeaderVersion1
+	h.LogsBloom = types.Bloom{}
+	assert.Equal(t, goldenHeaderV1Hex, hex.EncodeToString(h.Bytes()))
+	assert.Equal(t, goldenHeaderV1Hash, BlockHasher{}.Hash(h).String())
+
+	decoded := new(Header)
+	cr := &canonicalReader{r: bytes.NewReader(h.Bytes())}
+	cr.readHeader(decoded)
+	assert.Nil(t, cr.err)
+	assert.Equal(t, h, decoded)
+}
+
+func TestCanonicalTxGolden(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, goldenTx().Encode(NewCanonicalTxEncoder(buf)))
+	assert.Equal(t, goldenTxHex, hex.EncodeToString(buf.Bytes()))
+	b, err := goldenTx().Bytes()
+	assert.Nil(t, err)
+	assert.Equal(t, goldenTxHex, hex.EncodeToString(b))
+	assert.Equal(t, goldenTxHash, goldenTx().Hash(TxHasher{}).String())
+
+	tx := new(Transaction)
+	assert.Nil(t, tx.Decode(NewCanonicalTxDecoder(buf)))
+	assert.Equal(t, goldenTx(), tx)
+
+	dataHash, err := CalculateDataHash(goldenBlock().Transactions)
+	assert.Nil(t, err)
+	assert.Equal(t, goldenDataHash, dataHash.String())
+}
+
+func TestTxHashCoversSignature(t *testing.T) {
+	tx := goldenTx()
+	hash := tx.Hash(TxHasher{})
+
+	other := goldenTx()
+	other.Signature.S = big.NewInt(3)
+	assert.NotEqual(t, hash, other.Hash(TxHasher{}))
+
+	other = goldenTx()
+	other.From = crypto.GeneratePrivateKey().PublicKey()
+	assert.NotEqual(t, hash, other.Hash(TxHasher{}))
+
+	other = goldenTx()
+	other.GasLimit++
+	assert.NotEqual(t, hash, other.Hash(TxHasher{}))
+
+	// Signing again resets the cached hash
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	assert.NotEqual(t, hash, tx.Hash(TxHasher{}))
+}
+
+func TestTxBytesInvalidSignature(t *testing.T) {
+	tx := goldenTx()
+	tx.Signature.S = new(big.Int).Lsh(big.NewInt(1), 300)
+
+	_, err := tx.Bytes()
+	assert.NotNil(t, err)
+	assert.True(t, tx.Hash(TxHasher{}).IsZero())
+	assert.NotNil(t, tx.Verify())
+}
+
+func TestCanonicalBlockGolden(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, goldenBlock().Encode(NewCanonicalBlockEncoder(buf)))
+	assert.Equal(t, goldenBlockHex, hex.EncodeToString(buf.Bytes()))
+
+	b := new(Block)
+	assert.Nil(t, b.Decode(NewCanonicalBlockDecoder(buf)))
+	assert.Equal(t, goldenBlock(), b)
+
+	// Only blocks from HeaderVersion3 carry a commit
+	b = goldenBlock()
+	b.Commit = goldenBlockV3().Commit
+	assert.NotNil(t, b.Encode(NewCanonicalBlockEncoder(&bytes.Buffer{})))
+}
+
+func TestCanonicalBlockV3Golden(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, goldenBlockV3().Encode(NewCanonicalBlockEncoder(buf)))
+	assert.Equal(t, goldenBlockV3Hex, hex.EncodeToString(buf.Bytes()))
+
+	b := new(Block)
+	assert.Nil(t, b.Decode(NewCanonicalBlockDecoder(buf)))
+	assert.Equal(t, goldenBlockV3(), b)
+
+	// The commit is not part of the header, the hash of the block is the one
+	// of its header
+	withoutCommit := goldenBlockV3()
+	withoutCommit.Commit = nil
+	assert.Equal(t, withoutCommit.Hash(BlockHasher{}), goldenBlockV3().Hash(BlockHasher{}))
+}
+
+func TestCanonicalBlockRoundTrip(t *testing.T) {
+	b := randomBlock(t, 1, types.RandomHash())
+	buf := &bytes.Buffer{}
+	assert.Nil(t, b.Encode(NewCanonicalBlockEncoder(buf)))
+	encoded := buf.Bytes()
+
+	bDecode := new(Block)
+	assert.Nil(t, bDecode.Decode(NewCanonicalBlockDecoder(bytes.NewReader(encoded))))
+	assert.Equal(t, b.Hash(BlockHasher{}), bDecode.Hash(BlockHasher{}))
+	assert.Nil(t, bDecode.Verify())
+
+	truncated := new(Block)
+	assert.NotNil(t, truncated.Decode(NewCanonicalBlockDecoder(bytes.NewReader(encoded[:len(encoded)-1]))))
+}
@@ -0,0 +1,51 @@
+package core
+
+import "github.com/anthoai97/blockchain-from-scratch/types"
+
+// Executor runs the code of script transactions and contracts. A chain is
+// configured with a single executor, see Blockchain.SetExecutor, and every
+// node of the chain must use the same one to agree on the state.
+type Executor interface {
+	Execute(e *Execution) (ExecutionResult, error)
+}
+
+// Execution is the code to run with its environment.
+type Execution struct {
+	// VMVersion is the version of the code, given by the transaction or
+	// stored with the contract.
+	VMVersion uint8
+	Code      []byte
+	CallData  []byte
+	// Address is the address of the running contract, the zero address for
+	// scripts.
+	Address types.Address
+	// State is the whole state, the code may only write to its own storage
+	// and executors that support calls read the code of other contracts
+	// from it.
+	State    *State
+	GasLimit uint64
+	// Tracer may be nil, executors that can not trace fail when it is set.
+	Tracer Tracer
+}
+
+// Storage returns the storage of the running contract.
+func (e *Execution) Storage() ContractState {
+	return contractStorage{state: e.State, addr: e.Address}
+}
+
+// ExecutionResult is the outcome of an Execution. The gas used is also set
+// when the execution fails.
+type ExecutionResult struct {
+	GasUsed    uint64
+	Logs       []*Log
+	ReturnData []byte
+}
+
+// StackExecutor runs code on the stack VM of the version of the code, it is
+// the executor of a new Blockchain.
+type StackExecutor struct{}
+
+func (StackExecutor) Execute(e *Execution) (ExecutionResult, error) {
+	storage := contractStorage{state: e.State, addr: e.Address}
+	return runVM(e.VMVersion, e.Code, e.CallData, storage, e.GasLimit, e.Tracer, 0)
+}
@@ -0,0 +1,79 @@
+package core
+
+import "github.com/anthoai97/blockchain-from-scratch/types"
+
+const (
+	// DefaultTxGasLimit is the gas limit of a transaction that does not set
+	// one.
+	DefaultTxGasLimit uint64 = 100_000
+	// DefaultBlockGasLimit bounds the sum of the gas limits of the
+	// transactions of a block.
+	DefaultBlockGasLimit uint64 = 10_000_000
+)
+
+const (
+	// gasDefault is the cost of a byte of code that is not an instruction
+	gasDefault uint64 = 1
+	// gasPackPerByte is paid for every byte packed by InstrPack
+	gasPackPerByte uint64 = 1
+	// gasStorePerByte is paid for every byte of key and value stored
+	gasStorePerByte uint64 = 10
+	// gasLogPerTopic and gasLogPerByte are paid for every topic and byte of
+	// data of a log
+	gasLogPerTopic uint64 = 100
+	gasLogPerByte  uint64 = 2
+)
+
+// instrGas is the static cost of every instruction, dynamic costs are charged
+// while the instruction executes.
+var instrGas = map[Instruction]uint64{
+	InstrPush:     2,
+	InstrByte:     2,
+	InstrPack:     3,
+	InstrAdd:      3,
+	InstrSub:      3,
+	InstrStore:    100,
+	InstrMul:      5,
+	InstrDiv:      5,
+	InstrMod:      5,
+	InstrEq:       3,
+	InstrLt:       3,
+	InstrGt:       3,
+	InstrNot:      3,
+	InstrAnd:      3,
+	InstrOr:       3,
+	InstrJump:     8,
+	InstrJumpI:    10,
+	InstrGet:      50,
+	InstrDelete:   50,
+	InstrRot:      3,
+	InstrCallData: 3,
+	InstrLog:      100,
+	InstrMLoad:    3,
+	InstrMStore:   3,
+	InstrCall:     100,
+	InstrReturn:   3,
+	InstrRevert:   3,
+}
+
+func (instr Instruction) Gas() uint64 {
+	if gas, ok := instrGas[instr]; ok {
+		return gas
+	}
+	return gasDefault
+}
+
+// Receipt is the outcome of the execution of a transaction. A transaction that
+// fails is still part of the block, its state writes are reverted and Err
+// holds the reason.
+type Receipt struct {
+	TxHash  types.Hash
+	GasUsed uint64
+	Err     string
+	// ContractAddress is the address of the contract created by a deploy
+	// transaction.
+	ContractAddress types.Address
+	// Logs are the logs emitted by the transaction, a failing transaction
+	// has none.
+	Logs []*Log
+}
@@ -17,8 +17,16 @@ func (BlockHasher) Hash(b *Header) types.Hash {
 	return types.Hash(h)
 }
 
+// TxHasher hashes the canonical encoding of the transaction, the hash covers
+// the sender and the signature along with the execution fields. A transaction
+// without canonical encoding hashes to the zero hash, Verify rejects it so it
+// never enters the pool or a block.
 type TxHasher struct{}
 
 func (TxHasher) Hash(tx *Transaction) types.Hash {
-	return types.Hash(sha256.Sum256(tx.Data))
+	b, err := tx.Bytes()
+	if err != nil {
+		return types.Hash{}
+	}
+	return types.Hash(sha256.Sum256(b))
 }
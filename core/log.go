@@ -0,0 +1,65 @@
+package core
+
+import "github.com/anthoai97/blockchain-from-scratch/types"
+
+// maxLogTopics is the maximum number of topics of a log.
+const maxLogTopics = 4
+
+// Log is emitted by a contract with InstrLog to signal an outcome to off-chain
+// consumers. TxHash and Height locate the transaction that emitted it.
+type Log struct {
+	Address types.Address
+	Topics  []types.Hash
+	Data    []byte
+	TxHash  types.Hash
+	Height  uint32
+}
+
+// Matches tells if the log has the given address and topics, see FilterLogs.
+func (l *Log) Matches(address *types.Address, topics []types.Hash) bool {
+	if address != nil && *address != l.Address {
+		return false
+	}
+	if len(topics) > len(l.Topics) {
+		return false
+	}
+
+	for i, topic := range topics {
+		if !topic.IsZero() && topic != l.Topics[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// LogsBloom returns the bloom of the logs of the receipts, it holds the
+// address and the topics of every log.
+func LogsBloom(receipts []*Receipt) types.Bloom {
+	var bloom types.Bloom
+
+	for _, receipt := range receipts {
+		for _, l := range receipt.Logs {
+			bloom.Add(l.Address.ToSlice())
+			for _, topic := range l.Topics {
+				bloom.Add(topic.ToSlice())
+			}
+		}
+	}
+
+	return bloom
+}
+
+func bloomMatches(bloom types.Bloom, address *types.Address, topics []types.Hash) bool {
+	if address != nil && !bloom.Test(address.ToSlice()) {
+		return false
+	}
+
+	for _, topic := range topics {
+		if !topic.IsZero() && !bloom.Test(topic.ToSlice()) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// logCode emits a log with the call data as data and the topics 7 and the
+// call data.
+var logCode = []byte{
+	0x1e,       // calldata
+	0x07, 0x0a, // push 7
+	0x1e,       // calldata
+	0x02, 0x0a, // push 2
+	0x1f, // log
+}
+
+func wordTopic(x int64) types.Hash {
+	return types.HashFromBytes(NewWordValue(big.NewInt(x)).Serialize())
+}
+
+func TestVMLog(t *testing.T) {
+	vm := NewVM(logCode, NewState(), DefaultTxGasLimit)
+	vm.callData = []byte{0x03}
+	vm.address = types.Address{0x01}
+	assert.Nil(t, vm.Run())
+
+	assert.Equal(t, 1, len(vm.Logs()))
+	l := vm.Logs()[0]
+	assert.Equal(t, types.Address{0x01}, l.Address)
+	assert.Equal(t, []types.Hash{wordTopic(7), wordTopic(3)}, l.Topics)
+	assert.Equal(t, []byte{0x03}, l.Data)
+}
+
+func TestVMLogTooManyTopics(t *testing.T) {
+	// push 5, log
+	_, err := runCode(t, []byte{0x05, 0x0a, 0x1f})
+	assert.Equal(t, ErrTooManyTopics, err)
+}
+
+func TestFilterLogs(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	addr := deployWithKey(t, bc, privKey, logCode)
+	for i := byte(1); i <= 3; i++ {
+		receipt := call(t, bc, privKey, addr, []byte{i})
+		assert.Equal(t, "", receipt.Err)
+		assert.Equal(t, 1, len(receipt.Logs))
+	}
+
+	// The deploy block has no logs
+	header, err := bc.GetHeader(1)
+	assert.Nil(t, err)
+	assert.Equal(t, types.Bloom{}, header.LogsBloom)
+
+	header, err = bc.GetHeader(2)
+	assert.Nil(t, err)
+	assert.True(t, bloomMatches(header.LogsBloom, &addr, []types.Hash{wordTopic(7), wordTopic(1)}))
+	assert.False(t, bloomMatches(header.LogsBloom, &addr, []types.Hash{{}, wordTopic(2)}))
+
+	logs, err := bc.FilterLogs(0, 10, &addr, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(logs))
+	for i, l := range logs {
+		assert.Equal(t, uint32(i+2), l.Height)
+		assert.Equal(t, []byte{byte(i + 1)}, l.Data)
+	}
+
+	logs, err = bc.FilterLogs(0, 10, nil, []types.Hash{{}, wordTopic(2)})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(logs))
+	assert.Equal(t, uint32(3), logs[0].Height)
+
+	logs, err = bc.FilterLogs(3, 3, &addr, []types.Hash{wordTopic(7)})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(logs))
+
+	other := types.Address{0x01}
+	logs, err = bc.FilterLogs(0, 10, &other, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(logs))
+
+	_, err = bc.FilterLogs(2, 1, nil, nil)
+	assert.NotNil(t, err)
+}
+
+func TestAddBlockInvalidLogsBloom(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+	addr := deployWithKey(t, bc, privKey, logCode)
+
+	tx := NewCallTransaction(addr, []byte{1})
+	assert.Nil(t, tx.Sign(privKey))
+
+	prevHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	b, err := NewBlockFromPrevHeader(prevHeader, []*Transaction{tx})
+	assert.Nil(t, err)
+
+	state, _, err := bc.ExecuteBlock(b)
+	assert.Nil(t, err)
+	b.StateRoot = state.Root()
+	assert.Nil(t, b.Sign(privKey))
+
+	assert.NotNil(t, bc.AddBlock(b))
+}
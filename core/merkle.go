@@ -0,0 +1,161 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// Leaves and inner nodes are hashed with a different prefix so that an inner
+// node can never be passed off as a leaf.
+const (
+	merkleLeafPrefix  byte = 0x00
+	merkleInnerPrefix byte = 0x01
+)
+
+// MerkleProof proves that a leaf is part of a merkle tree. When a level has an
+// odd number of nodes the last one is promoted to the next level as is, so the
+// total number of leaves is needed to replay the path.
+type MerkleProof struct {
+	Index  uint32
+	Total  uint32
+	Hashes []types.Hash
+}
+
+func merkleLeafHash(leaf []byte) types.Hash {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, leaf...))
+}
+
+func merkleInnerHash(left, right types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+2*len(left))
+	buf = append(buf, merkleInnerPrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+
+	return sha256.Sum256(buf)
+}
+
+func merkleLevels(leaves [][]byte) [][]types.Hash {
+	level := make([]types.Hash, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeafHash(leaf)
+	}
+
+	levels := [][]types.Hash{level}
+	for len(level) > 1 {
+		next := make([]types.Hash, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, merkleInnerHash(level[i], level[i+1]))
+		}
+
+		levels = append(levels, next)
+		level = next
+	}
+
+	return levels
+}
+
+// MerkleRoot returns the root of the merkle tree build over the given leaves.
+// The root of an empty tree is the zero hash.
+func MerkleRoot(leaves [][]byte) types.Hash {
+	if len(leaves) == 0 {
+		return types.Hash{}
+	}
+
+	levels := merkleLevels(leaves)
+
+	return levels[len(levels)-1][0]
+}
+
+// NewMerkleProof returns the proof of the leaf at the given index.
+func NewMerkleProof(leaves [][]byte, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("leaf index (%d) out of range (%d)", index, len(leaves))
+	}
+
+	proof := &MerkleProof{
+		Index:  uint32(index),
+		Total:  uint32(len(leaves)),
+		Hashes: []types.Hash{},
+	}
+
+	levels := merkleLevels(leaves)
+	for _, level := range levels[:len(levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Hashes = append(proof.Hashes, level[sibling])
+		}
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// Verify checks that the leaf is part of the tree with the given root.
+func (p *MerkleProof) Verify(root types.Hash, leaf []byte) bool {
+	if p.Index >= p.Total {
+		return false
+	}
+
+	var (
+		hash   = merkleLeafHash(leaf)
+		index  = p.Index
+		n      = p.Total
+		hashes = p.Hashes
+	)
+
+	for n > 1 {
+		sibling := index ^ 1
+		if sibling < n {
+			if len(hashes) == 0 {
+				return false
+			}
+
+			if index%2 == 0 {
+				hash = merkleInnerHash(hash, hashes[0])
+			} else {
+				hash = merkleInnerHash(hashes[0], hash)
+			}
+			hashes = hashes[1:]
+		}
+
+		index /= 2
+		n = (n + 1) / 2
+	}
+
+	return len(hashes) == 0 && hash == root
+}
+
+// TxProof proves that a transaction is included in a block.
+type TxProof struct {
+	TxHash types.Hash
+	Proof  *MerkleProof
+}
+
+// Verify checks the proof against the data hash of a block header.
+func (p *TxProof) Verify(dataHash types.Hash) bool {
+	if p.Proof == nil {
+		return false
+	}
+	return p.Proof.Verify(dataHash, p.TxHash.ToSlice())
+}
+
+// StateProof proves the value of a key in the state committed by a block.
+type StateProof struct {
+	Key   []byte
+	Value []byte
+	Proof *MerkleProof
+}
+
+// Verify checks the proof against the state root of a block header.
+func (p *StateProof) Verify(stateRoot types.Hash) bool {
+	if p.Proof == nil {
+		return false
+	}
+	return p.Proof.Verify(stateRoot, stateLeaf(p.Key, p.Value))
+}
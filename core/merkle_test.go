@@ -0,0 +1,64 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleRootEmpty(t *testing.T) {
+	assert.Equal(t, types.Hash{}, MerkleRoot(nil))
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		leaves := [][]byte{}
+		for i := 0; i < n; i++ {
+			leaves = append(leaves, []byte(fmt.Sprintf("leaf_%d", i)))
+		}
+		root := MerkleRoot(leaves)
+
+		for i := 0; i < n; i++ {
+			proof, err := NewMerkleProof(leaves, i)
+			assert.Nil(t, err)
+			assert.True(t, proof.Verify(root, leaves[i]))
+			assert.False(t, proof.Verify(root, []byte("foo")))
+			assert.False(t, proof.Verify(types.RandomHash(), leaves[i]))
+		}
+	}
+
+	_, err := NewMerkleProof([][]byte{[]byte("foo")}, 1)
+	assert.NotNil(t, err)
+}
+
+func TestMerkleProofWrongIndex(t *testing.T) {
+	leaves := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	root := MerkleRoot(leaves)
+
+	proof, err := NewMerkleProof(leaves, 0)
+	assert.Nil(t, err)
+	proof.Index = 1
+	assert.False(t, proof.Verify(root, leaves[0]))
+}
+
+func TestStateProof(t *testing.T) {
+	s := NewState()
+	assert.Equal(t, types.Hash{}, s.Root())
+
+	assert.Nil(t, s.Put([]byte("foo"), []byte("bar")))
+	assert.Nil(t, s.Put([]byte("baz"), []byte("qux")))
+	root := s.Root()
+
+	proof, err := s.Prove([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), proof.Value)
+	assert.True(t, proof.Verify(root))
+
+	proof.Value = []byte("xxx")
+	assert.False(t, proof.Verify(root))
+
+	_, err = s.Prove([]byte("none"))
+	assert.NotNil(t, err)
+}
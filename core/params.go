@@ -0,0 +1,133 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+)
+
+// ChainParams are the parameters every node of a chain must agree on. They
+// are the data of a transaction of the genesis block so that its hash
+// commits to them, see NewGenesisBlock, and NewBlockchain reads them back
+// from the genesis block.
+type ChainParams struct {
+	// SignatureAlgorithms are the algorithms of the keys allowed to sign
+	// blocks and transactions, only P-256 when empty.
+	SignatureAlgorithms []crypto.Algorithm
+	// Validators must commit every block, each with the proof of possession
+	// of its private key at the same index of ValidatorProofs. Blocks need
+	// no commit when there is none.
+	Validators      []crypto.PublicKey
+	ValidatorProofs []*crypto.MultiSignature
+}
+
+// NewGenesisBlock returns the genesis block with the header of the chain with
+// the given parameters, the data hash of the header is filled in.
+func NewGenesisBlock(h *Header, params ChainParams) (*Block, error) {
+	data, err := params.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := NewBlock(h, []*Transaction{{Type: TxTypeChainParams, Data: data}})
+	if err != nil {
+		return nil, err
+	}
+
+	if b.DataHash, err = CalculateDataHash(b.Transactions); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Bytes returns the canonical encoding of the parameters.
+func (p ChainParams) Bytes() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	cw := &canonicalWriter{w: buf}
+	cw.writeChainParams(p)
+	if cw.err != nil {
+		return nil, cw.err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ChainParamsFromBytes decodes the canonical encoding of parameters.
+func ChainParamsFromBytes(b []byte) (ChainParams, error) {
+	r := bytes.NewReader(b)
+	cr := &canonicalReader{r: r}
+	p := cr.readChainParams()
+	if cr.err != nil {
+		return ChainParams{}, cr.err
+	}
+	if r.Len() != 0 {
+		return ChainParams{}, fmt.Errorf("canonical: %d trailing bytes after chain params", r.Len())
+	}
+
+	return p, nil
+}
+
+// genesisParams returns the parameters of the chain of the genesis block, the
+// default ones when it has no chain params transaction.
+func genesisParams(genesis *Block) (ChainParams, error) {
+	dataHash, err := CalculateDataHash(genesis.Transactions)
+	if err != nil {
+		return ChainParams{}, err
+	}
+	if dataHash != genesis.DataHash {
+		return ChainParams{}, fmt.Errorf("genesis block has an invalid data hash (%s) expected (%s)", genesis.DataHash, dataHash)
+	}
+
+	var (
+		params ChainParams
+		found  bool
+	)
+	for _, tx := range genesis.Transactions {
+		if tx.Type != TxTypeChainParams {
+			continue
+		}
+		if found {
+			return ChainParams{}, fmt.Errorf("genesis block has more than one chain params transaction")
+		}
+		found = true
+
+		if params, err = ChainParamsFromBytes(tx.Data); err != nil {
+			return ChainParams{}, err
+		}
+	}
+
+	return params, nil
+}
+
+// signatureAlgorithms returns the set of the allowed algorithms.
+func (p ChainParams) signatureAlgorithms() (map[crypto.Algorithm]bool, error) {
+	if len(p.SignatureAlgorithms) == 0 {
+		return map[crypto.Algorithm]bool{crypto.P256: true}, nil
+	}
+
+	known := map[crypto.Algorithm]bool{}
+	for _, alg := range crypto.Algorithms() {
+		known[alg] = true
+	}
+
+	algs := make(map[crypto.Algorithm]bool, len(p.SignatureAlgorithms))
+	for _, alg := range p.SignatureAlgorithms {
+		if !known[alg] {
+			return nil, fmt.Errorf("chain params allow unknown signature algorithm %s", alg)
+		}
+		algs[alg] = true
+	}
+
+	return algs, nil
+}
+
+// validatorSet returns the set of the validators, nil when there is none.
+func (p ChainParams) validatorSet() (*ValidatorSet, error) {
+	if len(p.Validators) == 0 && len(p.ValidatorProofs) == 0 {
+		return nil, nil
+	}
+
+	return NewValidatorSet(p.Validators, p.ValidatorProofs)
+}
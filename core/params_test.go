@@ -0,0 +1,90 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainParamsBytes(t *testing.T) {
+	params := ChainParams{SignatureAlgorithms: []crypto.Algorithm{crypto.Ed25519, crypto.Secp256k1}}
+	b, err := params.Bytes()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x02, 0x02, 0x01, 0x00, 0x00, 0x00, 0x00}, b)
+
+	decoded, err := ChainParamsFromBytes(b)
+	assert.Nil(t, err)
+	assert.Equal(t, params, decoded)
+
+	// With validators
+	params, _ = newValidatorParams(t, 2)
+	params.SignatureAlgorithms = []crypto.Algorithm{crypto.P256}
+	b, err = params.Bytes()
+	assert.Nil(t, err)
+	assert.Len(t, b, 2+4+2*(2+33+crypto.MultiSignatureSize))
+
+	decoded, err = ChainParamsFromBytes(b)
+	assert.Nil(t, err)
+	assert.Equal(t, params, decoded)
+
+	// Every validator needs a proof
+	params.ValidatorProofs = params.ValidatorProofs[:1]
+	_, err = params.Bytes()
+	assert.NotNil(t, err)
+
+	_, err = ChainParamsFromBytes(append(b, 0x00))
+	assert.NotNil(t, err)
+	_, err = ChainParamsFromBytes(b[:2])
+	assert.NotNil(t, err)
+}
+
+func TestNewBlockchainGenesisParams(t *testing.T) {
+	params := ChainParams{SignatureAlgorithms: []crypto.Algorithm{crypto.Ed25519}}
+	genesis, err := NewGenesisBlock(&Header{Version: HeaderVersion}, params)
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+	assert.True(t, bc.AllowsSignatureAlgorithm(crypto.Ed25519))
+	assert.False(t, bc.AllowsSignatureAlgorithm(crypto.P256))
+
+	// The chain params are committed by the data hash
+	other, err := ChainParams{SignatureAlgorithms: []crypto.Algorithm{crypto.P256}}.Bytes()
+	assert.Nil(t, err)
+	genesis.Transactions[0].Data = other
+	_, err = NewBlockchain(log.NewNopLogger(), genesis)
+	assert.NotNil(t, err)
+
+	// Another genesis
+	genesis, err = NewGenesisBlock(&Header{Version: HeaderVersion}, params)
+	assert.Nil(t, err)
+	otherGenesis, err := NewGenesisBlock(&Header{Version: HeaderVersion}, ChainParams{})
+	assert.Nil(t, err)
+	assert.NotEqual(t, genesis.Hash(BlockHasher{}), otherGenesis.Hash(BlockHasher{}))
+
+	// Unknown algorithms are not allowed
+	genesis, err = NewGenesisBlock(&Header{Version: HeaderVersion}, ChainParams{SignatureAlgorithms: []crypto.Algorithm{7}})
+	assert.Nil(t, err)
+	_, err = NewBlockchain(log.NewNopLogger(), genesis)
+	assert.NotNil(t, err)
+
+	// The proofs of possession of the validators are checked
+	params, _ = newValidatorParams(t, 2)
+	params.ValidatorProofs[0], params.ValidatorProofs[1] = params.ValidatorProofs[1], params.ValidatorProofs[0]
+	genesis, err = NewGenesisBlock(&Header{Version: HeaderVersion}, params)
+	assert.Nil(t, err)
+	_, err = NewBlockchain(log.NewNopLogger(), genesis)
+	assert.NotNil(t, err)
+
+	// A genesis block without chain params has the default ones
+	genesis, err = NewBlock(&Header{Version: HeaderVersion, DataHash: types.Hash{}}, nil)
+	assert.Nil(t, err)
+	bc, err = NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+	assert.True(t, bc.AllowsSignatureAlgorithm(crypto.P256))
+	assert.False(t, bc.AllowsSignatureAlgorithm(crypto.Ed25519))
+	assert.Nil(t, bc.ValidatorSet())
+}
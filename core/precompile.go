@@ -0,0 +1,123 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// The reserved addresses of the precompiled contracts, no deployed contract
+// can have them.
+var (
+	// SHA256Address returns the SHA-256 hash of the call data.
+	SHA256Address = types.Address{19: 0x01}
+	// VerifyAddress returns 1 as a word when the call data, a compressed
+	// public key, a 64 bytes signature and the signed data, holds a valid
+	// signature of crypto.PrivateKey.Sign and 0 otherwise.
+	VerifyAddress = types.Address{19: 0x02}
+	// DeriveAddressAddress returns the 20 bytes address of the compressed
+	// public key of the call data.
+	DeriveAddressAddress = types.Address{19: 0x03}
+)
+
+const (
+	gasSHA256        uint64 = 60
+	gasSHA256PerWord uint64 = 12
+	gasVerify        uint64 = 3000
+	gasDeriveAddress uint64 = 100
+
+	compressedKeySize = 33
+)
+
+// PrecompiledContract is a contract implemented in Go, contracts call it
+// with InstrCall like any other contract.
+type PrecompiledContract interface {
+	// Gas returns the cost of running the contract with the input.
+	Gas(input []byte) uint64
+	Run(input []byte) ([]byte, error)
+}
+
+var precompiles = map[types.Address]PrecompiledContract{
+	SHA256Address:        sha256Hash{},
+	VerifyAddress:        verifySignature{},
+	DeriveAddressAddress: deriveAddress{},
+}
+
+// Precompile returns the precompiled contract at the address.
+func Precompile(addr types.Address) (PrecompiledContract, bool) {
+	p, ok := precompiles[addr]
+	return p, ok
+}
+
+// runPrecompile runs the precompiled contract, all the gas is used when it
+// costs more than the gas limit or fails.
+func runPrecompile(p PrecompiledContract, input []byte, gasLimit uint64) (ExecutionResult, error) {
+	gas := p.Gas(input)
+	if gas > gasLimit {
+		return ExecutionResult{GasUsed: gasLimit}, ErrOutOfGas
+	}
+
+	out, err := p.Run(input)
+	if err != nil {
+		return ExecutionResult{GasUsed: gasLimit}, err
+	}
+
+	return ExecutionResult{GasUsed: gas, ReturnData: out}, nil
+}
+
+type sha256Hash struct{}
+
+func (sha256Hash) Gas(input []byte) uint64 {
+	return gasSHA256 + uint64((len(input)+wordSize-1)/wordSize)*gasSHA256PerWord
+}
+
+func (sha256Hash) Run(input []byte) ([]byte, error) {
+	h := sha256.Sum256(input)
+	return h[:], nil
+}
+
+type verifySignature struct{}
+
+func (verifySignature) Gas(input []byte) uint64 {
+	return gasVerify
+}
+
+func (verifySignature) Run(input []byte) ([]byte, error) {
+	if len(input) < compressedKeySize+crypto.SignatureSize {
+		return nil, fmt.Errorf("verify input of %d bytes is too short", len(input))
+	}
+
+	valid := false
+	pubKey, keyErr := crypto.PublicKeyFromBytes(input[:compressedKeySize])
+	sig, sigErr := crypto.SignatureFromBytes(input[compressedKeySize : compressedKeySize+crypto.SignatureSize])
+	if keyErr == nil && sigErr == nil {
+		valid = sig.Verify(pubKey, input[compressedKeySize+crypto.SignatureSize:])
+	}
+
+	out := make([]byte, wordSize)
+	if valid {
+		out[wordSize-1] = 1
+	}
+	return out, nil
+}
+
+type deriveAddress struct{}
+
+func (deriveAddress) Gas(input []byte) uint64 {
+	return gasDeriveAddress
+}
+
+func (deriveAddress) Run(input []byte) ([]byte, error) {
+	if len(input) != compressedKeySize {
+		return nil, fmt.Errorf("given public key with length %d should be %d", len(input), compressedKeySize)
+	}
+
+	pubKey, err := crypto.PublicKeyFromBytes(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return pubKey.Address().ToSlice(), nil
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallPrecompile(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	caller := deployWithKey(t, bc, privKey, callerCode(SHA256Address, allGas...))
+
+	receipt := call(t, bc, privKey, caller, []byte{0})
+	assert.Equal(t, "", receipt.Err)
+	assert.Equal(t, "1", storedWord(t, bc, caller, "S"))
+
+	h := sha256.Sum256([]byte{5})
+	assert.Equal(t, h[:], storedBytes(t, bc, caller, "R"))
+
+	// Not enough gas for the precompile, the call fails
+	caller = deployWithKey(t, bc, privKey, callerCode(SHA256Address, 0x01, 0x0a)) // push 1
+	receipt = call(t, bc, privKey, caller, []byte{0})
+	assert.Equal(t, "", receipt.Err)
+	assert.Equal(t, "0", storedWord(t, bc, caller, "S"))
+}
+
+func TestPrecompileVerify(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	data := []byte("hello")
+	sig, err := privKey.Sign(data)
+	assert.Nil(t, err)
+
+	input := append([]byte{}, privKey.PublicKey().ToSlice()...)
+	rs := make([]byte, 64)
+	sig.R.FillBytes(rs[:32])
+	sig.S.FillBytes(rs[32:])
+	input = append(input, rs...)
+
+	result, err := bc.Call(NewCallTransaction(VerifyAddress, append(input, data...)), bc.Height())
+	assert.Nil(t, err)
+	assert.Nil(t, result.Err)
+	assert.Equal(t, byte(1), result.ReturnData[31])
+	assert.Equal(t, gasVerify, result.GasUsed)
+
+	result, err = bc.Call(NewCallTransaction(VerifyAddress, append(input, []byte("world")...)), bc.Height())
+	assert.Nil(t, err)
+	assert.Nil(t, result.Err)
+	assert.Equal(t, make([]byte, 32), result.ReturnData)
+
+	// Too short
+	result, err = bc.Call(NewCallTransaction(VerifyAddress, input[:10]), bc.Height())
+	assert.Nil(t, err)
+	assert.NotNil(t, result.Err)
+
+	tx := NewCallTransaction(VerifyAddress, input)
+	tx.GasLimit = gasVerify - 1
+	result, err = bc.Call(tx, bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, ErrOutOfGas, result.Err)
+	assert.Equal(t, gasVerify-1, result.GasUsed)
+}
+
+func TestPrecompileDeriveAddress(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+
+	p, ok := Precompile(DeriveAddressAddress)
+	assert.True(t, ok)
+
+	out, err := p.Run(privKey.PublicKey().ToSlice())
+	assert.Nil(t, err)
+	assert.Equal(t, privKey.PublicKey().Address().ToSlice(), out)
+
+	_, err = p.Run([]byte{1, 2, 3})
+	assert.NotNil(t, err)
+
+	_, ok = Precompile(ContractAddress(privKey.PublicKey().Address(), 0))
+	assert.False(t, ok)
+}
@@ -1,9 +1,32 @@
 package core
 
-import "fmt"
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// ContractState is the part of the state the code run by the VM can read and
+// write.
+type ContractState interface {
+	Put(k, v []byte) error
+	Get(k []byte) ([]byte, error)
+	Delete(k []byte) error
+}
 
 type State struct {
 	data map[string][]byte
+	// journal records the previous value of every written key so that writes
+	// can be reverted up to a snapshot.
+	journal []journalEntry
+}
+
+type journalEntry struct {
+	key     string
+	prev    []byte
+	existed bool
 }
 
 func NewState() *State {
@@ -13,15 +36,50 @@ func NewState() *State {
 }
 
 func (s *State) Put(k, v []byte) error {
+	s.record(string(k))
 	s.data[string(k)] = v
 	return nil
 }
 
 func (s *State) Delete(k []byte) error {
+	s.record(string(k))
 	delete(s.data, string(k))
 	return nil
 }
 
+// Snapshot returns an identifier of the current state to revert to.
+func (s *State) Snapshot() int {
+	return len(s.journal)
+}
+
+// RevertToSnapshot undoes all the writes made after the given snapshot.
+func (s *State) RevertToSnapshot(snapshot int) {
+	for i := len(s.journal) - 1; i >= snapshot; i-- {
+		entry := s.journal[i]
+		if entry.existed {
+			s.data[entry.key] = entry.prev
+		} else {
+			delete(s.data, entry.key)
+		}
+	}
+
+	s.journal = s.journal[:snapshot]
+}
+
+// commit drops the journal, writes can not be reverted anymore.
+func (s *State) commit() {
+	s.journal = nil
+}
+
+func (s *State) record(key string) {
+	prev, existed := s.data[key]
+	s.journal = append(s.journal, journalEntry{
+		key:     key,
+		prev:    prev,
+		existed: existed,
+	})
+}
+
 func (s *State) Get(k []byte) ([]byte, error) {
 	key := string(k)
 
@@ -32,3 +90,73 @@ func (s *State) Get(k []byte) ([]byte, error) {
 
 	return value, nil
 }
+
+// Copy returns a copy of the state that can be modified without affecting s.
+func (s *State) Copy() *State {
+	cp := NewState()
+	for k, v := range s.data {
+		cp.data[k] = v
+	}
+
+	return cp
+}
+
+// Root returns the merkle root over all the key value pairs of the state,
+// ordered by key.
+func (s *State) Root() types.Hash {
+	keys := s.sortedKeys()
+
+	leaves := make([][]byte, len(keys))
+	for i, k := range keys {
+		leaves[i] = stateLeaf([]byte(k), s.data[k])
+	}
+
+	return MerkleRoot(leaves)
+}
+
+// Prove returns a proof of the value of the given key against the state root.
+// Only the inclusion of a key can be proven.
+func (s *State) Prove(k []byte) (*StateProof, error) {
+	keys := s.sortedKeys()
+
+	index := sort.SearchStrings(keys, string(k))
+	if index == len(keys) || keys[index] != string(k) {
+		return nil, fmt.Errorf("given key %s not found", k)
+	}
+
+	leaves := make([][]byte, len(keys))
+	for i, key := range keys {
+		leaves[i] = stateLeaf([]byte(key), s.data[key])
+	}
+
+	proof, err := NewMerkleProof(leaves, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StateProof{
+		Key:   k,
+		Value: s.data[string(k)],
+		Proof: proof,
+	}, nil
+}
+
+func (s *State) sortedKeys() []string {
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// stateLeaf encodes a key value pair as a merkle leaf, the key is length
+// prefixed so that different pairs can never produce the same leaf.
+func stateLeaf(k, v []byte) []byte {
+	buf := make([]byte, 4, 4+len(k)+len(v))
+	binary.BigEndian.PutUint32(buf, uint32(len(k)))
+	buf = append(buf, k...)
+
+	return append(buf, v...)
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateRevertToSnapshot(t *testing.T) {
+	s := NewState()
+	assert.Nil(t, s.Put([]byte("foo"), []byte("1")))
+
+	snapshot := s.Snapshot()
+	assert.Nil(t, s.Put([]byte("foo"), []byte("2")))
+	assert.Nil(t, s.Put([]byte("bar"), []byte("3")))
+	assert.Nil(t, s.Delete([]byte("foo")))
+
+	s.RevertToSnapshot(snapshot)
+
+	value, err := s.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	_, err = s.Get([]byte("bar"))
+	assert.NotNil(t, err)
+}
+
+func TestStateCopy(t *testing.T) {
+	s := NewState()
+	assert.Nil(t, s.Put([]byte("foo"), []byte("1")))
+
+	cp := s.Copy()
+	assert.Nil(t, cp.Put([]byte("foo"), []byte("2")))
+
+	value, err := s.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+	assert.NotEqual(t, s.Root(), cp.Root())
+}
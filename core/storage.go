@@ -1,15 +1,43 @@
 package core
 
+import (
+	"fmt"
+	"sync"
+)
+
 type Storage interface {
 	Put(*Block) error
+	Get(height uint32) (*Block, error)
 }
 
-type MemoryStore struct{}
+type MemoryStore struct {
+	lock   sync.RWMutex
+	blocks map[uint32]*Block
+}
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{}
+	return &MemoryStore{
+		blocks: make(map[uint32]*Block),
+	}
 }
 
 func (s *MemoryStore) Put(b *Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.blocks[b.Height] = b
+
 	return nil
 }
+
+func (s *MemoryStore) Get(height uint32) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	b, ok := s.blocks[height]
+	if !ok {
+		return nil, fmt.Errorf("block with height (%d) not found", height)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,197 @@
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// Tracer receives every step of the execution of the VM. Only code of
+// VMVersion1 or later can be traced.
+type Tracer interface {
+	// CaptureStep is called once an instruction is executed, also when it
+	// failed.
+	CaptureStep(step *Step)
+	// CaptureEnd is called once the execution is over.
+	CaptureEnd(gasUsed uint64, err error)
+}
+
+// Step is a single executed instruction.
+type Step struct {
+	// Depth is the number of calls that lead to the executing VM.
+	Depth int
+	IP    int
+	Op    Instruction
+	// Gas is the gas left before the instruction and GasCost the gas it used.
+	Gas     uint64
+	GasCost uint64
+	// Stack is the stack before the instruction, the top is the last value.
+	Stack []Value
+	// Writes are the writes of the instruction to the contract storage.
+	Writes []StateWrite
+	Err    error
+}
+
+// StateWrite is a write to the storage of a contract, a deleted key has no
+// value.
+type StateWrite struct {
+	Key     []byte
+	Value   []byte
+	Deleted bool
+}
+
+func (w StateWrite) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Key     string `json:"key"`
+		Value   string `json:"value,omitempty"`
+		Deleted bool   `json:"deleted,omitempty"`
+	}{
+		Key:     "0x" + hex.EncodeToString(w.Key),
+		Value:   hexOrEmpty(w.Value, w.Deleted),
+		Deleted: w.Deleted,
+	})
+}
+
+func hexOrEmpty(b []byte, empty bool) string {
+	if empty {
+		return ""
+	}
+	return "0x" + hex.EncodeToString(b)
+}
+
+func (s *Step) MarshalJSON() ([]byte, error) {
+	stack := make([]string, len(s.Stack))
+	for i, v := range s.Stack {
+		stack[i] = v.String()
+	}
+
+	var errMsg string
+	if s.Err != nil {
+		errMsg = s.Err.Error()
+	}
+
+	return json.Marshal(struct {
+		Depth   int          `json:"depth"`
+		IP      int          `json:"ip"`
+		Op      string       `json:"op"`
+		Gas     uint64       `json:"gas"`
+		GasCost uint64       `json:"gasCost"`
+		Stack   []string     `json:"stack"`
+		Writes  []StateWrite `json:"writes,omitempty"`
+		Err     string       `json:"error,omitempty"`
+	}{
+		Depth:   s.Depth,
+		IP:      s.IP,
+		Op:      s.Op.String(),
+		Gas:     s.Gas,
+		GasCost: s.GasCost,
+		Stack:   stack,
+		Writes:  s.Writes,
+		Err:     errMsg,
+	})
+}
+
+// Trace is the structured trace of an execution, it is collected by a
+// StepLogger.
+type Trace struct {
+	GasUsed uint64  `json:"gasUsed"`
+	Failed  bool    `json:"failed"`
+	Err     string  `json:"error,omitempty"`
+	Steps   []*Step `json:"steps"`
+}
+
+// StepLogger is a Tracer that keeps every step.
+type StepLogger struct {
+	trace Trace
+}
+
+func NewStepLogger() *StepLogger {
+	return &StepLogger{
+		trace: Trace{Steps: []*Step{}},
+	}
+}
+
+func (l *StepLogger) CaptureStep(step *Step) {
+	l.trace.Steps = append(l.trace.Steps, step)
+}
+
+func (l *StepLogger) CaptureEnd(gasUsed uint64, err error) {
+	l.trace.GasUsed = gasUsed
+	if err != nil {
+		l.trace.Failed = true
+		l.trace.Err = err.Error()
+	}
+}
+
+// Trace returns the collected trace.
+func (l *StepLogger) Trace() *Trace {
+	return &l.trace
+}
+
+// tracingState records the writes of the code to the contract state.
+type tracingState struct {
+	ContractState
+	writes []StateWrite
+}
+
+func (s *tracingState) Put(k, v []byte) error {
+	s.writes = append(s.writes, StateWrite{Key: k, Value: v})
+	return s.ContractState.Put(k, v)
+}
+
+func (s *tracingState) Delete(k []byte) error {
+	s.writes = append(s.writes, StateWrite{Key: k, Deleted: true})
+	return s.ContractState.Delete(k)
+}
+
+// takeWrites returns the writes recorded since the last call.
+func (s *tracingState) takeWrites() []StateWrite {
+	writes := s.writes
+	s.writes = nil
+	return writes
+}
+
+// TraceTransaction executes the transaction with the given hash again with
+// the tracer, against the state of the parent of its block and after the
+// transactions before it in the block. It returns the receipt of the traced
+// execution, or ErrNotTraceable when the transaction runs code that can not
+// be traced. The state of the chain is not modified.
+func (bc *Blockchain) TraceTransaction(hash types.Hash, tracer Tracer) (*Receipt, error) {
+	for height := uint32(1); height <= bc.Height(); height++ {
+		b, err := bc.GetBlock(height)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, tx := range b.Transactions {
+			if tx.Hash(TxHasher{}) != hash {
+				continue
+			}
+
+			parent, err := bc.stateAt(height - 1)
+			if err != nil {
+				return nil, err
+			}
+			state := parent.Copy()
+
+			for _, prev := range b.Transactions[:i] {
+				bc.executeTx(prev, state, nil)
+			}
+
+			receipt, _, err := bc.executeTx(tx, state, tracer)
+			if errors.Is(err, ErrNotTraceable) {
+				return nil, fmt.Errorf("transaction (%s): %w", hash, err)
+			}
+			for _, l := range receipt.Logs {
+				l.Height = height
+			}
+
+			return receipt, nil
+		}
+	}
+
+	return nil, fmt.Errorf("transaction (%s) not found", hash)
+}
@@ -0,0 +1,217 @@
+package core
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func stackStrings(values []Value) []string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = v.String()
+	}
+	return s
+}
+
+func TestTracer(t *testing.T) {
+	// push 2, push 3, add, byte 'K', push 1, pack, store
+	data := []byte{0x02, 0x0a, 0x03, 0x0a, 0x0b, 0x4b, 0x0c, 0x01, 0x0a, 0x0d, 0x0f}
+
+	tracer := NewStepLogger()
+	vm := NewVM(data, NewState(), DefaultTxGasLimit)
+	vm.SetTracer(tracer)
+	assert.Nil(t, vm.Run())
+
+	trace := tracer.Trace()
+	assert.False(t, trace.Failed)
+	assert.Equal(t, vm.GasUsed(), trace.GasUsed)
+	assert.Equal(t, 7, len(trace.Steps))
+
+	ops := []Instruction{InstrPush, InstrPush, InstrAdd, InstrByte, InstrPush, InstrPack, InstrStore}
+	ips := []int{1, 3, 4, 6, 8, 9, 10}
+
+	var gasCost uint64
+	for i, step := range trace.Steps {
+		assert.Equal(t, ops[i], step.Op)
+		assert.Equal(t, ips[i], step.IP)
+		assert.Equal(t, DefaultTxGasLimit-gasCost, step.Gas)
+		gasCost += step.GasCost
+	}
+	assert.Equal(t, vm.GasUsed(), gasCost)
+
+	add := trace.Steps[2]
+	assert.Equal(t, []string{"2", "3"}, stackStrings(add.Stack))
+	assert.Equal(t, InstrAdd.Gas(), add.GasCost)
+	assert.Nil(t, add.Writes)
+
+	store := trace.Steps[6]
+	assert.Equal(t, []string{"5", "0x4b"}, stackStrings(store.Stack))
+	assert.Equal(t, InstrStore.Gas()+(1+32)*gasStorePerByte, store.GasCost)
+	assert.Equal(t, []StateWrite{{Key: []byte("K"), Value: NewWordValue(big.NewInt(5)).Serialize()}}, store.Writes)
+}
+
+func TestTracerFailedStep(t *testing.T) {
+	// push 1, push 0, div
+	tracer := NewStepLogger()
+	vm := NewVM([]byte{0x01, 0x0a, 0x00, 0x0a, 0x11}, NewState(), DefaultTxGasLimit)
+	vm.SetTracer(tracer)
+	assert.Equal(t, ErrDivisionByZero, vm.Run())
+
+	trace := tracer.Trace()
+	assert.True(t, trace.Failed)
+	assert.Equal(t, ErrDivisionByZero.Error(), trace.Err)
+	assert.Equal(t, ErrDivisionByZero, trace.Steps[2].Err)
+}
+
+func TestTraceJSON(t *testing.T) {
+	// push 7, byte 'K', push 1, pack, store, byte 'K', push 1, pack, delete
+	data := []byte{0x07, 0x0a, 0x4b, 0x0c, 0x01, 0x0a, 0x0d, 0x0f, 0x4b, 0x0c, 0x01, 0x0a, 0x0d, 0x1c}
+
+	tracer := NewStepLogger()
+	vm := NewVM(data, NewState(), DefaultTxGasLimit)
+	vm.SetTracer(tracer)
+	assert.Nil(t, vm.Run())
+
+	b, err := json.Marshal(tracer.Trace())
+	assert.Nil(t, err)
+
+	var trace struct {
+		GasUsed uint64 `json:"gasUsed"`
+		Failed  bool   `json:"failed"`
+		Steps   []struct {
+			IP      int      `json:"ip"`
+			Op      string   `json:"op"`
+			Gas     uint64   `json:"gas"`
+			GasCost uint64   `json:"gasCost"`
+			Stack   []string `json:"stack"`
+			Writes  []struct {
+				Key     string `json:"key"`
+				Value   string `json:"value"`
+				Deleted bool   `json:"deleted"`
+			} `json:"writes"`
+		} `json:"steps"`
+	}
+	assert.Nil(t, json.Unmarshal(b, &trace))
+
+	assert.Equal(t, vm.GasUsed(), trace.GasUsed)
+	assert.Equal(t, 9, len(trace.Steps))
+
+	store := trace.Steps[4]
+	assert.Equal(t, "STORE", store.Op)
+	assert.Equal(t, []string{"7", "0x4b"}, store.Stack)
+	assert.Equal(t, "0x4b", store.Writes[0].Key)
+	assert.Equal(t, "0x0000000000000000000000000000000000000000000000000000000000000007", store.Writes[0].Value)
+
+	del := trace.Steps[8]
+	assert.Equal(t, "DELETE", del.Op)
+	assert.Equal(t, "0x4b", del.Writes[0].Key)
+	assert.Equal(t, "", del.Writes[0].Value)
+	assert.True(t, del.Writes[0].Deleted)
+}
+
+func TestVMStep(t *testing.T) {
+	// push 2, push 3, sub
+	vm := NewVM([]byte{0x02, 0x0a, 0x03, 0x0a, 0x0e}, NewState(), DefaultTxGasLimit)
+	assert.Equal(t, 0, vm.IP())
+
+	done, err := vm.Step()
+	assert.Nil(t, err)
+	assert.False(t, done)
+	assert.Equal(t, 2, vm.IP())
+	assert.Equal(t, []string{"2"}, stackStrings(vm.Stack()))
+
+	done, err = vm.Step()
+	assert.Nil(t, err)
+	assert.False(t, done)
+	assert.Equal(t, []string{"2", "3"}, stackStrings(vm.Stack()))
+
+	done, err = vm.Step()
+	assert.Nil(t, err)
+	assert.True(t, done)
+	assert.Equal(t, 1, len(vm.Stack()))
+
+	// Stepping past the end does nothing
+	done, err = vm.Step()
+	assert.Nil(t, err)
+	assert.True(t, done)
+}
+
+func TestTraceTransaction(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	addr := deployWithKey(t, bc, privKey, counterCode)
+
+	first := NewCallTransaction(addr, []byte{2})
+	assert.Nil(t, first.Sign(privKey))
+	second := NewCallTransaction(addr, []byte{3})
+	assert.Nil(t, second.Sign(privKey))
+	addBlockWithTxx(t, bc, privKey, first, second)
+
+	receipts, err := bc.GetReceipts(bc.Height())
+	assert.Nil(t, err)
+
+	// A later block does not change the traced state
+	call(t, bc, privKey, addr, []byte{10})
+	assert.Equal(t, "15", storedWord(t, bc, addr, "C"))
+
+	tracer := NewStepLogger()
+	receipt, err := bc.TraceTransaction(second.Hash(TxHasher{}), tracer)
+	assert.Nil(t, err)
+	assert.Equal(t, receipts[1], receipt)
+
+	trace := tracer.Trace()
+	assert.False(t, trace.Failed)
+	assert.Equal(t, receipt.GasUsed, trace.GasUsed)
+
+	// The value written by the first transaction of the block is read
+	store := trace.Steps[len(trace.Steps)-1]
+	assert.Equal(t, InstrStore, store.Op)
+	assert.Equal(t, NewWordValue(big.NewInt(5)).Serialize(), store.Writes[0].Value)
+
+	assert.Equal(t, "15", storedWord(t, bc, addr, "C"))
+
+	_, err = bc.TraceTransaction(types.RandomHash(), NewStepLogger())
+	assert.NotNil(t, err)
+}
+
+func TestTraceLegacyTransaction(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	tx := NewTransaction([]byte{0x01, 0x0a})
+	tx.VMVersion = VMVersionLegacy
+	assert.Nil(t, tx.Sign(privKey))
+	addBlockWithTxx(t, bc, privKey, tx)
+
+	_, err := bc.TraceTransaction(tx.Hash(TxHasher{}), NewStepLogger())
+	assert.ErrorIs(t, err, ErrNotTraceable)
+}
+
+func TestTraceCallToLegacyContract(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	deploy := NewDeployTransaction([]byte{0x01, 0x0a}) // push 1
+	deploy.VMVersion = VMVersionLegacy
+	assert.Nil(t, deploy.Sign(privKey))
+	addBlockWithTxx(t, bc, privKey, deploy)
+	receipts, err := bc.GetReceipts(bc.Height())
+	assert.Nil(t, err)
+	legacy := receipts[0].ContractAddress
+
+	caller := deployWithKey(t, bc, privKey, callerCode(legacy, allGas...))
+	receipt := call(t, bc, privKey, caller, nil)
+	assert.Equal(t, "", receipt.Err)
+	assert.Equal(t, "1", storedWord(t, bc, caller, "S"))
+
+	// The legacy callee can not be traced, the trace of the caller alone
+	// would not be the execution of the block
+	_, err = bc.TraceTransaction(receipt.TxHash, NewStepLogger())
+	assert.ErrorIs(t, err, ErrNotTraceable)
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bytes"
 	"fmt"
 
 	"github.com/anthoai97/blockchain-from-scratch/crypto"
@@ -8,12 +9,21 @@ import (
 )
 
 type Transaction struct {
-	Data []byte
+	Type TxType
+	// To is the address of the called contract.
+	To types.Address
+	// VMVersion is the bytecode version of Data, it selects the VM that runs
+	// it.
+	VMVersion uint8
+	Data      []byte
+	// GasLimit is the maximum gas the execution may use, DefaultTxGasLimit
+	// when 0.
+	GasLimit uint64
 
 	From      crypto.PublicKey
 	Signature *crypto.Signature
 
-	// cache version of data hash
+	// cache version of the hash
 	hash types.Hash
 	// firstSeen is the timestamp of when this tx is first seen locally
 	fristSeen int64
@@ -21,18 +31,111 @@ type Transaction struct {
 
 func NewTransaction(data []byte) *Transaction {
 	return &Transaction{
-		Data: data,
+		VMVersion: LatestVMVersion,
+		Data:      data,
 	}
 }
 
+// NewDeployTransaction returns a transaction that deploys the code as a new
+// contract.
+func NewDeployTransaction(code []byte) *Transaction {
+	tx := NewTransaction(code)
+	tx.Type = TxTypeDeploy
+	return tx
+}
+
+// NewCallTransaction returns a transaction that runs the code of the contract
+// at the given address with the call data.
+func NewCallTransaction(to types.Address, callData []byte) *Transaction {
+	tx := NewTransaction(callData)
+	tx.Type = TxTypeCall
+	tx.To = to
+	return tx
+}
+
+// Gas returns the gas limit of the execution of the transaction.
+func (tx *Transaction) Gas() uint64 {
+	if tx.GasLimit == 0 {
+		return DefaultTxGasLimit
+	}
+	return tx.GasLimit
+}
+
+// Bytes returns the canonical encoding of the transaction.
+func (tx *Transaction) Bytes() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	cw := &canonicalWriter{w: buf}
+	cw.writeTransaction(tx)
+	if cw.err != nil {
+		return nil, cw.err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Sender returns the address of the signer of the transaction, the zero
+// address when it is not signed.
+func (tx *Transaction) Sender() types.Address {
+	key, err := tx.SenderKey()
+	if err != nil {
+		return types.Address{}
+	}
+	return key.Address()
+}
+
+// SenderKey returns the public key of the signer of the transaction, From or
+// when it is not set the key recovered from a recoverable signature and the
+// signing digest. A recovered key always verifies, a tampered transaction
+// has another sender.
+func (tx *Transaction) SenderKey() (crypto.PublicKey, error) {
+	if tx.From.Key != nil {
+		return tx.From, nil
+	}
+	if tx.Signature == nil || !tx.Signature.Recoverable() {
+		return crypto.PublicKey{}, fmt.Errorf("transaction has no sender")
+	}
+
+	digest, err := tx.SigningDigest()
+	if err != nil {
+		return crypto.PublicKey{}, err
+	}
+
+	return tx.Signature.RecoverPublicKey(digest)
+}
+
+// SigningDigest returns the digest signed by the sender, it covers every
+// field of the transaction but the sender and the signature.
+func (tx *Transaction) SigningDigest() (types.Hash, error) {
+	buf := &bytes.Buffer{}
+	cw := &canonicalWriter{w: buf}
+	cw.writeUnsignedTx(tx)
+	if cw.err != nil {
+		return types.Hash{}, cw.err
+	}
+
+	return crypto.Digest(txSigningDomain, buf.Bytes()), nil
+}
+
+// Sign signs the transaction with the private key. From is left empty when
+// the signature is recoverable so that the sender is never sent along, see
+// SenderKey.
 func (tx *Transaction) Sign(privKey crypto.PrivateKey) error {
-	sig, err := privKey.Sign(tx.Data)
+	digest, err := tx.SigningDigest()
 	if err != nil {
 		return err
 	}
 
-	tx.From = privKey.PublicKey()
+	sig, err := privKey.SignDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	tx.From = crypto.PublicKey{}
+	if !sig.Recoverable() {
+		tx.From = privKey.PublicKey()
+	}
 	tx.Signature = sig
+	tx.hash = types.Hash{}
 	return nil
 }
 
@@ -41,10 +144,25 @@ func (tx *Transaction) Verify() error {
 		return fmt.Errorf("tx hash no signature")
 	}
 
-	if !tx.Signature.Verify(tx.From, tx.Data) {
+	from, err := tx.SenderKey()
+	if err != nil {
+		return err
+	}
+
+	digest, err := tx.SigningDigest()
+	if err != nil {
+		return err
+	}
+
+	if !tx.Signature.VerifyDigest(from, digest) {
 		return fmt.Errorf("invalid transaction signature")
 	}
 
+	// The hash of the transaction is the one of its encoding
+	if _, err := tx.Bytes(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,6 +35,119 @@ func TestVerifyTransaction(t *testing.T) {
 	assert.NotNil(t, tx.Verify())
 }
 
+func TestTransactionSignatureCoversAllFields(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	tx := NewCallTransaction(types.Address{1}, bytes.Repeat([]byte{0xab}, 100))
+	tx.GasLimit = 5000
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, tx.Verify())
+
+	for i := range tx.Data {
+		tampered := *tx
+		tampered.Data = append([]byte{}, tx.Data...)
+		tampered.Data[i] ^= 0x01
+		assert.NotNil(t, tampered.Verify(), "byte %d", i)
+	}
+
+	tampers := []func(tx *Transaction){
+		func(tx *Transaction) { tx.Type = TxTypeScript },
+		func(tx *Transaction) { tx.To = types.Address{2} },
+		func(tx *Transaction) { tx.VMVersion = VMVersionLegacy },
+		func(tx *Transaction) { tx.GasLimit++ },
+		func(tx *Transaction) { tx.Data = tx.Data[:99] },
+	}
+	for i, tamper := range tampers {
+		tampered := *tx
+		tamper(&tampered)
+		assert.NotNil(t, tampered.Verify(), "tamper %d", i)
+	}
+}
+
+func TestTransactionSigningDomain(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	tx := NewTransaction([]byte("foo"))
+
+	// A signature over the raw encoding is not valid
+	sig, err := privKey.Sign(tx.Data)
+	assert.Nil(t, err)
+	tx.From = privKey.PublicKey()
+	tx.Signature = sig
+	assert.NotNil(t, tx.Verify())
+}
+
+func TestTxRecoverSender(t *testing.T) {
+	privKey, err := crypto.GenerateKey(crypto.Secp256k1)
+	assert.Nil(t, err)
+	tx := NewTransaction([]byte("foo"))
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, tx.From.Key)
+	address := privKey.PublicKey().Address()
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(NewCanonicalTxEncoder(buf)))
+	withoutFrom := buf.Len()
+
+	// The sender is not on the wire, it is recovered
+	txDecoded := new(Transaction)
+	assert.Nil(t, txDecoded.Decode(NewCanonicalTxDecoder(buf)))
+	assert.Equal(t, privKey.PublicKey(), txDecoded.From)
+	assert.Nil(t, txDecoded.Verify())
+
+	notRecoverable := *tx
+	notRecoverable.From = privKey.PublicKey()
+	notRecoverable.Signature = &crypto.Signature{Algorithm: tx.Signature.Algorithm, R: tx.Signature.R, S: tx.Signature.S}
+	buf.Reset()
+	assert.Nil(t, notRecoverable.Encode(NewCanonicalTxEncoder(buf)))
+	assert.Equal(t, withoutFrom+len(notRecoverable.From.Key), buf.Len())
+
+	assert.Equal(t, address, tx.Sender())
+	assert.Nil(t, tx.Verify())
+
+	// A tampered transaction is signed by someone else
+	tx.Data = []byte("bar")
+	assert.NotEqual(t, address, tx.Sender())
+}
+
+func TestTxGobRecoverableSenderSize(t *testing.T) {
+	privKey, err := crypto.GenerateKey(crypto.Secp256k1)
+	assert.Nil(t, err)
+	tx := NewTransaction([]byte("foo"))
+	assert.Nil(t, tx.Sign(privKey))
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(NewGobTxEncoder(buf)))
+	size := buf.Len()
+
+	txDecoded := new(Transaction)
+	assert.Nil(t, txDecoded.Decode(NewGobTxDecoder(buf)))
+	assert.Nil(t, txDecoded.Verify())
+	assert.Equal(t, privKey.PublicKey().Address(), txDecoded.Sender())
+
+	// The sender would take the bytes of its key on the wire
+	withFrom := *tx
+	withFrom.From = privKey.PublicKey()
+	buf.Reset()
+	assert.Nil(t, withFrom.Encode(NewGobTxEncoder(buf)))
+	assert.GreaterOrEqual(t, buf.Len()-size, len(withFrom.From.Key))
+}
+
+func TestTxP256SenderOnTheWire(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	tx := NewTransaction([]byte("foo"))
+	assert.Nil(t, tx.Sign(privKey))
+	assert.False(t, tx.Signature.Recoverable())
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(NewCanonicalTxEncoder(buf)))
+	txDecoded := new(Transaction)
+	assert.Nil(t, txDecoded.Decode(NewCanonicalTxDecoder(buf)))
+	assert.Equal(t, privKey.PublicKey(), txDecoded.From)
+	assert.Nil(t, txDecoded.Verify())
+
+	tx.From = crypto.PublicKey{}
+	assert.NotNil(t, tx.Verify())
+}
+
 func TestTxEncodeDecode(t *testing.T) {
 	tx := randomTxWithSignature(t)
 	buf := &bytes.Buffer{}
@@ -53,3 +167,14 @@ func randomTxWithSignature(t *testing.T) *Transaction {
 	assert.Nil(t, tx.Sign(privateKey))
 	return tx
 }
+
+func TestTxJSONEncodeDecode(t *testing.T) {
+	tx := randomTxWithSignature(t)
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(NewJSONTxEncoder(buf)))
+
+	txDecoded := new(Transaction)
+	assert.Nil(t, txDecoded.Decode(NewJSONTxDecoder(buf)))
+	assert.Equal(t, tx.Hash(TxHasher{}), txDecoded.Hash(TxHasher{}))
+	assert.Nil(t, txDecoded.Verify())
+}
@@ -1,6 +1,10 @@
 package core
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
 
 type Validator interface {
 	ValidateBlock(*Block) error
@@ -35,9 +39,44 @@ func (v *BlockValidator) ValidateBlock(b *Block) error {
 		return fmt.Errorf("the hash of previous block (%s) is invalid", b.PrevBlockHash)
 	}
 
+	if b.Version > HeaderVersion || b.Version < prevHeader.Version {
+		return fmt.Errorf("block (%s) has an invalid version (%d) after version (%d)", b.Hash(BlockHasher{}), b.Version, prevHeader.Version)
+	}
+	if !b.HasLogsBloom() && b.LogsBloom != (types.Bloom{}) {
+		return fmt.Errorf("block (%s) of version (%d) can not have a logs bloom", b.Hash(BlockHasher{}), b.Version)
+	}
+	if !b.HasCommitField() && b.Commit != nil {
+		return fmt.Errorf("block (%s) of version (%d) can not have a commit", b.Hash(BlockHasher{}), b.Version)
+	}
+
+	if !v.bc.AllowsSignatureAlgorithm(b.Validator.Algorithm) {
+		return fmt.Errorf("block (%s) is signed with a %s key which the chain does not allow", b.Hash(BlockHasher{}), b.Validator.Algorithm)
+	}
+	for _, tx := range b.Transactions {
+		if tx.Type == TxTypeChainParams {
+			return fmt.Errorf("transaction (%s) sets the chain params out of the genesis block", tx.Hash(TxHasher{}))
+		}
+		from, err := tx.SenderKey()
+		if err != nil {
+			return err
+		}
+		if !v.bc.AllowsSignatureAlgorithm(from.Algorithm) {
+			return fmt.Errorf("transaction (%s) is signed with a %s key which the chain does not allow", tx.Hash(TxHasher{}), from.Algorithm)
+		}
+	}
+
 	if err := b.Verify(); err != nil {
 		return err
 	}
 
+	if vs := v.bc.ValidatorSet(); vs != nil {
+		if b.Commit == nil {
+			return fmt.Errorf("block (%s) has no commit", b.Hash(BlockHasher{}))
+		}
+		if err := b.Commit.Verify(vs, b.Header); err != nil {
+			return fmt.Errorf("block (%s) commit: %s", b.Hash(BlockHasher{}), err)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"encoding/hex"
+	"math/big"
+)
+
+const wordSize = 32
+
+// wordModulus is 2^256, words wrap around modulo it.
+var wordModulus = new(big.Int).Lsh(big.NewInt(1), 8*wordSize)
+
+// Value is a value on the stack of the VM, either a 256 bit unsigned word or
+// a byte slice. The zero Value is the empty byte slice.
+type Value struct {
+	word  *big.Int
+	bytes []byte
+}
+
+// NewWordValue returns the word x modulo 2^256.
+func NewWordValue(x *big.Int) Value {
+	return Value{
+		word: new(big.Int).Mod(x, wordModulus),
+	}
+}
+
+func NewBytesValue(b []byte) Value {
+	return Value{
+		bytes: b,
+	}
+}
+
+func (v Value) IsWord() bool {
+	return v.word != nil
+}
+
+// Word returns the value as a word, bytes of at most 32 bytes are read as a
+// big endian number.
+func (v Value) Word() (*big.Int, error) {
+	if v.word != nil {
+		return v.word, nil
+	}
+	if len(v.bytes) > wordSize {
+		return nil, ErrTypeMismatch
+	}
+	return new(big.Int).SetBytes(v.bytes), nil
+}
+
+// Bytes returns the value as bytes, a word is not converted.
+func (v Value) Bytes() ([]byte, error) {
+	if v.word != nil {
+		return nil, ErrTypeMismatch
+	}
+	return v.bytes, nil
+}
+
+// Serialize returns the bytes of the value as written to the state, a word
+// is written as 32 bytes big endian.
+func (v Value) Serialize() []byte {
+	if v.word != nil {
+		return v.word.FillBytes(make([]byte, wordSize))
+	}
+	return v.bytes
+}
+
+func (v Value) String() string {
+	if v.word != nil {
+		return v.word.String()
+	}
+	return "0x" + hex.EncodeToString(v.bytes)
+}
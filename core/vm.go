@@ -1,133 +1,845 @@
 package core
 
 import (
-	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+var (
+	// ErrOutOfGas is returned when the execution used up the gas limit.
+	ErrOutOfGas = errors.New("out of gas")
+	// ErrInvalidJump is returned when jumping outside of the code or in
+	// the middle of an instruction.
+	ErrInvalidJump = errors.New("invalid jump destination")
+	// ErrDivisionByZero is returned by InstrDiv and InstrMod.
+	ErrDivisionByZero = errors.New("division by zero")
+	// ErrStackUnderflow is returned when popping from an empty stack.
+	ErrStackUnderflow = errors.New("stack underflow")
+	// ErrStackOverflow is returned when pushing on a full stack.
+	ErrStackOverflow = errors.New("stack overflow")
+	// ErrTypeMismatch is returned when an instruction pops a value of the
+	// wrong type.
+	ErrTypeMismatch = errors.New("type mismatch")
+	// ErrInvalidOpcode is returned when executing a byte that is not an
+	// instruction.
+	ErrInvalidOpcode = errors.New("invalid opcode")
+	// ErrEmptyCode is returned when running a VM without code.
+	ErrEmptyCode = errors.New("empty code")
+	// ErrTooManyTopics is returned by InstrLog for more than maxLogTopics
+	// topics.
+	ErrTooManyTopics = errors.New("too many log topics")
+	// ErrInvalidMemorySlot is returned when accessing a memory slot outside
+	// of the memory.
+	ErrInvalidMemorySlot = errors.New("invalid memory slot")
+	// ErrReverted is returned when the code executed InstrRevert.
+	ErrReverted = errors.New("execution reverted")
+	// ErrNotTraceable is returned when a traced execution runs code of a VM
+	// version that can not be traced, it ends the whole execution.
+	ErrNotTraceable = errors.New("vm version can not be traced")
 )
 
+// errStop ends the execution without error, it is returned by InstrReturn.
+var errStop = errors.New("stop")
+
 type Instruction byte
 
+// Bytecode versions, the version of a transaction selects the VM that runs
+// its code. Both versions share the instruction set and the encoding of the
+// code: PUSH and BYTE read their operand from the byte before them.
 const (
-	InstrPush  Instruction = 0x0a // 10
-	InstrAdd   Instruction = 0x0b // 11
-	InstrByte  Instruction = 0x0c
-	InstrPack  Instruction = 0x0d
-	InstrSub   Instruction = 0x0e
-	InstrStore Instruction = 0x0f
+	// VMVersionLegacy pops the oldest value of the stack first and holds Go
+	// ints, bytes and byte slices.
+	VMVersionLegacy uint8 = 0
+	// VMVersion1 has a LIFO stack of 256 bit words and byte slices.
+	VMVersion1 uint8 = 1
+
+	// LatestVMVersion is the version of new transactions.
+	LatestVMVersion = VMVersion1
 )
 
-type Stack struct {
-	data []any
-	sp   int
+// The operand order below is the one of VMVersion1, where b is the top of
+// the stack and a the value below it, so push a, push b, sub computes a - b.
+// Words wrap around modulo 2^256 and comparisons are unsigned. Storage only
+// holds bytes, instructions that take a word also accept at most 32 bytes
+// which are read as a big endian number.
+//
+// VMVersionLegacy pops a first and then b from the front of the stack, the
+// other instructions pop their operands in the same order as listed here.
+const (
+	InstrPush     Instruction = 0x0a // push the operand as word
+	InstrAdd      Instruction = 0x0b // a b -> a + b
+	InstrByte     Instruction = 0x0c // push the operand as word, a byte in legacy code
+	InstrPack     Instruction = 0x0d // x1 .. xn n -> bytes x1 .. xn
+	InstrSub      Instruction = 0x0e // a b -> a - b
+	InstrStore    Instruction = 0x0f // value key -> , a word is stored as 32 bytes
+	InstrMul      Instruction = 0x10 // a b -> a * b
+	InstrDiv      Instruction = 0x11 // a b -> a / b
+	InstrMod      Instruction = 0x12 // a b -> a % b
+	InstrEq       Instruction = 0x13 // a b -> 1 if a == b else 0
+	InstrLt       Instruction = 0x14 // a b -> 1 if a < b else 0
+	InstrGt       Instruction = 0x15 // a b -> 1 if a > b else 0
+	InstrNot      Instruction = 0x16 // a -> 1 if a == 0 else 0
+	InstrAnd      Instruction = 0x17 // a b -> a & b
+	InstrOr       Instruction = 0x18 // a b -> a | b
+	InstrJump     Instruction = 0x19 // dest -> , jump to dest
+	InstrJumpI    Instruction = 0x1a // cond dest -> , jump to dest if cond != 0
+	InstrGet      Instruction = 0x1b // key -> value, empty bytes when missing
+	InstrDelete   Instruction = 0x1c // key -> , remove key from the state
+	InstrRot      Instruction = 0x1d // x1 .. xn -> xn x1 .. xn-1
+	InstrCallData Instruction = 0x1e // -> call data as bytes
+	InstrLog      Instruction = 0x1f // data t1 .. tn n -> , emit a log with n topics
+	InstrMLoad    Instruction = 0x20 // slot -> value of the memory slot
+	InstrMStore   Instruction = 0x21 // value slot -> , write the memory slot
+	InstrCall     Instruction = 0x22 // addr data gas -> returned ok, call the contract at addr
+	InstrReturn   Instruction = 0x23 // data -> , end the execution with data as return data
+	InstrRevert   Instruction = 0x24 // data -> , fail the execution with data as return data
+)
+
+var instrNames = map[Instruction]string{
+	InstrPush:     "PUSH",
+	InstrAdd:      "ADD",
+	InstrByte:     "BYTE",
+	InstrPack:     "PACK",
+	InstrSub:      "SUB",
+	InstrStore:    "STORE",
+	InstrMul:      "MUL",
+	InstrDiv:      "DIV",
+	InstrMod:      "MOD",
+	InstrEq:       "EQ",
+	InstrLt:       "LT",
+	InstrGt:       "GT",
+	InstrNot:      "NOT",
+	InstrAnd:      "AND",
+	InstrOr:       "OR",
+	InstrJump:     "JUMP",
+	InstrJumpI:    "JUMPI",
+	InstrGet:      "GET",
+	InstrDelete:   "DELETE",
+	InstrRot:      "ROT",
+	InstrCallData: "CALLDATA",
+	InstrLog:      "LOG",
+	InstrMLoad:    "MLOAD",
+	InstrMStore:   "MSTORE",
+	InstrCall:     "CALL",
+	InstrReturn:   "RETURN",
+	InstrRevert:   "REVERT",
 }
 
-func NewStack(size int) *Stack {
-	return &Stack{
-		data: make([]any, size),
-		sp:   0,
+// IsValid tells if the byte is an instruction.
+func (instr Instruction) IsValid() bool {
+	_, ok := instrNames[instr]
+	return ok
+}
+
+func (instr Instruction) String() string {
+	if name, ok := instrNames[instr]; ok {
+		return name
 	}
+	return fmt.Sprintf("0x%02x", byte(instr))
 }
 
-func (s *Stack) Push(v any) {
-	s.data[s.sp] = v
-	s.sp++
+// HasOperand tells if the instruction reads the byte before it as operand.
+func (instr Instruction) HasOperand() bool {
+	return instr == InstrPush || instr == InstrByte
 }
 
-func (s *Stack) Pop() any {
-	value := s.data[0]
-	s.data = append(s.data[:0], s.data[1:]...)
-	s.sp--
+// operandPositions marks the bytes of the code that are the operand of the
+// instruction following them, they are never executed themselves.
+func operandPositions(code []byte) []bool {
+	operands := make([]bool, len(code))
+
+	for i := 0; i < len(code); i++ {
+		if i+1 < len(code) && Instruction(code[i+1]).HasOperand() {
+			operands[i] = true
+			i++
+		}
+	}
 
-	return value
+	return operands
 }
 
-type VM struct {
+// runVM runs the code with the VM of the given bytecode version against the
+// storage of a contract, depth is the number of calls that lead to it. Legacy
+// code has no access to the call data, can not emit logs or call contracts,
+// has no return data and can not be traced, ErrNotTraceable is returned when
+// the tracer is set. The tracer may be nil. The return data of a failed
+// execution is only kept when it reverted.
+func runVM(version uint8, data, callData []byte, storage contractStorage, gasLimit uint64, tracer Tracer, depth int) (ExecutionResult, error) {
+	switch version {
+	case VMVersionLegacy:
+		if tracer != nil {
+			return ExecutionResult{}, fmt.Errorf("vm version %d: %w", version, ErrNotTraceable)
+		}
+		vm := newLegacyVM(data, storage, gasLimit)
+		err := vm.Run()
+		return ExecutionResult{GasUsed: vm.GasUsed()}, err
+	case VMVersion1:
+		vm := NewVM(data, storage, gasLimit)
+		vm.callData = callData
+		vm.address = storage.addr
+		vm.state = storage.state
+		vm.depth = depth
+		if tracer != nil {
+			vm.SetTracer(tracer)
+		}
+		err := vm.Run()
+
+		result := ExecutionResult{
+			GasUsed: vm.GasUsed(),
+			Logs:    vm.Logs(),
+		}
+		if err == nil || err == ErrReverted {
+			result.ReturnData = vm.ReturnData()
+		}
+		return result, err
+	}
+
+	return ExecutionResult{}, fmt.Errorf("unsupported vm version %d", version)
+}
+
+// program is the part of the execution shared by all versions of the VM.
+type program struct {
 	data          []byte
+	callData      []byte
 	ip            int
-	stack         Stack
-	contractState *State
+	contractState ContractState
+	gasLimit      uint64
+	gasUsed       uint64
+	operands      []bool
 }
 
-func NewVM(data []byte, contractState *State) *VM {
-	return &VM{
+func newProgram(data []byte, contractState ContractState, gasLimit uint64) program {
+	return program{
 		data:          data,
 		ip:            0,
-		stack:         *NewStack(128),
 		contractState: contractState,
+		gasLimit:      gasLimit,
+		operands:      operandPositions(data),
 	}
 }
 
-func (vm *VM) Run() error {
-	for {
-		instr := Instruction(vm.data[vm.ip])
+// jump moves the execution to the given position, which must be the start of
+// an instruction. An instruction with an operand starts at its operand.
+func (p *program) jump(dest int) error {
+	if dest < 0 || dest >= len(p.data) || (dest > 0 && p.operands[dest-1]) {
+		return ErrInvalidJump
+	}
+
+	// run moves to the next instruction once this one is executed
+	p.ip = dest - 1
 
-		if err := vm.Exec(instr); err != nil {
+	return nil
+}
+
+// operand returns the operand of the current instruction, an instruction that
+// has an operand but directly follows another instruction is invalid.
+func (p *program) operand() (byte, error) {
+	if p.ip == 0 || !p.operands[p.ip-1] {
+		return 0, ErrInvalidOpcode
+	}
+	return p.data[p.ip-1], nil
+}
+
+// GasUsed returns the gas used so far, it equals the gas limit when the
+// execution ran out of gas.
+func (p *program) GasUsed() uint64 {
+	return p.gasUsed
+}
+
+func (p *program) useGas(gas uint64) error {
+	if gas > p.gasLimit-p.gasUsed {
+		p.gasUsed = p.gasLimit
+		return ErrOutOfGas
+	}
+
+	p.gasUsed += gas
+	return nil
+}
+
+func (p *program) run(exec func(Instruction) error) error {
+	for {
+		done, err := p.step(exec)
+		if err != nil || done {
 			return err
 		}
+	}
+}
 
-		vm.ip++
+// step executes the next instruction and tells if the end of the code is
+// reached.
+func (p *program) step(exec func(Instruction) error) (bool, error) {
+	if len(p.data) == 0 {
+		return true, ErrEmptyCode
+	}
+	if p.ip >= len(p.data) {
+		return true, nil
+	}
+
+	// Operands are read by the instruction that follows them, the last byte
+	// is never an operand
+	for p.operands[p.ip] {
+		p.ip++
+	}
 
-		if vm.ip > len(vm.data)-1 {
-			break
+	instr := Instruction(p.data[p.ip])
+
+	if err := p.useGas(instr.Gas()); err != nil {
+		return false, err
+	}
+
+	if err := exec(instr); err != nil {
+		if err == errStop {
+			p.ip = len(p.data)
+			return true, nil
 		}
+		return false, err
 	}
 
+	p.ip++
+
+	return p.ip >= len(p.data), nil
+}
+
+// Stack is the LIFO stack of the VM.
+type Stack struct {
+	data []Value
+	size int
+}
+
+func NewStack(size int) *Stack {
+	return &Stack{
+		data: make([]Value, 0, size),
+		size: size,
+	}
+}
+
+func (s *Stack) Len() int {
+	return len(s.data)
+}
+
+func (s *Stack) Push(v Value) error {
+	if len(s.data) >= s.size {
+		return ErrStackOverflow
+	}
+
+	s.data = append(s.data, v)
 	return nil
 }
+
+func (s *Stack) Pop() (Value, error) {
+	if len(s.data) == 0 {
+		return Value{}, ErrStackUnderflow
+	}
+
+	value := s.data[len(s.data)-1]
+	s.data = s.data[:len(s.data)-1]
+
+	return value, nil
+}
+
+type VM struct {
+	program
+	stack Stack
+	// address is the address of the running contract, it is the address of
+	// the logs.
+	address types.Address
+	logs    []*Log
+	// memory holds values for the duration of the execution, it grows up to
+	// maxMemorySlots slots and a slot that was never written is empty.
+	memory []Value
+	// returnData is set by InstrReturn and InstrRevert.
+	returnData []byte
+	returned   bool
+
+	// state holds the contracts the code can call, there are none when it is
+	// nil. depth is the number of calls that lead to this VM.
+	state *State
+	depth int
+
+	tracer       Tracer
+	tracingState *tracingState
+}
+
+const (
+	maxMemorySlots = 256
+	// maxCallDepth is the maximum number of nested calls, a call beyond it
+	// fails.
+	maxCallDepth = 64
+)
+
+func NewVM(data []byte, contractState ContractState, gasLimit uint64) *VM {
+	return &VM{
+		program: newProgram(data, contractState, gasLimit),
+		stack:   *NewStack(128),
+	}
+}
+
+func (vm *VM) Run() error {
+	err := vm.run(vm.exec)
+	// Nested calls are part of the trace of the outermost VM
+	if vm.tracer != nil && vm.depth == 0 {
+		vm.tracer.CaptureEnd(vm.GasUsed(), err)
+	}
+	return err
+}
+
+// SetTracer makes the VM report every executed instruction to the tracer.
+func (vm *VM) SetTracer(tracer Tracer) {
+	vm.tracer = tracer
+	vm.tracingState = &tracingState{ContractState: vm.contractState}
+	vm.contractState = vm.tracingState
+}
+
+// Step executes the next instruction and tells if the execution is over, so
+// that a debugger can inspect IP and Stack between instructions. Step must
+// not be called again once it returned an error.
+func (vm *VM) Step() (bool, error) {
+	done, err := vm.step(vm.exec)
+	if (done || err != nil) && vm.tracer != nil && vm.depth == 0 {
+		vm.tracer.CaptureEnd(vm.GasUsed(), err)
+	}
+	return done, err
+}
+
+// ReturnData returns the data of InstrReturn or InstrRevert. Without them it
+// is the value on top of the stack as written to the state, nil when the
+// stack is empty.
+func (vm *VM) ReturnData() []byte {
+	if vm.returned {
+		return vm.returnData
+	}
+	if vm.stack.Len() == 0 {
+		return nil
+	}
+	return vm.stack.data[vm.stack.Len()-1].Serialize()
+}
+
+// IP returns the position of the next instruction.
+func (vm *VM) IP() int {
+	return vm.ip
+}
+
+// Stack returns a copy of the stack, the top is the last value.
+func (vm *VM) Stack() []Value {
+	return append([]Value{}, vm.stack.data...)
+}
+
+// exec executes the instruction and reports it to the tracer.
+func (vm *VM) exec(instr Instruction) error {
+	if vm.tracer == nil {
+		return vm.Exec(instr)
+	}
+
+	// The gas of the instruction itself is already used
+	step := &Step{
+		Depth: vm.depth,
+		IP:    vm.ip,
+		Op:    instr,
+		Gas:   vm.gasLimit - vm.gasUsed + instr.Gas(),
+		Stack: vm.Stack(),
+	}
+
+	err := vm.Exec(instr)
+
+	step.GasCost = step.Gas - (vm.gasLimit - vm.gasUsed)
+	step.Writes = vm.tracingState.takeWrites()
+	if err != errStop {
+		step.Err = err
+	}
+	vm.tracer.CaptureStep(step)
+
+	return err
+}
+
+// Logs returns the logs emitted so far.
+func (vm *VM) Logs() []*Log {
+	return vm.logs
+}
+
 func (vm *VM) Exec(instr Instruction) error {
 	switch instr {
+	case InstrPush, InstrByte:
+		operand, err := vm.operand()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(NewWordValue(new(big.Int).SetUint64(uint64(operand))))
+
+	case InstrPack:
+		n, err := vm.popWord()
+		if err != nil {
+			return err
+		}
+		if !n.IsInt64() || n.Int64() > int64(vm.stack.Len()) {
+			return ErrStackUnderflow
+		}
+		if err := vm.useGas(n.Uint64() * gasPackPerByte); err != nil {
+			return err
+		}
+
+		// The first pushed value is the first byte
+		b := make([]byte, n.Int64())
+		for i := len(b) - 1; i >= 0; i-- {
+			x, err := vm.popWord()
+			if err != nil {
+				return err
+			}
+			if x.BitLen() > 8 {
+				return ErrTypeMismatch
+			}
+			b[i] = byte(x.Uint64())
+		}
+
+		return vm.stack.Push(NewBytesValue(b))
+
 	case InstrStore:
-		var (
-			key             = vm.stack.Pop().([]byte)
-			value           = vm.stack.Pop()
-			serializedValue []byte
-		)
+		key, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		value, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+
+		serializedValue := value.Serialize()
 
-		switch v := value.(type) {
-		case int:
-			serializedValue = serializeInt64(int64(v))
-		default:
-			panic("TODO: unknown type")
+		if err := vm.useGas(uint64(len(key)+len(serializedValue)) * gasStorePerByte); err != nil {
+			return err
 		}
 
 		vm.contractState.Put(key, serializedValue)
-	case InstrPush:
-		vm.stack.Push(int(vm.data[vm.ip-1]))
-	case InstrByte:
-		vm.stack.Push(byte(vm.data[vm.ip-1]))
-	case InstrPack:
-		n := vm.stack.Pop().(int)
-		b := make([]byte, n)
 
-		for i := 0; i < n; i++ {
-			b[i] = vm.stack.Pop().(byte)
+	case InstrGet:
+		key, err := vm.popBytes()
+		if err != nil {
+			return err
 		}
 
-		vm.stack.Push(b)
+		// A missing key reads as empty bytes, which is the word 0
+		value, _ := vm.contractState.Get(key)
+		return vm.stack.Push(NewBytesValue(append([]byte{}, value...)))
 
-	case InstrAdd:
-		a := vm.stack.Pop().(int)
-		b := vm.stack.Pop().(int)
-		c := a + b
-		vm.stack.Push(c)
+	case InstrDelete:
+		key, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		vm.contractState.Delete(key)
 
-	case InstrSub:
-		a := vm.stack.Pop().(int)
-		b := vm.stack.Pop().(int)
-		c := a - b
-		vm.stack.Push(c)
+	case InstrRot:
+		value, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		vm.stack.data = append([]Value{value}, vm.stack.data...)
+
+	case InstrCallData:
+		return vm.stack.Push(NewBytesValue(append([]byte{}, vm.callData...)))
+
+	case InstrLog:
+		return vm.log()
+
+	case InstrCall:
+		return vm.call()
+
+	case InstrReturn, InstrRevert:
+		data, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		vm.returnData = data.Serialize()
+		vm.returned = true
+		if instr == InstrRevert {
+			return ErrReverted
+		}
+		return errStop
+
+	case InstrMLoad:
+		slot, err := vm.popSlot()
+		if err != nil {
+			return err
+		}
+		if slot >= len(vm.memory) {
+			return vm.stack.Push(Value{})
+		}
+		return vm.stack.Push(vm.memory[slot])
+
+	case InstrMStore:
+		slot, err := vm.popSlot()
+		if err != nil {
+			return err
+		}
+		value, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		for len(vm.memory) <= slot {
+			vm.memory = append(vm.memory, Value{})
+		}
+		vm.memory[slot] = value
+
+	case InstrNot:
+		a, err := vm.popWord()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(boolToWord(a.Sign() == 0))
+
+	case InstrJump:
+		dest, err := vm.popWord()
+		if err != nil {
+			return err
+		}
+		return vm.jumpWord(dest)
+
+	case InstrJumpI:
+		dest, err := vm.popWord()
+		if err != nil {
+			return err
+		}
+		cond, err := vm.popWord()
+		if err != nil {
+			return err
+		}
+		if cond.Sign() != 0 {
+			return vm.jumpWord(dest)
+		}
+
+	case InstrAdd, InstrSub, InstrMul, InstrDiv, InstrMod, InstrEq, InstrLt, InstrGt, InstrAnd, InstrOr:
+		b, err := vm.popWord()
+		if err != nil {
+			return err
+		}
+		a, err := vm.popWord()
+		if err != nil {
+			return err
+		}
+
+		c, err := wordBinaryOp(instr, a, b)
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(c)
+
+	default:
+		return ErrInvalidOpcode
+	}
+
+	return nil
+}
+
+// log pops the number of topics, the topics and the data of a log. Topics
+// keep the order in which they were pushed.
+func (vm *VM) log() error {
+	n, err := vm.popWord()
+	if err != nil {
+		return err
+	}
+	if n.Cmp(big.NewInt(maxLogTopics)) > 0 {
+		return ErrTooManyTopics
+	}
+
+	topics := make([]types.Hash, n.Int64())
+	for i := len(topics) - 1; i >= 0; i-- {
+		topic, err := vm.popWord()
+		if err != nil {
+			return err
+		}
+		topics[i] = types.HashFromBytes(topic.FillBytes(make([]byte, wordSize)))
+	}
+
+	value, err := vm.stack.Pop()
+	if err != nil {
+		return err
 	}
+	data := value.Serialize()
+
+	gas := uint64(len(topics))*gasLogPerTopic + uint64(len(data))*gasLogPerByte
+	if err := vm.useGas(gas); err != nil {
+		return err
+	}
+
+	vm.logs = append(vm.logs, &Log{
+		Address: vm.address,
+		Topics:  topics,
+		Data:    data,
+	})
 
 	return nil
 }
 
-func serializeInt64(value int64) []byte {
-	buf := make([]byte, 8)
+// call runs the code of a contract in a new VM with its own stack, memory and
+// gas limit. The writes of a failing call are reverted and its logs dropped,
+// the caller continues with 0 on top of the stack. A call to an address
+// without contract or beyond maxCallDepth fails the same way. Precompiled
+// contracts run in Go without a new VM.
+func (vm *VM) call() error {
+	gas, err := vm.popWord()
+	if err != nil {
+		return err
+	}
+	callData, err := vm.popBytes()
+	if err != nil {
+		return err
+	}
+	addr, err := vm.popAddress()
+	if err != nil {
+		return err
+	}
+
+	// The callee gets at most the gas left
+	gasLimit := vm.gasLimit - vm.gasUsed
+	if gas.IsUint64() && gas.Uint64() < gasLimit {
+		gasLimit = gas.Uint64()
+	}
 
-	binary.LittleEndian.PutUint64(buf, uint64(value))
+	var (
+		result ExecutionResult
+		ok     bool
+	)
+
+	if p, isPrecompile := Precompile(addr); isPrecompile {
+		result, err = runPrecompile(p, callData, gasLimit)
+		ok = err == nil
+	} else if code, err := vm.code(addr); err == nil && vm.depth < maxCallDepth {
+		snapshot := vm.state.Snapshot()
+		storage := contractStorage{state: vm.state, addr: addr}
+
+		result, err = runVM(code[0], code[1:], callData, storage, gasLimit, vm.tracer, vm.depth+1)
+		// The callee would run untraced, the trace would not be the
+		// execution of the block
+		if errors.Is(err, ErrNotTraceable) {
+			return err
+		}
+		if err != nil {
+			vm.state.RevertToSnapshot(snapshot)
+		} else {
+			ok = true
+			vm.logs = append(vm.logs, result.Logs...)
+		}
+	}
+
+	if err := vm.useGas(result.GasUsed); err != nil {
+		return err
+	}
+
+	if err := vm.stack.Push(NewBytesValue(append([]byte{}, result.ReturnData...))); err != nil {
+		return err
+	}
+	return vm.stack.Push(boolToWord(ok))
+}
+
+// code returns the code of the contract at the address prefixed with its VM
+// version.
+func (vm *VM) code(addr types.Address) ([]byte, error) {
+	if vm.state == nil {
+		return nil, fmt.Errorf("no contract at address (%s)", addr)
+	}
+
+	code, err := vm.state.Get(CodeKey(addr))
+	if err != nil || len(code) == 0 {
+		return nil, fmt.Errorf("no contract at address (%s)", addr)
+	}
 
-	return buf
+	return code, nil
 }
 
-func deserializeInt64(b []byte) int64 {
-	return int64(binary.LittleEndian.Uint64(b))
+func (vm *VM) jumpWord(dest *big.Int) error {
+	if !dest.IsInt64() || dest.Int64() >= int64(len(vm.data)) {
+		return ErrInvalidJump
+	}
+	return vm.jump(int(dest.Int64()))
+}
+
+func wordBinaryOp(instr Instruction, a, b *big.Int) (Value, error) {
+	c := new(big.Int)
+
+	switch instr {
+	case InstrAdd:
+		c.Add(a, b)
+	case InstrSub:
+		c.Sub(a, b)
+	case InstrMul:
+		c.Mul(a, b)
+	case InstrDiv:
+		if b.Sign() == 0 {
+			return Value{}, ErrDivisionByZero
+		}
+		c.Div(a, b)
+	case InstrMod:
+		if b.Sign() == 0 {
+			return Value{}, ErrDivisionByZero
+		}
+		c.Mod(a, b)
+	case InstrEq:
+		return boolToWord(a.Cmp(b) == 0), nil
+	case InstrLt:
+		return boolToWord(a.Cmp(b) < 0), nil
+	case InstrGt:
+		return boolToWord(a.Cmp(b) > 0), nil
+	case InstrAnd:
+		c.And(a, b)
+	case InstrOr:
+		c.Or(a, b)
+	default:
+		return Value{}, ErrInvalidOpcode
+	}
+
+	return NewWordValue(c), nil
+}
+
+func boolToWord(b bool) Value {
+	if b {
+		return NewWordValue(big.NewInt(1))
+	}
+	return NewWordValue(new(big.Int))
+}
+
+func (vm *VM) popWord() (*big.Int, error) {
+	value, err := vm.stack.Pop()
+	if err != nil {
+		return nil, err
+	}
+	return value.Word()
+}
+
+func (vm *VM) popSlot() (int, error) {
+	slot, err := vm.popWord()
+	if err != nil {
+		return 0, err
+	}
+	if slot.Cmp(big.NewInt(maxMemorySlots)) >= 0 {
+		return 0, ErrInvalidMemorySlot
+	}
+	return int(slot.Int64()), nil
+}
+
+// popAddress pops an address given as 20 bytes or as a word.
+func (vm *VM) popAddress() (types.Address, error) {
+	value, err := vm.stack.Pop()
+	if err != nil {
+		return types.Address{}, err
+	}
+
+	b := value.Serialize()
+	switch {
+	case value.IsWord():
+		return types.AddressFromBytes(b[wordSize-20:]), nil
+	case len(b) == 20:
+		return types.AddressFromBytes(b), nil
+	}
+
+	return types.Address{}, ErrTypeMismatch
+}
+
+func (vm *VM) popBytes() ([]byte, error) {
+	value, err := vm.stack.Pop()
+	if err != nil {
+		return nil, err
+	}
+	return value.Bytes()
 }
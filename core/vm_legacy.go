@@ -0,0 +1,282 @@
+package core
+
+import "encoding/binary"
+
+// legacyStack is the stack of VMVersionLegacy, it pops the oldest value
+// first.
+type legacyStack struct {
+	data []any
+	sp   int
+}
+
+func newLegacyStack(size int) *legacyStack {
+	return &legacyStack{
+		data: make([]any, size),
+		sp:   0,
+	}
+}
+
+func (s *legacyStack) Push(v any) error {
+	if s.sp >= len(s.data) {
+		return ErrStackOverflow
+	}
+
+	s.data[s.sp] = v
+	s.sp++
+
+	return nil
+}
+
+func (s *legacyStack) Pop() (any, error) {
+	if s.sp == 0 {
+		return nil, ErrStackUnderflow
+	}
+
+	value := s.data[0]
+	s.data = append(s.data[:0], s.data[1:]...)
+	s.sp--
+
+	return value, nil
+}
+
+// legacyVM runs the code of VMVersionLegacy transactions, its behaviour must
+// not change so that existing blocks keep the same state root.
+type legacyVM struct {
+	program
+	stack legacyStack
+}
+
+func newLegacyVM(data []byte, contractState ContractState, gasLimit uint64) *legacyVM {
+	return &legacyVM{
+		program: newProgram(data, contractState, gasLimit),
+		stack:   *newLegacyStack(128),
+	}
+}
+
+func (vm *legacyVM) Run() error {
+	return vm.run(vm.Exec)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (vm *legacyVM) Exec(instr Instruction) error {
+	switch instr {
+	case InstrStore:
+		key, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		value, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+
+		serializedValue := serializeInt64(int64(value))
+
+		if err := vm.useGas(uint64(len(key)+len(serializedValue)) * gasStorePerByte); err != nil {
+			return err
+		}
+
+		vm.contractState.Put(key, serializedValue)
+	case InstrGet:
+		key, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+
+		value, err := vm.contractState.Get(key)
+		switch {
+		case err != nil:
+			return vm.stack.Push(0)
+		case len(value) == 8:
+			return vm.stack.Push(int(deserializeInt64(value)))
+		default:
+			return vm.stack.Push(append([]byte{}, value...))
+		}
+	case InstrDelete:
+		key, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		vm.contractState.Delete(key)
+	case InstrRot:
+		value, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(value)
+	case InstrPush:
+		operand, err := vm.operand()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(int(operand))
+	case InstrByte:
+		operand, err := vm.operand()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(operand)
+	case InstrPack:
+		n, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		// A negative length can only be computed, there are never that many
+		// values to pack
+		if n < 0 || n > vm.stack.sp {
+			return ErrStackUnderflow
+		}
+		if err := vm.useGas(uint64(n) * gasPackPerByte); err != nil {
+			return err
+		}
+
+		b := make([]byte, n)
+
+		for i := 0; i < n; i++ {
+			if b[i], err = vm.popByte(); err != nil {
+				return err
+			}
+		}
+
+		return vm.stack.Push(b)
+
+	case InstrNot:
+		// InstrNot is a logical not, 0 becomes 1 and anything else 0
+		a, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(boolToInt(a == 0))
+
+	case InstrJump:
+		dest, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		return vm.jump(dest)
+
+	case InstrJumpI:
+		dest, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		cond, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		if cond != 0 {
+			return vm.jump(dest)
+		}
+
+	case InstrAdd, InstrSub, InstrMul, InstrDiv, InstrMod, InstrEq, InstrLt, InstrGt, InstrAnd, InstrOr:
+		a, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		b, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+
+		c, err := legacyBinaryOp(instr, a, b)
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(c)
+
+	default:
+		return ErrInvalidOpcode
+	}
+
+	return nil
+}
+
+func legacyBinaryOp(instr Instruction, a, b int) (int, error) {
+	switch instr {
+	case InstrAdd:
+		return a + b, nil
+	case InstrSub:
+		return a - b, nil
+	case InstrMul:
+		return a * b, nil
+	case InstrDiv:
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return a / b, nil
+	case InstrMod:
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return a % b, nil
+	case InstrEq:
+		return boolToInt(a == b), nil
+	case InstrLt:
+		return boolToInt(a < b), nil
+	case InstrGt:
+		return boolToInt(a > b), nil
+	case InstrAnd:
+		return a & b, nil
+	case InstrOr:
+		return a | b, nil
+	}
+
+	return 0, ErrInvalidOpcode
+}
+
+func (vm *legacyVM) popInt() (int, error) {
+	value, err := vm.stack.Pop()
+	if err != nil {
+		return 0, err
+	}
+
+	v, ok := value.(int)
+	if !ok {
+		return 0, ErrTypeMismatch
+	}
+	return v, nil
+}
+
+func (vm *legacyVM) popByte() (byte, error) {
+	value, err := vm.stack.Pop()
+	if err != nil {
+		return 0, err
+	}
+
+	v, ok := value.(byte)
+	if !ok {
+		return 0, ErrTypeMismatch
+	}
+	return v, nil
+}
+
+func (vm *legacyVM) popBytes() ([]byte, error) {
+	value, err := vm.stack.Pop()
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := value.([]byte)
+	if !ok {
+		return nil, ErrTypeMismatch
+	}
+	return v, nil
+}
+
+func serializeInt64(value int64) []byte {
+	buf := make([]byte, 8)
+
+	binary.LittleEndian.PutUint64(buf, uint64(value))
+
+	return buf
+}
+
+func deserializeInt64(b []byte) int64 {
+	return int64(binary.LittleEndian.Uint64(b))
+}
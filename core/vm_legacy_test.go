@@ -0,0 +1,265 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLegacyStack(t *testing.T) {
+	s := newLegacyStack(128)
+	assert.Nil(t, s.Push(1))
+	assert.Nil(t, s.Push(2))
+
+	value, err := s.Pop()
+	assert.Nil(t, err)
+
+	assert.Equal(t, value, 1)
+
+	fmt.Println(s.data)
+}
+
+func TestLegacyVM(t *testing.T) {
+	// 1 + 2 = 3
+	// 1
+	// push stack
+	// 2
+	// add
+	// 3
+	// push stack
+	// data := []byte{0x03, 0x0a, 0x02, 0x0a, 0x0e}
+	data := []byte{0x03, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x0d, 0x05, 0x0a, 0x0f}
+	contractState := NewState()
+	vm := newLegacyVM(data, contractState, DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+	valueBytes, err := contractState.Get([]byte("FOO"))
+	value := deserializeInt64(valueBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, value, int64(5))
+}
+
+func TestLegacyVMOutOfGas(t *testing.T) {
+	data := []byte{0x03, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x0d, 0x05, 0x0a, 0x0f}
+
+	vm := newLegacyVM(data, NewState(), DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+	gasUsed := vm.GasUsed()
+
+	// push, byte, byte, byte, pack of 3 bytes, push, store of 3 + 8 bytes
+	assert.Equal(t, uint64(2+2+2+2+3+3+2+100+11*10), gasUsed)
+
+	vm = newLegacyVM(data, NewState(), gasUsed-1)
+	assert.Equal(t, ErrOutOfGas, vm.Run())
+	assert.Equal(t, gasUsed-1, vm.GasUsed())
+
+	vm = newLegacyVM(data, NewState(), gasUsed)
+	assert.Nil(t, vm.Run())
+}
+
+// runLegacyCode runs the code and returns the value left first in line on the stack.
+func runLegacyCode(t *testing.T, code []byte) (any, error) {
+	vm := newLegacyVM(code, NewState(), DefaultTxGasLimit)
+	if err := vm.Run(); err != nil {
+		return nil, err
+	}
+
+	assert.True(t, vm.stack.sp > 0)
+	return vm.stack.data[0], nil
+}
+
+func TestLegacyVMBinaryInstructions(t *testing.T) {
+	cases := []struct {
+		instr    Instruction
+		a, b     byte
+		expected int
+	}{
+		{InstrAdd, 7, 3, 10},
+		{InstrSub, 7, 3, 4},
+		{InstrMul, 7, 3, 21},
+		{InstrDiv, 7, 3, 2},
+		{InstrMod, 7, 3, 1},
+		{InstrEq, 7, 3, 0},
+		{InstrEq, 3, 3, 1},
+		{InstrLt, 7, 3, 0},
+		{InstrLt, 3, 7, 1},
+		{InstrGt, 7, 3, 1},
+		{InstrGt, 3, 7, 0},
+		{InstrAnd, 6, 3, 2},
+		{InstrAnd, 1, 0, 0},
+		{InstrOr, 6, 3, 7},
+		{InstrOr, 0, 0, 0},
+	}
+
+	for _, c := range cases {
+		code := []byte{c.a, byte(InstrPush), c.b, byte(InstrPush), byte(c.instr)}
+		value, err := runLegacyCode(t, code)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, value, "instruction %x", c.instr)
+	}
+}
+
+func TestLegacyVMNot(t *testing.T) {
+	value, err := runLegacyCode(t, []byte{0x00, byte(InstrPush), byte(InstrNot)})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, value)
+
+	value, err = runLegacyCode(t, []byte{0x05, byte(InstrPush), byte(InstrNot)})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, value)
+}
+
+func TestLegacyVMDivisionByZero(t *testing.T) {
+	_, err := runLegacyCode(t, []byte{0x07, byte(InstrPush), 0x00, byte(InstrPush), byte(InstrDiv)})
+	assert.Equal(t, ErrDivisionByZero, err)
+
+	_, err = runLegacyCode(t, []byte{0x07, byte(InstrPush), 0x00, byte(InstrPush), byte(InstrMod)})
+	assert.Equal(t, ErrDivisionByZero, err)
+}
+
+func TestLegacyVMJump(t *testing.T) {
+	code := []byte{
+		0x06, byte(InstrPush), // 0: push 6
+		byte(InstrJump),       // 2: jump
+		0x01, byte(InstrPush), // 3: push 1, skipped
+		byte(InstrAdd),        // 5: skipped
+		0x07, byte(InstrPush), // 6: push 7
+	}
+
+	vm := newLegacyVM(code, NewState(), DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 1, vm.stack.sp)
+	assert.Equal(t, 7, vm.stack.data[0])
+}
+
+func TestLegacyVMJumpI(t *testing.T) {
+	code := func(cond byte) []byte {
+		return []byte{
+			0x08, byte(InstrPush), // 0: push 8
+			cond, byte(InstrPush), // 2: push cond
+			byte(InstrJumpI),      // 4: jumpi
+			0x01, byte(InstrPush), // 5: push 1
+			byte(InstrNot),        // 7: not
+			0x07, byte(InstrPush), // 8: push 7
+		}
+	}
+
+	// Jumps over the not
+	vm := newLegacyVM(code(1), NewState(), DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 1, vm.stack.sp)
+	assert.Equal(t, 7, vm.stack.data[0])
+
+	// Falls through, the pushed 1 is negated
+	vm = newLegacyVM(code(0), NewState(), DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 2, vm.stack.sp)
+	assert.Equal(t, 0, vm.stack.data[0])
+	assert.Equal(t, 7, vm.stack.data[1])
+}
+
+func TestLegacyVMInvalidJump(t *testing.T) {
+	// In the middle of the push instruction
+	_, err := runLegacyCode(t, []byte{0x01, byte(InstrPush), byte(InstrJump)})
+	assert.Equal(t, ErrInvalidJump, err)
+
+	// Outside of the code
+	_, err = runLegacyCode(t, []byte{0x10, byte(InstrPush), byte(InstrJump)})
+	assert.Equal(t, ErrInvalidJump, err)
+}
+
+func TestLegacyVMInfiniteLoopRunsOutOfGas(t *testing.T) {
+	// push 0, jump
+	vm := newLegacyVM([]byte{0x00, byte(InstrPush), byte(InstrJump)}, NewState(), 1000)
+	assert.Equal(t, ErrOutOfGas, vm.Run())
+	assert.Equal(t, uint64(1000), vm.GasUsed())
+}
+
+func TestLegacyVMGet(t *testing.T) {
+	// push 1, byte 'C', pack, get
+	code := []byte{0x01, 0x0a, 0x43, 0x0c, 0x0d, 0x1b}
+
+	state := NewState()
+	vm := newLegacyVM(code, state, DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 0, vm.stack.data[0])
+
+	assert.Nil(t, state.Put([]byte("C"), serializeInt64(42)))
+	vm = newLegacyVM(code, state, DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 42, vm.stack.data[0])
+
+	assert.Nil(t, state.Put([]byte("C"), []byte("foo")))
+	vm = newLegacyVM(code, state, DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, []byte("foo"), vm.stack.data[0])
+}
+
+func TestLegacyVMDelete(t *testing.T) {
+	// push 1, byte 'C', pack, delete
+	code := []byte{0x01, 0x0a, 0x43, 0x0c, 0x0d, 0x1c}
+
+	state := NewState()
+	assert.Nil(t, state.Put([]byte("C"), serializeInt64(42)))
+
+	vm := newLegacyVM(code, state, DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+
+	_, err := state.Get([]byte("C"))
+	assert.NotNil(t, err)
+
+	// Deleting a missing key is not an error
+	vm = newLegacyVM(code, state, DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+}
+
+func TestLegacyVMRot(t *testing.T) {
+	// push 1, push 2, rot
+	vm := newLegacyVM([]byte{0x01, 0x0a, 0x02, 0x0a, 0x1d}, NewState(), DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 2, vm.stack.sp)
+	assert.Equal(t, 2, vm.stack.data[0])
+	assert.Equal(t, 1, vm.stack.data[1])
+}
+
+func TestLegacyStackOverflowUnderflow(t *testing.T) {
+	s := newLegacyStack(1)
+
+	_, err := s.Pop()
+	assert.Equal(t, ErrStackUnderflow, err)
+
+	assert.Nil(t, s.Push(1))
+	assert.Equal(t, ErrStackOverflow, s.Push(2))
+}
+
+func TestLegacyVMErrors(t *testing.T) {
+	overflow := []byte{}
+	for i := 0; i < 129; i++ {
+		overflow = append(overflow, 0x01, byte(InstrPush))
+	}
+
+	tests := []struct {
+		name string
+		code []byte
+		err  error
+	}{
+		{"empty code", []byte{}, ErrEmptyCode},
+		{"invalid opcode", []byte{0xff}, ErrInvalidOpcode},
+		{"missing operand", []byte{byte(InstrPush)}, ErrInvalidOpcode},
+		{"stack underflow", []byte{0x01, 0x0a, byte(InstrAdd)}, ErrStackUnderflow},
+		{"stack overflow", overflow, ErrStackOverflow},
+		// push 1, store pops an int as key
+		{"type mismatch", []byte{0x01, 0x0a, byte(InstrStore)}, ErrTypeMismatch},
+		// push 2, byte 'A', pack of 2 values with only 1 left
+		{"pack underflow", []byte{0x02, 0x0a, 0x41, 0x0c, byte(InstrPack)}, ErrStackUnderflow},
+		// push 0, push 1, sub, pack of -1 values
+		{"pack negative", []byte{0x00, 0x0a, 0x01, 0x0a, byte(InstrSub), byte(InstrPack)}, ErrStackUnderflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runLegacyCode(t, tt.code)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
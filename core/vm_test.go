@@ -1,39 +1,283 @@
 package core
 
 import (
-	"fmt"
+	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestStack(t *testing.T) {
-	s := NewStack(128)
-	s.Push(1)
-	s.Push(2)
+	s := NewStack(2)
+	assert.Nil(t, s.Push(NewWordValue(big.NewInt(1))))
+	assert.Nil(t, s.Push(NewWordValue(big.NewInt(2))))
+	assert.Equal(t, ErrStackOverflow, s.Push(NewWordValue(big.NewInt(3))))
 
-	value := s.Pop()
+	value, err := s.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "2", value.String())
 
-	assert.Equal(t, value, 1)
+	value, err = s.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "1", value.String())
 
-	fmt.Println(s.data)
+	_, err = s.Pop()
+	assert.Equal(t, ErrStackUnderflow, err)
 }
 
 func TestVM(t *testing.T) {
-	// 1 + 2 = 3
-	// 1
-	// push stack
-	// 2
-	// add
-	// 3
-	// push stack
-	// data := []byte{0x03, 0x0a, 0x02, 0x0a, 0x0e}
-	data := []byte{0x03, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x0d, 0x05, 0x0a, 0x0f}
+	// push 5, byte 'F', byte 'O', byte 'O', push 3, pack, store
+	data := []byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
 	contractState := NewState()
-	vm := NewVM(data, contractState)
+	vm := NewVM(data, contractState, DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+
+	value, err := contractState.Get([]byte("FOO"))
+	assert.Nil(t, err)
+	assert.Equal(t, NewWordValue(big.NewInt(5)).Serialize(), value)
+}
+
+func TestVMOutOfGas(t *testing.T) {
+	data := []byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
+
+	vm := NewVM(data, NewState(), DefaultTxGasLimit)
 	assert.Nil(t, vm.Run())
-	valueBytes, err := contractState.Get([]byte("FOO"))
-	value := deserializeInt64(valueBytes)
+	gasUsed := vm.GasUsed()
+
+	// push, byte, byte, byte, push, pack of 3 bytes, store of 3 + 32 bytes
+	assert.Equal(t, uint64(2+2+2+2+2+3+3+100+35*10), gasUsed)
+
+	vm = NewVM(data, NewState(), gasUsed-1)
+	assert.Equal(t, ErrOutOfGas, vm.Run())
+	assert.Equal(t, gasUsed-1, vm.GasUsed())
+}
+
+// runCode runs the code and returns the value on top of the stack.
+func runCode(t *testing.T, code []byte) (Value, error) {
+	vm := NewVM(code, NewState(), DefaultTxGasLimit)
+	if err := vm.Run(); err != nil {
+		return Value{}, err
+	}
+
+	return vm.stack.Pop()
+}
+
+func TestVMBinaryInstructions(t *testing.T) {
+	cases := []struct {
+		instr    Instruction
+		a, b     byte
+		expected string
+	}{
+		{InstrAdd, 7, 3, "10"},
+		{InstrSub, 7, 3, "4"},
+		{InstrSub, 3, 7, new(big.Int).Sub(wordModulus, big.NewInt(4)).String()},
+		{InstrMul, 7, 3, "21"},
+		{InstrDiv, 7, 3, "2"},
+		{InstrMod, 7, 3, "1"},
+		{InstrEq, 7, 3, "0"},
+		{InstrEq, 3, 3, "1"},
+		{InstrLt, 7, 3, "0"},
+		{InstrLt, 3, 7, "1"},
+		{InstrGt, 7, 3, "1"},
+		{InstrGt, 3, 7, "0"},
+		{InstrAnd, 6, 3, "2"},
+		{InstrOr, 6, 3, "7"},
+	}
+
+	for _, c := range cases {
+		code := []byte{c.a, byte(InstrPush), c.b, byte(InstrPush), byte(c.instr)}
+		value, err := runCode(t, code)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, value.String(), "instruction %x", c.instr)
+	}
+}
+
+func TestVMWordsWrapAround(t *testing.T) {
+	// 0 - 1 + 1
+	value, err := runCode(t, []byte{0x00, 0x0a, 0x01, 0x0a, 0x0e, 0x01, 0x0a, 0x0b})
+	assert.Nil(t, err)
+	assert.Equal(t, "0", value.String())
+}
+
+func TestVMNot(t *testing.T) {
+	value, err := runCode(t, []byte{0x00, byte(InstrPush), byte(InstrNot)})
 	assert.Nil(t, err)
-	assert.Equal(t, value, int64(5))
+	assert.Equal(t, "1", value.String())
+
+	value, err = runCode(t, []byte{0x05, byte(InstrPush), byte(InstrNot)})
+	assert.Nil(t, err)
+	assert.Equal(t, "0", value.String())
+}
+
+func TestVMDivisionByZero(t *testing.T) {
+	_, err := runCode(t, []byte{0x07, byte(InstrPush), 0x00, byte(InstrPush), byte(InstrDiv)})
+	assert.Equal(t, ErrDivisionByZero, err)
+
+	_, err = runCode(t, []byte{0x07, byte(InstrPush), 0x00, byte(InstrPush), byte(InstrMod)})
+	assert.Equal(t, ErrDivisionByZero, err)
+}
+
+func TestVMJumpI(t *testing.T) {
+	code := func(cond byte) []byte {
+		return []byte{
+			0x01, byte(InstrPush), // 0: push 1
+			cond, byte(InstrPush), // 2: push cond
+			0x08, byte(InstrPush), // 4: push 8
+			byte(InstrJumpI),      // 6: jumpi
+			byte(InstrNot),        // 7: not
+			0x00, byte(InstrPush), // 8: push 0
+			byte(InstrAdd), // 10: add
+		}
+	}
+
+	// Jumps over the not
+	value, err := runCode(t, code(1))
+	assert.Nil(t, err)
+	assert.Equal(t, "1", value.String())
+
+	// Falls through, the 1 is negated
+	value, err = runCode(t, code(0))
+	assert.Nil(t, err)
+	assert.Equal(t, "0", value.String())
+}
+
+func TestVMInvalidJump(t *testing.T) {
+	// In the middle of the push instruction
+	_, err := runCode(t, []byte{0x01, byte(InstrPush), byte(InstrJump)})
+	assert.Equal(t, ErrInvalidJump, err)
+
+	// Outside of the code
+	_, err = runCode(t, []byte{0x10, byte(InstrPush), byte(InstrJump)})
+	assert.Equal(t, ErrInvalidJump, err)
+}
+
+func TestVMInfiniteLoopRunsOutOfGas(t *testing.T) {
+	// push 0, jump
+	vm := NewVM([]byte{0x00, byte(InstrPush), byte(InstrJump)}, NewState(), 1000)
+	assert.Equal(t, ErrOutOfGas, vm.Run())
+	assert.Equal(t, uint64(1000), vm.GasUsed())
+}
+
+func TestVMGetDelete(t *testing.T) {
+	// byte 'C', push 1, pack, get
+	get := []byte{0x43, 0x0c, 0x01, 0x0a, 0x0d, 0x1b}
+	// byte 'C', push 1, pack, delete
+	del := []byte{0x43, 0x0c, 0x01, 0x0a, 0x0d, 0x1c}
+
+	state := NewState()
+
+	vm := NewVM(get, state, DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+	value, err := vm.stack.Pop()
+	assert.Nil(t, err)
+	assert.False(t, value.IsWord())
+	assert.Equal(t, "0", mustWord(t, value).String())
+
+	assert.Nil(t, state.Put([]byte("C"), NewWordValue(big.NewInt(42)).Serialize()))
+	vm = NewVM(get, state, DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+	value, err = vm.stack.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "42", mustWord(t, value).String())
+
+	assert.Nil(t, NewVM(del, state, DefaultTxGasLimit).Run())
+	_, err = state.Get([]byte("C"))
+	assert.NotNil(t, err)
+}
+
+func mustWord(t *testing.T, v Value) *big.Int {
+	w, err := v.Word()
+	assert.Nil(t, err)
+	return w
+}
+
+func TestVMRot(t *testing.T) {
+	// push 1, push 2, push 3, rot
+	vm := NewVM([]byte{0x01, 0x0a, 0x02, 0x0a, 0x03, 0x0a, 0x1d}, NewState(), DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+
+	for _, expected := range []string{"2", "1", "3"} {
+		value, err := vm.stack.Pop()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, value.String())
+	}
+}
+
+func TestVMErrors(t *testing.T) {
+	overflow := []byte{}
+	for i := 0; i < 129; i++ {
+		overflow = append(overflow, 0x01, byte(InstrPush))
+	}
+
+	tests := []struct {
+		name string
+		code []byte
+		err  error
+	}{
+		{"empty code", []byte{}, ErrEmptyCode},
+		{"invalid opcode", []byte{0xff}, ErrInvalidOpcode},
+		{"missing operand", []byte{byte(InstrPush)}, ErrInvalidOpcode},
+		{"stack underflow", []byte{0x01, 0x0a, byte(InstrAdd)}, ErrStackUnderflow},
+		{"stack overflow", overflow, ErrStackOverflow},
+		// push 1, store pops a word as key
+		{"type mismatch", []byte{0x01, 0x0a, 0x01, 0x0a, byte(InstrStore)}, ErrTypeMismatch},
+		// push 255, push 1, add, push 1, pack of a word over 255
+		{"pack word", []byte{0xff, 0x0a, 0x01, 0x0a, 0x0b, 0x01, 0x0a, 0x0d}, ErrTypeMismatch},
+		// byte 'A', push 2, pack of 2 values with only 1 left
+		{"pack underflow", []byte{0x41, 0x0c, 0x02, 0x0a, byte(InstrPack)}, ErrStackUnderflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runCode(t, tt.code)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestValue(t *testing.T) {
+	word, err := NewBytesValue([]byte{0x01, 0x00}).Word()
+	assert.Nil(t, err)
+	assert.Equal(t, "256", word.String())
+
+	_, err = NewBytesValue(make([]byte, 33)).Word()
+	assert.Equal(t, ErrTypeMismatch, err)
+
+	_, err = NewWordValue(big.NewInt(1)).Bytes()
+	assert.Equal(t, ErrTypeMismatch, err)
+
+	assert.Equal(t, "1", NewWordValue(new(big.Int).Add(wordModulus, big.NewInt(1))).String())
+	assert.Equal(t, 32, len(NewWordValue(big.NewInt(1)).Serialize()))
+}
+
+func FuzzVMRun(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f})
+	f.Add([]byte{0x43, 0x0c, 0x01, 0x0a, 0x0d, 0x1b, 0x1c})
+	f.Add([]byte{0x00, 0x0a, 0x19})
+
+	f.Fuzz(func(t *testing.T, code []byte) {
+		// Any error is fine, the VMs must not panic
+		NewVM(code, NewState(), DefaultTxGasLimit).Run()
+		newLegacyVM(code, NewState(), DefaultTxGasLimit).Run()
+	})
+}
+
+func TestVMMemory(t *testing.T) {
+	// push 7, push 3, mstore, push 3, mload, push 4, mload
+	vm := NewVM([]byte{0x07, 0x0a, 0x03, 0x0a, 0x21, 0x03, 0x0a, 0x20, 0x04, 0x0a, 0x20}, NewState(), DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+
+	// The slot that was never written is empty
+	value, err := vm.stack.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "0", mustWord(t, value).String())
+
+	value, err = vm.stack.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, "7", value.String())
+
+	// push 255, push 1, add, mload
+	_, err = runCode(t, []byte{0xff, 0x0a, 0x01, 0x0a, 0x0b, 0x20})
+	assert.Equal(t, ErrInvalidMemorySlot, err)
 }
@@ -0,0 +1,122 @@
+package crypto
+
+import (
+	"fmt"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// Algorithm is a signature scheme, keys and signatures are tagged with the
+// algorithm they belong to.
+type Algorithm uint8
+
+const (
+	// P256 is ECDSA over NIST P-256, it is the zero value so that keys and
+	// signatures from before the other algorithms are P-256 ones.
+	P256 Algorithm = 0
+	// Secp256k1 is ECDSA over secp256k1.
+	Secp256k1 Algorithm = 1
+	// Ed25519 is EdDSA over edwards25519.
+	Ed25519 Algorithm = 2
+)
+
+var algorithmNames = map[Algorithm]string{
+	P256:      "p256",
+	Secp256k1: "secp256k1",
+	Ed25519:   "ed25519",
+}
+
+func (alg Algorithm) String() string {
+	if name, ok := algorithmNames[alg]; ok {
+		return name
+	}
+	return fmt.Sprintf("algorithm(%d)", uint8(alg))
+}
+
+// ParseAlgorithm returns the algorithm with the given name.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	for alg, n := range algorithmNames {
+		if n == name {
+			return alg, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown signature algorithm %s", name)
+}
+
+// Algorithms returns every supported algorithm.
+func Algorithms() []Algorithm {
+	return []Algorithm{P256, Secp256k1, Ed25519}
+}
+
+// Signer signs digests with a private key, PrivateKey is a Signer of any
+// algorithm.
+type Signer interface {
+	Algorithm() Algorithm
+	PublicKey() PublicKey
+	SignDigest(digest types.Hash) (*Signature, error)
+}
+
+// Verifier verifies the signatures of one algorithm.
+type Verifier interface {
+	Algorithm() Algorithm
+	VerifyDigest(pubKey PublicKey, digest types.Hash, sig *Signature) bool
+}
+
+// scheme is the implementation of an algorithm.
+type scheme interface {
+	Verifier
+	generateKey() (signer, error)
+	parsePrivateKey(b []byte) (signer, error)
+	// checkPublicKey validates the encoding of a public key.
+	checkPublicKey(b []byte) error
+	// checkSignature rejects the signatures that can never be valid, and
+	// the encodings that are not canonical.
+	checkSignature(sig *Signature) error
+}
+
+// recoverer is a scheme whose signatures can be recoverable, the public key
+// of the signer is derived from the signature and the digest.
+type recoverer interface {
+	// recoverable tells if the signatures of the scheme are recoverable.
+	recoverable() bool
+	recoverPublicKey(digest types.Hash, sig *Signature) (PublicKey, error)
+}
+
+// signer is a Signer whose private key can be exported.
+type signer interface {
+	Signer
+	Bytes() []byte
+}
+
+var schemes = map[Algorithm]scheme{
+	P256:      p256Scheme,
+	Secp256k1: secp256k1Scheme,
+	Ed25519:   ed25519Scheme{},
+}
+
+func schemeOf(alg Algorithm) (scheme, error) {
+	s, ok := schemes[alg]
+	if !ok {
+		return nil, fmt.Errorf("unknown signature algorithm %d", uint8(alg))
+	}
+	return s, nil
+}
+
+// recovererOf returns the recoverer of the algorithm, an error when its
+// signatures are not recoverable.
+func recovererOf(alg Algorithm) (recoverer, error) {
+	s, err := schemeOf(alg)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := s.(recoverer)
+	if !ok || !r.recoverable() {
+		return nil, fmt.Errorf("%s signatures are not recoverable", alg)
+	}
+	return r, nil
+}
+
+// NewVerifier returns the verifier of the algorithm.
+func NewVerifier(alg Algorithm) (Verifier, error) {
+	return schemeOf(alg)
+}
@@ -0,0 +1,273 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecp256k1Curve(t *testing.T) {
+	params := secp256k1.Params()
+	assert.True(t, secp256k1.IsOnCurve(params.Gx, params.Gy))
+
+	x, y := secp256k1.ScalarBaseMult([]byte{2})
+	assert.Equal(t, hexInt("c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5"), x)
+	assert.Equal(t, hexInt("1ae168fea63dc339a3c58419466ceaeef7f632653266d0e1236431a950cfe52a"), y)
+
+	dx, dy := secp256k1.Double(params.Gx, params.Gy)
+	assert.Equal(t, x, dx)
+	assert.Equal(t, y, dy)
+
+	// N * G is the point at infinity
+	x, y = secp256k1.ScalarBaseMult(params.N.Bytes())
+	assert.True(t, isInfinity(x, y))
+}
+
+func TestSignVerifyAlgorithms(t *testing.T) {
+	msg := []byte("foo")
+
+	for _, alg := range Algorithms() {
+		privKey, err := GenerateKey(alg)
+		assert.Nil(t, err)
+		assert.Equal(t, alg, privKey.Algorithm())
+
+		pubKey := privKey.PublicKey()
+		assert.Equal(t, alg, pubKey.Algorithm)
+
+		sig, err := privKey.Sign(msg)
+		assert.Nil(t, err)
+		assert.Equal(t, alg, sig.Algorithm)
+		assert.True(t, sig.Verify(pubKey, msg), alg.String())
+		assert.False(t, sig.Verify(pubKey, []byte("bar")))
+
+		again, err := privKey.Sign(msg)
+		assert.Nil(t, err)
+		assert.Equal(t, sig, again)
+
+		verifier, err := NewVerifier(alg)
+		assert.Nil(t, err)
+		assert.True(t, verifier.VerifyDigest(pubKey, Digest(MessageSigningDomain, msg), sig))
+
+		otherKey, err := GenerateKey(alg)
+		assert.Nil(t, err)
+		assert.False(t, sig.Verify(otherKey.PublicKey(), msg))
+	}
+}
+
+func TestVerifyAlgorithmMismatch(t *testing.T) {
+	msg := []byte("foo")
+
+	for _, alg := range Algorithms() {
+		privKey, err := GenerateKey(alg)
+		assert.Nil(t, err)
+		sig, err := privKey.Sign(msg)
+		assert.Nil(t, err)
+
+		for _, other := range Algorithms() {
+			if other == alg {
+				continue
+			}
+
+			// The same bytes tagged with another algorithm
+			pubKey := privKey.PublicKey()
+			pubKey.Algorithm = other
+			assert.False(t, sig.Verify(pubKey, msg))
+
+			tagged := *sig
+			tagged.Algorithm = other
+			assert.False(t, tagged.Verify(privKey.PublicKey(), msg))
+		}
+	}
+}
+
+func TestSecp256k1LowS(t *testing.T) {
+	privKey, err := GenerateKey(Secp256k1)
+	assert.Nil(t, err)
+	msg := []byte("foo")
+
+	sig, err := privKey.Sign(msg)
+	assert.Nil(t, err)
+	assert.True(t, sig.S.Cmp(secp256k1Scheme.halfOrder) <= 0)
+
+	highS := &Signature{Algorithm: Secp256k1, R: sig.R, S: new(big.Int).Sub(secp256k1.Params().N, sig.S)}
+	assert.False(t, highS.Verify(privKey.PublicKey(), msg))
+
+	b, err := highS.Bytes()
+	assert.Nil(t, err)
+	_, err = ParseSignature(Secp256k1, b)
+	assert.NotNil(t, err)
+}
+
+func TestP256Interop(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	msg := []byte("foo")
+	digest := Digest(MessageSigningDomain, msg)
+
+	sig, err := privKey.Sign(msg)
+	assert.Nil(t, err)
+
+	curve := elliptic.P256()
+	x, y := elliptic.UnmarshalCompressed(curve, privKey.PublicKey().Key)
+	assert.True(t, ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, digest[:], sig.R, sig.S))
+}
+
+func TestAlgorithmEncodings(t *testing.T) {
+	for _, alg := range Algorithms() {
+		privKey, err := GenerateKey(alg)
+		assert.Nil(t, err)
+		pubKey := privKey.PublicKey()
+		sig, err := privKey.Sign([]byte("foo"))
+		assert.Nil(t, err)
+
+		decodedPriv, err := PrivateKeyFromHex(privKey.Hex())
+		assert.Nil(t, err)
+		assert.Equal(t, pubKey, decodedPriv.PublicKey())
+
+		pemBytes, err := privKey.MarshalPEM()
+		assert.Nil(t, err)
+		decodedPriv, err = PrivateKeyFromPEM(pemBytes)
+		assert.Nil(t, err)
+		assert.Equal(t, pubKey, decodedPriv.PublicKey())
+
+		decodedPub, err := PublicKeyFromHex(pubKey.Hex())
+		assert.Nil(t, err)
+		assert.Equal(t, pubKey, decodedPub)
+
+		pemBytes, err = pubKey.MarshalPEM()
+		assert.Nil(t, err)
+		decodedPub, err = PublicKeyFromPEM(pemBytes)
+		assert.Nil(t, err)
+		assert.Equal(t, pubKey, decodedPub)
+
+		b, err := json.Marshal(pubKey)
+		assert.Nil(t, err)
+		decodedPub = PublicKey{}
+		assert.Nil(t, json.Unmarshal(b, &decodedPub))
+		assert.Equal(t, pubKey, decodedPub)
+
+		b, err = json.Marshal(sig)
+		assert.Nil(t, err)
+		decodedSig := new(Signature)
+		assert.Nil(t, json.Unmarshal(b, decodedSig))
+		assert.Equal(t, sig, decodedSig)
+
+		buf := &bytes.Buffer{}
+		assert.Nil(t, gob.NewEncoder(buf).Encode(struct {
+			Key PublicKey
+			Sig *Signature
+		}{pubKey, sig}))
+		var decoded struct {
+			Key PublicKey
+			Sig *Signature
+		}
+		assert.Nil(t, gob.NewDecoder(buf).Decode(&decoded))
+		assert.Equal(t, pubKey, decoded.Key)
+		assert.Equal(t, sig, decoded.Sig)
+
+		data, err := encryptKey(privKey, "passphrase", testScryptN)
+		assert.Nil(t, err)
+		decodedPriv, err = DecryptKey(data, "passphrase")
+		assert.Nil(t, err)
+		assert.Equal(t, pubKey, decodedPriv.PublicKey())
+	}
+}
+
+func TestParsePublicKeyInvalid(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	b := privKey.PublicKey().Key
+
+	// An Ed25519 key is 32 bytes
+	_, err := ParsePublicKey(Ed25519, b)
+	assert.NotNil(t, err)
+	_, err = ParsePublicKey(Algorithm(42), b)
+	assert.NotNil(t, err)
+
+	_, err = ParseAlgorithm("rsa")
+	assert.NotNil(t, err)
+	alg, err := ParseAlgorithm("secp256k1")
+	assert.Nil(t, err)
+	assert.Equal(t, Secp256k1, alg)
+}
+
+func TestRecoverPublicKey(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		privKey, err := GenerateKey(Secp256k1)
+		assert.Nil(t, err)
+		digest := sha256.Sum256([]byte{byte(i)})
+
+		sig, err := privKey.SignDigest(digest)
+		assert.Nil(t, err)
+		assert.True(t, sig.Recoverable())
+
+		pubKey, err := sig.RecoverPublicKey(digest)
+		assert.Nil(t, err)
+		assert.Equal(t, privKey.PublicKey(), pubKey)
+
+		// Another digest or recovery id gives another key
+		other, err := sig.RecoverPublicKey(sha256.Sum256([]byte("bar")))
+		if err == nil {
+			assert.NotEqual(t, pubKey, other)
+		}
+		flipped := *sig
+		flipped.V = (sig.V - 1) ^ 1 + 1
+		other, err = flipped.RecoverPublicKey(digest)
+		assert.Nil(t, err)
+		assert.NotEqual(t, pubKey, other)
+
+		b, err := sig.RecoverableBytes()
+		assert.Nil(t, err)
+		decoded, err := ParseRecoverableSignature(Secp256k1, b)
+		assert.Nil(t, err)
+		assert.Equal(t, sig, decoded)
+	}
+}
+
+func TestRecoverPublicKeyNotRecoverable(t *testing.T) {
+	privKey, err := GenerateKey(Ed25519)
+	assert.Nil(t, err)
+	digest := sha256.Sum256([]byte("foo"))
+
+	sig, err := privKey.SignDigest(digest)
+	assert.Nil(t, err)
+	assert.False(t, sig.Recoverable())
+	_, err = sig.RecoverPublicKey(digest)
+	assert.NotNil(t, err)
+	_, err = sig.RecoverableBytes()
+	assert.NotNil(t, err)
+
+	b, err := sig.Bytes()
+	assert.Nil(t, err)
+	_, err = ParseRecoverableSignature(Ed25519, append(b, 0))
+	assert.NotNil(t, err)
+
+	// P-256 signatures keep their 64 bytes
+	p256Sig, err := GeneratePrivateKey().SignDigest(digest)
+	assert.Nil(t, err)
+	assert.False(t, p256Sig.Recoverable())
+	b, err = p256Sig.encode()
+	assert.Nil(t, err)
+	assert.Len(t, b, SignatureSize)
+	_, err = ParseRecoverableSignature(P256, append(b, 0))
+	assert.NotNil(t, err)
+
+	// A recovery id added to a P-256 signature recovers nothing
+	p256Sig.V = 1
+	_, err = p256Sig.RecoverPublicKey(digest)
+	assert.NotNil(t, err)
+
+	k1Key, err := GenerateKey(Secp256k1)
+	assert.Nil(t, err)
+	sig, err = k1Key.SignDigest(digest)
+	assert.Nil(t, err)
+	b, err = sig.Bytes()
+	assert.Nil(t, err)
+	_, err = ParseRecoverableSignature(Secp256k1, append(b, 4))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,303 @@
+package crypto
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+const scalarSize = 32
+
+// ecdsaScheme is ECDSA over a curve y² = x³ + ax + b of 256 bits, public keys
+// are encoded compressed.
+type ecdsaScheme struct {
+	alg   Algorithm
+	curve elliptic.Curve
+	a     *big.Int
+	// oid names the curve in PEM encoded keys.
+	oid asn1.ObjectIdentifier
+	// halfOrder is half the order of the curve, S of a signature is at
+	// most halfOrder so that (R, N-S) is not another valid signature of
+	// the digest.
+	halfOrder *big.Int
+	// withRecoveryID tells if signatures carry the recovery id of their
+	// nonce point, see recoverPublicKey.
+	withRecoveryID bool
+}
+
+func newECDSAScheme(alg Algorithm, curve elliptic.Curve, a int64, oid asn1.ObjectIdentifier, withRecoveryID bool) *ecdsaScheme {
+	return &ecdsaScheme{
+		alg:            alg,
+		curve:          curve,
+		a:              big.NewInt(a),
+		oid:            oid,
+		halfOrder:      new(big.Int).Rsh(curve.Params().N, 1),
+		withRecoveryID: withRecoveryID,
+	}
+}
+
+// Only secp256k1 signatures are recoverable, P-256 ones keep their 64 bytes
+// encoding.
+var (
+	p256Scheme      = newECDSAScheme(P256, elliptic.P256(), -3, asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}, false)
+	secp256k1Scheme = newECDSAScheme(Secp256k1, secp256k1, 0, asn1.ObjectIdentifier{1, 3, 132, 0, 10}, true)
+)
+
+func (s *ecdsaScheme) Algorithm() Algorithm {
+	return s.alg
+}
+
+func (s *ecdsaScheme) generateKey() (signer, error) {
+	// d in [1, N-1]
+	max := new(big.Int).Sub(s.curve.Params().N, big.NewInt(1))
+	d, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newKey(d.Add(d, big.NewInt(1))), nil
+}
+
+func (s *ecdsaScheme) parsePrivateKey(b []byte) (signer, error) {
+	if len(b) != scalarSize {
+		return nil, fmt.Errorf("given private key with length %d should be %d", len(b), scalarSize)
+	}
+
+	d := new(big.Int).SetBytes(b)
+	if d.Sign() == 0 || d.Cmp(s.curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("private key out of range")
+	}
+
+	return s.newKey(d), nil
+}
+
+func (s *ecdsaScheme) newKey(d *big.Int) *ecdsaKey {
+	x, y := s.curve.ScalarBaseMult(d.FillBytes(make([]byte, scalarSize)))
+	return &ecdsaKey{scheme: s, d: d, x: x, y: y}
+}
+
+// decompress returns the point of a compressed public key.
+func (s *ecdsaScheme) decompress(b []byte) (*big.Int, *big.Int, error) {
+	params := s.curve.Params()
+	p := params.P
+
+	if len(b) != 1+scalarSize || (b[0] != 2 && b[0] != 3) {
+		return nil, nil, fmt.Errorf("invalid compressed public key")
+	}
+	// The standard library is much faster for its own curves
+	if s.curve == elliptic.P256() {
+		x, y := elliptic.UnmarshalCompressed(s.curve, b)
+		if x == nil {
+			return nil, nil, fmt.Errorf("invalid compressed public key")
+		}
+		return x, y, nil
+	}
+
+	x := new(big.Int).SetBytes(b[1:])
+	if x.Cmp(p) >= 0 {
+		return nil, nil, fmt.Errorf("invalid compressed public key")
+	}
+
+	// y² = x³ + ax + b, both primes are 3 mod 4 so y = y²^((p+1)/4)
+	y2 := new(big.Int).Mul(x, x)
+	y2.Mul(y2, x)
+	y2.Add(y2, new(big.Int).Mul(s.a, x))
+	y2.Add(y2, params.B)
+	y2.Mod(y2, p)
+
+	exp := new(big.Int).Add(p, big.NewInt(1))
+	exp.Rsh(exp, 2)
+	y := new(big.Int).Exp(y2, exp, p)
+	if new(big.Int).Exp(y, big.NewInt(2), p).Cmp(y2) != 0 {
+		return nil, nil, fmt.Errorf("invalid compressed public key")
+	}
+
+	if y.Bit(0) != uint(b[0]&1) {
+		y.Sub(p, y)
+	}
+
+	return x, y, nil
+}
+
+func (s *ecdsaScheme) checkPublicKey(b []byte) error {
+	_, _, err := s.decompress(b)
+	return err
+}
+
+func (s *ecdsaScheme) checkSignature(sig *Signature) error {
+	n := s.curve.Params().N
+
+	if sig.R == nil || sig.S == nil {
+		return fmt.Errorf("signature has no scalars")
+	}
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || sig.R.Cmp(n) >= 0 || sig.S.Cmp(n) >= 0 {
+		return fmt.Errorf("signature scalar out of range")
+	}
+	if sig.S.Cmp(s.halfOrder) > 0 {
+		return fmt.Errorf("signature has a high S")
+	}
+
+	return nil
+}
+
+// sign returns the signature of the digest by d. The nonce is derived from
+// the key and the digest as in RFC 6979 and S is the low one of its two
+// valid values, so the signature of a digest never changes. The signature
+// is recoverable when the scheme is, see recoverPublicKey.
+func (s *ecdsaScheme) sign(d *big.Int, digest types.Hash) *Signature {
+	n := s.curve.Params().N
+	e := bitsToInt(digest[:], n)
+
+	nonces := newNonceGenerator(d, digest[:], n)
+	for {
+		nonce := nonces.next()
+
+		x, y := s.curve.ScalarBaseMult(nonce.FillBytes(make([]byte, scalarSize)))
+		r := new(big.Int).Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+
+		// The recovery id tells which of the points of abscissa r, or r + N
+		// when it overflowed, is the nonce point.
+		recoveryID := uint8(y.Bit(0))
+		if x.Cmp(n) >= 0 {
+			recoveryID |= 2
+		}
+
+		// sig = (e + r * d) / nonce
+		sig := new(big.Int).Mul(r, d)
+		sig.Add(sig, e)
+		sig.Mul(sig, new(big.Int).ModInverse(nonce, n))
+		sig.Mod(sig, n)
+		if sig.Sign() == 0 {
+			continue
+		}
+
+		// -S is the signature of the negated nonce, whose point has the
+		// other y
+		if sig.Cmp(s.halfOrder) > 0 {
+			sig.Sub(n, sig)
+			recoveryID ^= 1
+		}
+
+		if !s.withRecoveryID {
+			return &Signature{Algorithm: s.alg, R: r, S: sig}
+		}
+		return &Signature{Algorithm: s.alg, R: r, S: sig, V: recoveryID + 1}
+	}
+}
+
+func (s *ecdsaScheme) VerifyDigest(pubKey PublicKey, digest types.Hash, sig *Signature) bool {
+	if pubKey.Algorithm != s.alg || sig.Algorithm != s.alg || s.checkSignature(sig) != nil {
+		return false
+	}
+	qx, qy, err := s.decompress(pubKey.Key)
+	if err != nil {
+		return false
+	}
+
+	// R = e/s * G + r/s * Q
+	n := s.curve.Params().N
+	e := bitsToInt(digest[:], n)
+	w := new(big.Int).ModInverse(sig.S, n)
+	u1 := e.Mul(e, w)
+	u1.Mod(u1, n)
+	u2 := w.Mul(sig.R, w)
+	u2.Mod(u2, n)
+
+	x1, y1 := s.curve.ScalarBaseMult(u1.FillBytes(make([]byte, scalarSize)))
+	x2, y2 := s.curve.ScalarMult(qx, qy, u2.FillBytes(make([]byte, scalarSize)))
+	x, y := s.curve.Add(x1, y1, x2, y2)
+	if isInfinity(x, y) {
+		return false
+	}
+
+	return x.Mod(x, n).Cmp(sig.R) == 0
+}
+
+func (s *ecdsaScheme) recoverable() bool {
+	return s.withRecoveryID
+}
+
+// recoverPublicKey returns the public key whose private key made the
+// recoverable signature of the digest, Q = (S * R - e * G) / r where R is
+// the nonce point given by r and the recovery id.
+func (s *ecdsaScheme) recoverPublicKey(digest types.Hash, sig *Signature) (PublicKey, error) {
+	if sig.Algorithm != s.alg {
+		return PublicKey{}, fmt.Errorf("signature is not a %s signature", s.alg)
+	}
+	if err := s.checkSignature(sig); err != nil {
+		return PublicKey{}, err
+	}
+	if sig.V < 1 || sig.V > 4 {
+		return PublicKey{}, fmt.Errorf("signature is not recoverable")
+	}
+	recoveryID := sig.V - 1
+
+	params := s.curve.Params()
+	n := params.N
+
+	x := new(big.Int).Set(sig.R)
+	if recoveryID&2 != 0 {
+		x.Add(x, n)
+		if x.Cmp(params.P) >= 0 {
+			return PublicKey{}, fmt.Errorf("invalid signature recovery id")
+		}
+	}
+	compressed := make([]byte, 1+scalarSize)
+	compressed[0] = 2 | recoveryID&1
+	x.FillBytes(compressed[1:])
+	rx, ry, err := s.decompress(compressed)
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("invalid signature recovery id")
+	}
+
+	// Q = -e/r * G + S/r * R
+	e := bitsToInt(digest[:], n)
+	rInv := new(big.Int).ModInverse(sig.R, n)
+	u1 := e.Mul(e, rInv)
+	u1.Neg(u1)
+	u1.Mod(u1, n)
+	u2 := new(big.Int).Mul(sig.S, rInv)
+	u2.Mod(u2, n)
+
+	x1, y1 := s.curve.ScalarBaseMult(u1.FillBytes(make([]byte, scalarSize)))
+	x2, y2 := s.curve.ScalarMult(rx, ry, u2.FillBytes(make([]byte, scalarSize)))
+	qx, qy := s.curve.Add(x1, y1, x2, y2)
+	if isInfinity(qx, qy) {
+		return PublicKey{}, fmt.Errorf("invalid signature")
+	}
+
+	return PublicKey{Algorithm: s.alg, Key: elliptic.MarshalCompressed(s.curve, qx, qy)}, nil
+}
+
+// ecdsaKey is a private key of an ecdsaScheme with its public point.
+type ecdsaKey struct {
+	scheme *ecdsaScheme
+	d      *big.Int
+	x, y   *big.Int
+}
+
+func (k *ecdsaKey) Algorithm() Algorithm {
+	return k.scheme.alg
+}
+
+func (k *ecdsaKey) PublicKey() PublicKey {
+	return PublicKey{
+		Algorithm: k.scheme.alg,
+		Key:       elliptic.MarshalCompressed(k.scheme.curve, k.x, k.y),
+	}
+}
+
+func (k *ecdsaKey) SignDigest(digest types.Hash) (*Signature, error) {
+	return k.scheme.sign(k.d, digest), nil
+}
+
+func (k *ecdsaKey) Bytes() []byte {
+	return k.d.FillBytes(make([]byte, scalarSize))
+}
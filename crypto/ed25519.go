@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// ed25519Scheme is Ed25519 of the standard library. A signature is kept as
+// its two halves read as big endian integers in R and S so that every
+// algorithm has the same 64 bytes compact encoding.
+type ed25519Scheme struct{}
+
+func (ed25519Scheme) Algorithm() Algorithm {
+	return Ed25519
+}
+
+func (ed25519Scheme) generateKey() (signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519Key(key), nil
+}
+
+// parsePrivateKey parses the 32 bytes seed of a key.
+func (ed25519Scheme) parsePrivateKey(b []byte) (signer, error) {
+	if len(b) != ed25519.SeedSize {
+		return nil, fmt.Errorf("given private key with length %d should be %d", len(b), ed25519.SeedSize)
+	}
+	return ed25519Key(ed25519.NewKeyFromSeed(b)), nil
+}
+
+func (ed25519Scheme) checkPublicKey(b []byte) error {
+	if len(b) != ed25519.PublicKeySize {
+		return fmt.Errorf("given public key with length %d should be %d", len(b), ed25519.PublicKeySize)
+	}
+	return nil
+}
+
+func (ed25519Scheme) checkSignature(sig *Signature) error {
+	_, err := sig.Bytes()
+	return err
+}
+
+func (s ed25519Scheme) VerifyDigest(pubKey PublicKey, digest types.Hash, sig *Signature) bool {
+	if pubKey.Algorithm != Ed25519 || sig.Algorithm != Ed25519 || s.checkPublicKey(pubKey.Key) != nil {
+		return false
+	}
+
+	b, err := sig.Bytes()
+	if err != nil {
+		return false
+	}
+
+	return ed25519.Verify(ed25519.PublicKey(pubKey.Key), digest[:], b)
+}
+
+type ed25519Key ed25519.PrivateKey
+
+func (k ed25519Key) Algorithm() Algorithm {
+	return Ed25519
+}
+
+func (k ed25519Key) PublicKey() PublicKey {
+	pub := ed25519.PrivateKey(k).Public().(ed25519.PublicKey)
+	return PublicKey{Algorithm: Ed25519, Key: []byte(pub)}
+}
+
+// SignDigest signs the digest as the message, Ed25519 signatures are
+// deterministic.
+func (k ed25519Key) SignDigest(digest types.Hash) (*Signature, error) {
+	b := ed25519.Sign(ed25519.PrivateKey(k), digest[:])
+
+	return &Signature{
+		Algorithm: Ed25519,
+		R:         new(big.Int).SetBytes(b[:scalarSize]),
+		S:         new(big.Int).SetBytes(b[scalarSize:]),
+	}, nil
+}
+
+func (k ed25519Key) Bytes() []byte {
+	return ed25519.PrivateKey(k).Seed()
+}
@@ -0,0 +1,244 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+)
+
+const (
+	pemTypeECPrivateKey = "EC PRIVATE KEY"
+	pemTypePrivateKey   = "PRIVATE KEY"
+	pemTypePublicKey    = "PUBLIC KEY"
+)
+
+var oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+// ecPrivateKey is the SEC 1 structure of an EC private key. The x509 package
+// only knows the NIST curves, so ECDSA keys are encoded here for secp256k1
+// as well.
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+// pkixPublicKey is the SubjectPublicKeyInfo structure of a public key.
+type pkixPublicKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// Bytes returns the private key, the private scalar left padded to 32 bytes
+// for ECDSA and the seed for Ed25519.
+func (k PrivateKey) Bytes() []byte {
+	return k.key.Bytes()
+}
+
+// Hex returns the hex of Bytes, prefixed with the algorithm and a colon for
+// other algorithms than P-256.
+func (k PrivateKey) Hex() string {
+	return encodeTagged(k.Algorithm(), k.Bytes())
+}
+
+// MarshalPEM encodes an ECDSA key as a SEC 1 "EC PRIVATE KEY" PEM block and
+// an Ed25519 key as a PKCS #8 "PRIVATE KEY" PEM block.
+func (k PrivateKey) MarshalPEM() ([]byte, error) {
+	switch key := k.key.(type) {
+	case *ecdsaKey:
+		der, err := asn1.Marshal(ecPrivateKey{
+			Version:       1,
+			PrivateKey:    key.Bytes(),
+			NamedCurveOID: key.scheme.oid,
+			PublicKey:     asn1.BitString{Bytes: elliptic.Marshal(key.scheme.curve, key.x, key.y)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: pemTypeECPrivateKey, Bytes: der}), nil
+	case ed25519Key:
+		der, err := x509.MarshalPKCS8PrivateKey(ed25519.PrivateKey(key))
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der}), nil
+	}
+
+	return nil, fmt.Errorf("can not encode %s private key", k.Algorithm())
+}
+
+// PrivateKeyFromBytes parses the 32 bytes private scalar of a P-256 key.
+func PrivateKeyFromBytes(b []byte) (PrivateKey, error) {
+	return ParsePrivateKey(P256, b)
+}
+
+// ParsePrivateKey parses the Bytes of a private key of the algorithm.
+func ParsePrivateKey(alg Algorithm, b []byte) (PrivateKey, error) {
+	s, err := schemeOf(alg)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+
+	key, err := s.parsePrivateKey(b)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+
+	return PrivateKey{key: key}, nil
+}
+
+// PrivateKeyFromHex parses the output of Hex.
+func PrivateKeyFromHex(s string) (PrivateKey, error) {
+	alg, b, err := parseTagged(s)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+	return ParsePrivateKey(alg, b)
+}
+
+// PrivateKeyFromPEM parses a key encoded by MarshalPEM.
+func PrivateKeyFromPEM(b []byte) (PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return PrivateKey{}, fmt.Errorf("no private key PEM block")
+	}
+
+	switch block.Type {
+	case pemTypeECPrivateKey:
+		var key ecPrivateKey
+		if rest, err := asn1.Unmarshal(block.Bytes, &key); err != nil {
+			return PrivateKey{}, err
+		} else if len(rest) > 0 {
+			return PrivateKey{}, fmt.Errorf("trailing data after private key")
+		}
+
+		s, err := ecdsaSchemeOf(key.NamedCurveOID)
+		if err != nil {
+			return PrivateKey{}, err
+		}
+		return ParsePrivateKey(s.alg, key.PrivateKey)
+	case pemTypePrivateKey:
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return PrivateKey{}, err
+		}
+		key, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return PrivateKey{}, fmt.Errorf("private key is not an Ed25519 key")
+		}
+		return PrivateKey{key: ed25519Key(key)}, nil
+	}
+
+	return PrivateKey{}, fmt.Errorf("unknown private key PEM block %s", block.Type)
+}
+
+// Hex returns the hex of the encoding of the key, prefixed with the algorithm
+// and a colon for other algorithms than P-256.
+func (k PublicKey) Hex() string {
+	return encodeTagged(k.Algorithm, k.ToSlice())
+}
+
+// MarshalPEM encodes the key as a PKIX "PUBLIC KEY" PEM block.
+func (k PublicKey) MarshalPEM() ([]byte, error) {
+	s, err := schemeOf(k.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var der []byte
+	switch s := s.(type) {
+	case *ecdsaScheme:
+		x, y, err := s.decompress(k.Key)
+		if err != nil {
+			return nil, err
+		}
+		params, err := asn1.Marshal(s.oid)
+		if err != nil {
+			return nil, err
+		}
+		der, err = asn1.Marshal(pkixPublicKey{
+			Algorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  oidPublicKeyECDSA,
+				Parameters: asn1.RawValue{FullBytes: params},
+			},
+			PublicKey: asn1.BitString{Bytes: elliptic.Marshal(s.curve, x, y)},
+		})
+		if err != nil {
+			return nil, err
+		}
+	case ed25519Scheme:
+		der, err = x509.MarshalPKIXPublicKey(ed25519.PublicKey(k.Key))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypePublicKey, Bytes: der}), nil
+}
+
+// PublicKeyFromHex parses the output of Hex.
+func PublicKeyFromHex(s string) (PublicKey, error) {
+	alg, b, err := parseTagged(s)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	return ParsePublicKey(alg, b)
+}
+
+// PublicKeyFromPEM parses a key encoded by MarshalPEM.
+func PublicKeyFromPEM(b []byte) (PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != pemTypePublicKey {
+		return PublicKey{}, fmt.Errorf("no %s PEM block", pemTypePublicKey)
+	}
+
+	var info pkixPublicKey
+	if rest, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		return PublicKey{}, err
+	} else if len(rest) > 0 {
+		return PublicKey{}, fmt.Errorf("trailing data after public key")
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidPublicKeyECDSA) {
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		key, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return PublicKey{}, fmt.Errorf("unsupported public key type %T", parsed)
+		}
+		return ParsePublicKey(Ed25519, key)
+	}
+
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &oid); err != nil {
+		return PublicKey{}, err
+	}
+	s, err := ecdsaSchemeOf(oid)
+	if err != nil {
+		return PublicKey{}, err
+	}
+
+	x, y := elliptic.Unmarshal(s.curve, info.PublicKey.RightAlign())
+	if x == nil {
+		return PublicKey{}, fmt.Errorf("invalid %s public key", s.alg)
+	}
+
+	return ParsePublicKey(s.alg, elliptic.MarshalCompressed(s.curve, x, y))
+}
+
+// ecdsaSchemeOf returns the ECDSA scheme of the curve named by oid.
+func ecdsaSchemeOf(oid asn1.ObjectIdentifier) (*ecdsaScheme, error) {
+	for _, s := range []*ecdsaScheme{p256Scheme, secp256k1Scheme} {
+		if s.oid.Equal(oid) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported curve %s", oid)
+}
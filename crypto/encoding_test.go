@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivateKeyHex(t *testing.T) {
+	privKey := GeneratePrivateKey()
+
+	decoded, err := PrivateKeyFromHex(privKey.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, privKey.Bytes(), decoded.Bytes())
+	assert.Equal(t, privKey.PublicKey().Address(), decoded.PublicKey().Address())
+
+	_, err = PrivateKeyFromHex("zz")
+	assert.NotNil(t, err)
+	_, err = PrivateKeyFromBytes(make([]byte, 31))
+	assert.NotNil(t, err)
+	_, err = PrivateKeyFromBytes(make([]byte, 32))
+	assert.NotNil(t, err)
+}
+
+func TestPrivateKeyPEM(t *testing.T) {
+	privKey := GeneratePrivateKey()
+
+	b, err := privKey.MarshalPEM()
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), "BEGIN EC PRIVATE KEY")
+
+	decoded, err := PrivateKeyFromPEM(b)
+	assert.Nil(t, err)
+	assert.Equal(t, privKey.Bytes(), decoded.Bytes())
+
+	// Signatures are deterministic, the decoded key signs the same
+	sig, err := privKey.Sign([]byte("foo"))
+	assert.Nil(t, err)
+	decodedSig, err := decoded.Sign([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, sig, decodedSig)
+
+	pubPEM, err := privKey.PublicKey().MarshalPEM()
+	assert.Nil(t, err)
+	_, err = PrivateKeyFromPEM(pubPEM)
+	assert.NotNil(t, err)
+	_, err = PrivateKeyFromPEM([]byte("foo"))
+	assert.NotNil(t, err)
+}
+
+func TestPublicKeyHexPEM(t *testing.T) {
+	pubKey := GeneratePrivateKey().PublicKey()
+
+	decoded, err := PublicKeyFromHex(pubKey.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, pubKey.ToSlice(), decoded.ToSlice())
+
+	b, err := pubKey.MarshalPEM()
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), "BEGIN PUBLIC KEY")
+
+	decoded, err = PublicKeyFromPEM(b)
+	assert.Nil(t, err)
+	assert.Equal(t, pubKey.Address(), decoded.Address())
+
+	_, err = PublicKeyFromHex("0011")
+	assert.NotNil(t, err)
+}
@@ -1,51 +1,148 @@
 package crypto
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"math/big"
+	"strings"
 
 	"github.com/anthoai97/blockchain-from-scratch/types"
 )
 
 type PrivateKey struct {
-	key *ecdsa.PrivateKey
+	key signer
 }
 
+// GeneratePrivateKey returns a new P-256 key.
 func GeneratePrivateKey() PrivateKey {
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	k, err := GenerateKey(P256)
 	if err != nil {
 		panic(err)
 	}
+	return k
+}
 
-	return PrivateKey{
-		key: key,
+// GenerateKey returns a new key of the algorithm.
+func GenerateKey(alg Algorithm) (PrivateKey, error) {
+	s, err := schemeOf(alg)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+
+	key, err := s.generateKey()
+	if err != nil {
+		return PrivateKey{}, err
 	}
+
+	return PrivateKey{key: key}, nil
+}
+
+func (k PrivateKey) Algorithm() Algorithm {
+	return k.key.Algorithm()
 }
 
+// MessageSigningDomain is the domain of the digest signed by Sign, so that a
+// signed message can never pass for a transaction, a header or any other
+// digest signed in its own domain.
+const MessageSigningDomain = "blockchain-from-scratch/message/v1"
+
+// Sign signs the digest of the data in MessageSigningDomain, so that the
+// signature covers data of any length.
 func (k PrivateKey) Sign(data []byte) (*Signature, error) {
-	r, s, err := ecdsa.Sign(rand.Reader, k.key, data)
+	return k.SignDigest(Digest(MessageSigningDomain, data))
+}
 
-	if err != nil {
-		panic(err)
-	}
-	return &Signature{R: r, S: s}, nil
+// SignDigest signs a digest computed by the caller, see Digest. Signatures
+// are deterministic, the ECDSA nonce is derived from the key and the digest
+// as in RFC 6979 and S is the low one of its two valid values.
+func (k PrivateKey) SignDigest(digest types.Hash) (*Signature, error) {
+	return k.key.SignDigest(digest)
 }
 
 func (k PrivateKey) PublicKey() PublicKey {
-	return PublicKey{
-		Key: &k.key.PublicKey,
-	}
+	return k.key.PublicKey()
 }
 
 type PublicKey struct {
-	Key *ecdsa.PublicKey
+	Algorithm Algorithm
+	// Key is the encoding of the key, compressed for the ECDSA algorithms.
+	// It is nil when there is no key.
+	Key []byte
 }
 
 func (k PublicKey) ToSlice() []byte {
-	return elliptic.MarshalCompressed(k.Key, k.Key.X, k.Key.Y)
+	return k.Key
+}
+
+// GobEncode encodes the algorithm followed by the key.
+func (k PublicKey) GobEncode() ([]byte, error) {
+	if k.Key == nil {
+		return []byte{}, nil
+	}
+	return append([]byte{byte(k.Algorithm)}, k.Key...), nil
+}
+
+func (k *PublicKey) GobDecode(b []byte) error {
+	if len(b) == 0 {
+		*k = PublicKey{}
+		return nil
+	}
+
+	key, err := ParsePublicKey(Algorithm(b[0]), b[1:])
+	if err != nil {
+		return err
+	}
+
+	*k = key
+	return nil
+}
+
+// MarshalJSON encodes the key as the hex of its encoding, prefixed with the
+// algorithm and a colon for other algorithms than P-256.
+func (k PublicKey) MarshalJSON() ([]byte, error) {
+	if k.Key == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(encodeTagged(k.Algorithm, k.Key))
+}
+
+func (k *PublicKey) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	alg, b, err := decodeTagged(data)
+	if err != nil {
+		return err
+	}
+
+	key, err := ParsePublicKey(alg, b)
+	if err != nil {
+		return err
+	}
+
+	*k = key
+	return nil
+}
+
+// PublicKeyFromBytes parses a P-256 public key in its compressed form.
+func PublicKeyFromBytes(b []byte) (PublicKey, error) {
+	return ParsePublicKey(P256, b)
+}
+
+// ParsePublicKey parses the encoding of a public key of the algorithm.
+func ParsePublicKey(alg Algorithm, b []byte) (PublicKey, error) {
+	s, err := schemeOf(alg)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	if err := s.checkPublicKey(b); err != nil {
+		return PublicKey{}, err
+	}
+
+	return PublicKey{Algorithm: alg, Key: append([]byte{}, b...)}, nil
 }
 
 func (k PublicKey) Address() types.Address {
@@ -54,10 +151,273 @@ func (k PublicKey) Address() types.Address {
 	return types.AddressFromBytes(b[len(b)-20:])
 }
 
+const (
+	// SignatureSize is the size of the compact encoding of a signature.
+	SignatureSize = 2 * scalarSize
+	// RecoverableSignatureSize is the size of the compact encoding of a
+	// recoverable signature, followed by its recovery id.
+	RecoverableSignatureSize = SignatureSize + 1
+)
+
 type Signature struct {
-	R, S *big.Int
+	Algorithm Algorithm
+	R, S      *big.Int
+	// V is the recovery id plus one of a recoverable secp256k1 signature,
+	// 0 when the public key can not be recovered from the signature.
+	V uint8
+}
+
+// Recoverable tells if the public key of the signer can be recovered from
+// the signature, see RecoverPublicKey.
+func (sig Signature) Recoverable() bool {
+	return sig.V != 0
+}
+
+// Bytes returns the compact encoding of the signature, R and S both left
+// padded to 32 bytes. The algorithm is not part of it.
+func (sig Signature) Bytes() ([]byte, error) {
+	if sig.R == nil || sig.S == nil {
+		return nil, fmt.Errorf("signature has no scalars")
+	}
+	if sig.R.Sign() < 0 || sig.S.Sign() < 0 || sig.R.BitLen() > 8*scalarSize || sig.S.BitLen() > 8*scalarSize {
+		return nil, fmt.Errorf("signature scalar out of range")
+	}
+
+	b := make([]byte, SignatureSize)
+	sig.R.FillBytes(b[:scalarSize])
+	sig.S.FillBytes(b[scalarSize:])
+
+	return b, nil
+}
+
+// SignatureFromBytes parses the compact encoding of a P-256 signature.
+func SignatureFromBytes(b []byte) (*Signature, error) {
+	return ParseSignature(P256, b)
+}
+
+// RecoverableBytes returns the compact encoding of a recoverable signature
+// followed by its recovery id.
+func (sig Signature) RecoverableBytes() ([]byte, error) {
+	if !sig.Recoverable() {
+		return nil, fmt.Errorf("signature is not recoverable")
+	}
+
+	b, err := sig.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, sig.V-1), nil
+}
+
+// ParseRecoverableSignature parses the encoding of RecoverableBytes.
+func ParseRecoverableSignature(alg Algorithm, b []byte) (*Signature, error) {
+	if len(b) != RecoverableSignatureSize {
+		return nil, fmt.Errorf("given signature with length %d should be %d", len(b), RecoverableSignatureSize)
+	}
+
+	sig, err := ParseSignature(alg, b[:SignatureSize])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := recovererOf(alg); err != nil {
+		return nil, err
+	}
+	if b[SignatureSize] > 3 {
+		return nil, fmt.Errorf("invalid signature recovery id %d", b[SignatureSize])
+	}
+
+	sig.V = b[SignatureSize] + 1
+	return sig, nil
+}
+
+// parseSignatureBytes parses the encoding of Bytes or RecoverableBytes.
+func parseSignatureBytes(alg Algorithm, b []byte) (*Signature, error) {
+	if len(b) == RecoverableSignatureSize {
+		return ParseRecoverableSignature(alg, b)
+	}
+	return ParseSignature(alg, b)
+}
+
+// encode returns RecoverableBytes for a recoverable signature and Bytes
+// otherwise.
+func (sig Signature) encode() ([]byte, error) {
+	if sig.Recoverable() {
+		return sig.RecoverableBytes()
+	}
+	return sig.Bytes()
+}
+
+// ParseSignature parses the compact encoding of a signature of the
+// algorithm. For ECDSA it rejects scalars out of [1, N-1] and a high S.
+func ParseSignature(alg Algorithm, b []byte) (*Signature, error) {
+	s, err := schemeOf(alg)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != SignatureSize {
+		return nil, fmt.Errorf("given signature with length %d should be %d", len(b), SignatureSize)
+	}
+
+	sig := &Signature{
+		Algorithm: alg,
+		R:         new(big.Int).SetBytes(b[:scalarSize]),
+		S:         new(big.Int).SetBytes(b[scalarSize:]),
+	}
+	if err := s.checkSignature(sig); err != nil {
+		return nil, err
+	}
+
+	return sig, nil
+}
+
+// GobEncode encodes the algorithm followed by the compact form, with the
+// recovery id of a recoverable signature.
+func (sig Signature) GobEncode() ([]byte, error) {
+	b, err := sig.encode()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(sig.Algorithm)}, b...), nil
+}
+
+func (sig *Signature) GobDecode(b []byte) error {
+	if len(b) == 0 {
+		return fmt.Errorf("empty signature")
+	}
+
+	decoded, err := parseSignatureBytes(Algorithm(b[0]), b[1:])
+	if err != nil {
+		return err
+	}
+
+	*sig = *decoded
+	return nil
+}
+
+// MarshalJSON encodes the signature as the hex of its compact form, with the
+// recovery id of a recoverable signature, prefixed with the algorithm and a
+// colon for other algorithms than P-256.
+func (sig Signature) MarshalJSON() ([]byte, error) {
+	if sig.R == nil || sig.S == nil {
+		return []byte("null"), nil
+	}
+
+	b, err := sig.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(encodeTagged(sig.Algorithm, b))
+}
+
+func (sig *Signature) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	alg, b, err := decodeTagged(data)
+	if err != nil {
+		return err
+	}
+
+	decoded, err := parseSignatureBytes(alg, b)
+	if err != nil {
+		return err
+	}
+
+	*sig = *decoded
+	return nil
 }
 
+// encodeTagged returns the hex of b, prefixed with the algorithm unless it
+// is P-256 so that the encoding of P-256 keys and signatures is unchanged.
+func encodeTagged(alg Algorithm, b []byte) string {
+	if alg == P256 {
+		return hex.EncodeToString(b)
+	}
+	return alg.String() + ":" + hex.EncodeToString(b)
+}
+
+func decodeTagged(data []byte) (Algorithm, []byte, error) {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return 0, nil, err
+	}
+	return parseTagged(s)
+}
+
+// parseTagged parses the output of encodeTagged.
+func parseTagged(s string) (Algorithm, []byte, error) {
+	alg := P256
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		var err error
+		if alg, err = ParseAlgorithm(s[:i]); err != nil {
+			return 0, nil, err
+		}
+		s = s[i+1:]
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return alg, b, nil
+}
+
+// Verify tells if the signature was made by Sign over the data with the
+// private key of pubkey.
 func (sig Signature) Verify(pubkey PublicKey, data []byte) bool {
-	return ecdsa.Verify(pubkey.Key, data, sig.R, sig.S)
+	return sig.VerifyDigest(pubkey, Digest(MessageSigningDomain, data))
+}
+
+// VerifyDigest tells if the signature was made by SignDigest over the digest
+// with the private key of pubkey. The signature and the key must be of the
+// same algorithm and an ECDSA signature with a high S is never valid.
+func (sig Signature) VerifyDigest(pubkey PublicKey, digest types.Hash) bool {
+	if pubkey.Key == nil || sig.R == nil || sig.S == nil {
+		return false
+	}
+
+	s, err := schemeOf(pubkey.Algorithm)
+	if err != nil {
+		return false
+	}
+
+	return s.VerifyDigest(pubkey, digest, &sig)
+}
+
+// RecoverPublicKey returns the public key whose private key made the
+// recoverable signature over the digest. A valid recoverable signature
+// verifies against the returned key, any other gives an error or a key
+// nobody holds the private key of.
+func (sig Signature) RecoverPublicKey(digest types.Hash) (PublicKey, error) {
+	if !sig.Recoverable() {
+		return PublicKey{}, fmt.Errorf("signature is not recoverable")
+	}
+
+	r, err := recovererOf(sig.Algorithm)
+	if err != nil {
+		return PublicKey{}, err
+	}
+
+	return r.recoverPublicKey(digest, &sig)
+}
+
+// Digest returns the SHA-256 digest of the message in the given domain. The
+// domain is length prefixed so that the digest of a message in one domain is
+// never the digest of a message in another domain, a signature over one can
+// not be replayed as the other.
+func Digest(domain string, msg []byte) types.Hash {
+	if len(domain) > 255 {
+		panic("crypto: digest domain longer than 255 bytes")
+	}
+
+	h := sha256.New()
+	h.Write([]byte{byte(len(domain))})
+	h.Write([]byte(domain))
+	h.Write(msg)
+
+	return types.HashFromBytes(h.Sum(nil))
 }
@@ -1,6 +1,12 @@
 package crypto
 
 import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/json"
+	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -31,3 +37,165 @@ func TestKeypair_Sign_Verify_Fail(t *testing.T) {
 	assert.False(t, sig.Verify(otherPubKey, msg))
 	assert.False(t, sig.Verify(pubKey, []byte("Xxxxxx")))
 }
+
+func TestSignLongMessage(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	pubKey := privKey.PublicKey()
+	msg := bytes.Repeat([]byte("abcdefgh"), 16)
+
+	sig, err := privKey.Sign(msg)
+	assert.Nil(t, err)
+	assert.True(t, sig.Verify(pubKey, msg))
+
+	// Every byte is covered, not only the first 32
+	for i := range msg {
+		tampered := append([]byte{}, msg...)
+		tampered[i] ^= 0x01
+		assert.False(t, sig.Verify(pubKey, tampered), "byte %d", i)
+	}
+	assert.False(t, sig.Verify(pubKey, append(msg, 0)))
+}
+
+func TestDigest(t *testing.T) {
+	assert.NotEqual(t, Digest("a", []byte("bc")), Digest("ab", []byte("c")))
+	assert.NotEqual(t, Digest("tx", []byte("foo")), Digest("block", []byte("foo")))
+	assert.Equal(t, Digest("tx", []byte("foo")), Digest("tx", []byte("foo")))
+
+	privKey := GeneratePrivateKey()
+	digest := Digest("tx", []byte("foo"))
+	sig, err := privKey.SignDigest(digest)
+	assert.Nil(t, err)
+	assert.True(t, sig.VerifyDigest(privKey.PublicKey(), digest))
+	assert.False(t, sig.VerifyDigest(privKey.PublicKey(), Digest("block", []byte("foo"))))
+	assert.False(t, sig.VerifyDigest(PublicKey{}, digest))
+}
+
+func TestPublicKeyGobEncodeDecode(t *testing.T) {
+	pubKey := GeneratePrivateKey().PublicKey()
+	buf := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buf).Encode(pubKey))
+
+	decoded := PublicKey{}
+	assert.Nil(t, gob.NewDecoder(buf).Decode(&decoded))
+	assert.Equal(t, pubKey.ToSlice(), decoded.ToSlice())
+	assert.Equal(t, pubKey.Address(), decoded.Address())
+}
+
+func TestKeypairJSON(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	pubKey := privKey.PublicKey()
+	msg := []byte("Hello World")
+
+	sig, err := privKey.Sign(msg)
+	assert.Nil(t, err)
+
+	b, err := json.Marshal(pubKey)
+	assert.Nil(t, err)
+	decodedKey := PublicKey{}
+	assert.Nil(t, json.Unmarshal(b, &decodedKey))
+	assert.Equal(t, pubKey.Address(), decodedKey.Address())
+
+	b, err = json.Marshal(sig)
+	assert.Nil(t, err)
+	decodedSig := new(Signature)
+	assert.Nil(t, json.Unmarshal(b, decodedSig))
+	assert.Equal(t, 0, sig.R.Cmp(decodedSig.R))
+	assert.Equal(t, 0, sig.S.Cmp(decodedSig.S))
+
+	assert.True(t, decodedSig.Verify(decodedKey, msg))
+
+	assert.NotNil(t, json.Unmarshal([]byte(`"00ff"`), decodedSig))
+	assert.NotNil(t, json.Unmarshal([]byte(`"00ff"`), &decodedKey))
+}
+
+func TestSignDomainSeparated(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	pubKey := privKey.PublicKey()
+	msg := []byte("foo")
+
+	sig, err := privKey.Sign(msg)
+	assert.Nil(t, err)
+	assert.True(t, sig.VerifyDigest(pubKey, Digest(MessageSigningDomain, msg)))
+	assert.False(t, sig.VerifyDigest(pubKey, sha256.Sum256(msg)))
+	assert.False(t, sig.VerifyDigest(pubKey, Digest("blockchain-from-scratch/tx/v1", msg)))
+}
+
+func TestSignRFC6979(t *testing.T) {
+	// Test vector of RFC 6979 A.2.5, P-256 with SHA-256, over the plain
+	// digest of the message
+	d, _ := new(big.Int).SetString("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721", 16)
+	privKey, err := PrivateKeyFromBytes(d.Bytes())
+	assert.Nil(t, err)
+
+	ux, _ := new(big.Int).SetString("60FED4BA255A9D31C961EB74C6356D68C049B8923B61FA6CE669622E60F29FB6", 16)
+	assert.Equal(t, ux.Bytes(), privKey.PublicKey().Key[1:])
+
+	r, _ := new(big.Int).SetString("EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716", 16)
+	s, _ := new(big.Int).SetString("F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8", 16)
+	// The vector has a high S
+	s.Sub(elliptic.P256().Params().N, s)
+
+	sig, err := privKey.SignDigest(sha256.Sum256([]byte("sample")))
+	assert.Nil(t, err)
+	assert.Equal(t, r, sig.R)
+	assert.Equal(t, s, sig.S)
+}
+
+func TestSignDeterministicLowS(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	pubKey := privKey.PublicKey()
+
+	for i := 0; i < 20; i++ {
+		msg := []byte{byte(i)}
+		sig, err := privKey.Sign(msg)
+		assert.Nil(t, err)
+
+		again, err := privKey.Sign(msg)
+		assert.Nil(t, err)
+		assert.Equal(t, sig, again)
+
+		assert.True(t, sig.S.Cmp(p256Scheme.halfOrder) <= 0)
+		assert.True(t, sig.Verify(pubKey, msg))
+
+		// The high S twin is valid ECDSA but not accepted
+		highS := &Signature{R: sig.R, S: new(big.Int).Sub(elliptic.P256().Params().N, sig.S)}
+		assert.False(t, highS.Verify(pubKey, msg))
+	}
+}
+
+func TestSignatureBytes(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	sig, err := privKey.Sign([]byte("foo"))
+	assert.Nil(t, err)
+
+	b, err := sig.Bytes()
+	assert.Nil(t, err)
+	assert.Len(t, b, SignatureSize)
+
+	decoded, err := SignatureFromBytes(b)
+	assert.Nil(t, err)
+	assert.Equal(t, sig.R, decoded.R)
+	assert.Equal(t, sig.S, decoded.S)
+	assert.False(t, decoded.Recoverable())
+
+	assert.False(t, sig.Recoverable())
+	assert.Equal(t, sig, decoded)
+
+	_, err = SignatureFromBytes(b[:63])
+	assert.NotNil(t, err)
+
+	highS := &Signature{R: sig.R, S: new(big.Int).Sub(elliptic.P256().Params().N, sig.S)}
+	b, err = highS.Bytes()
+	assert.Nil(t, err)
+	_, err = SignatureFromBytes(b)
+	assert.NotNil(t, err)
+
+	_, err = SignatureFromBytes(make([]byte, SignatureSize))
+	assert.NotNil(t, err)
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buf).Encode(sig))
+	gobDecoded := &Signature{}
+	assert.Nil(t, gob.NewDecoder(buf).Decode(gobDecoded))
+	assert.Equal(t, sig, gobDecoded)
+}
@@ -0,0 +1,207 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	keystoreVersion = 1
+
+	// The scrypt parameters of new keystore files, decrypting takes about a
+	// hundred milliseconds.
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltSize     = 32
+
+	// maxScryptMemory bounds the cost a keystore file can ask for, scrypt
+	// uses 128 * N * R bytes of memory and runs in time proportional to
+	// 128 * N * R * P, both are bounded by it.
+	maxScryptMemory = 256 << 20
+)
+
+// ErrWrongPassphrase is returned when decrypting a keystore file with another
+// passphrase than the one it was encrypted with.
+var ErrWrongPassphrase = errors.New("could not decrypt key with given passphrase")
+
+// keystoreFile is the JSON format of an encrypted private key. The key
+// encrypting the private key with AES-256-GCM is derived from the passphrase
+// with scrypt, the address of the key is authenticated along. Files without
+// an algorithm hold a P-256 key.
+type keystoreFile struct {
+	Version    int          `json:"version"`
+	Algorithm  string       `json:"algorithm,omitempty"`
+	Address    string       `json:"address"`
+	KDF        string       `json:"kdf"`
+	KDFParams  scryptParams `json:"kdfparams"`
+	Cipher     string       `json:"cipher"`
+	Nonce      string       `json:"nonce"`
+	Ciphertext string       `json:"ciphertext"`
+}
+
+type scryptParams struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt string `json:"salt"`
+}
+
+// EncryptKey returns the keystore file of the key encrypted with the
+// passphrase.
+func EncryptKey(k PrivateKey, passphrase string) ([]byte, error) {
+	return encryptKey(k, passphrase, scryptN)
+}
+
+func encryptKey(k PrivateKey, passphrase string, n int) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	params := scryptParams{N: n, R: scryptR, P: scryptP, Salt: hex.EncodeToString(salt)}
+	aead, err := params.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	address := k.PublicKey().Address().String()
+	ciphertext := aead.Seal(nil, nonce, k.Bytes(), []byte(address))
+
+	return json.MarshalIndent(keystoreFile{
+		Version:    keystoreVersion,
+		Algorithm:  k.Algorithm().String(),
+		Address:    address,
+		KDF:        "scrypt",
+		KDFParams:  params,
+		Cipher:     "aes-256-gcm",
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(ciphertext),
+	}, "", "  ")
+}
+
+// DecryptKey returns the key of the keystore file.
+func DecryptKey(data []byte, passphrase string) (PrivateKey, error) {
+	var f keystoreFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return PrivateKey{}, err
+	}
+
+	if f.Version != keystoreVersion {
+		return PrivateKey{}, fmt.Errorf("unsupported keystore version %d", f.Version)
+	}
+	if f.KDF != "scrypt" || f.Cipher != "aes-256-gcm" {
+		return PrivateKey{}, fmt.Errorf("unsupported keystore kdf %s or cipher %s", f.KDF, f.Cipher)
+	}
+	if err := f.KDFParams.checkCost(); err != nil {
+		return PrivateKey{}, err
+	}
+
+	aead, err := f.KDFParams.cipher(passphrase)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+
+	nonce, err := hex.DecodeString(f.Nonce)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return PrivateKey{}, fmt.Errorf("given nonce with length %d should be %d", len(nonce), aead.NonceSize())
+	}
+	ciphertext, err := hex.DecodeString(f.Ciphertext)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(f.Address))
+	if err != nil {
+		return PrivateKey{}, ErrWrongPassphrase
+	}
+
+	alg := P256
+	if f.Algorithm != "" {
+		if alg, err = ParseAlgorithm(f.Algorithm); err != nil {
+			return PrivateKey{}, err
+		}
+	}
+
+	k, err := ParsePrivateKey(alg, plaintext)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+	if k.PublicKey().Address().String() != f.Address {
+		return PrivateKey{}, fmt.Errorf("keystore key does not match address %s", f.Address)
+	}
+
+	return k, nil
+}
+
+// checkCost checks that deriving the key stays within maxScryptMemory.
+func (p scryptParams) checkCost() error {
+	if p.N <= 1 || p.R <= 0 || p.P <= 0 {
+		return fmt.Errorf("invalid keystore scrypt parameters")
+	}
+
+	const limit = maxScryptMemory / 128
+	if p.N > limit || p.R > limit || p.P > limit || uint64(p.N)*uint64(p.R)*uint64(p.P) > limit {
+		return fmt.Errorf("keystore scrypt parameters are too expensive")
+	}
+
+	return nil
+}
+
+// cipher returns the AES-256-GCM cipher keyed by the passphrase.
+func (p scryptParams) cipher(passphrase string) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(p.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, p.N, p.R, p.P, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// SavePrivateKey writes the key encrypted with the passphrase to a keystore
+// file readable only by its owner.
+func SavePrivateKey(path string, k PrivateKey, passphrase string) error {
+	data, err := EncryptKey(k, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// LoadPrivateKey reads the key of a keystore file written by SavePrivateKey.
+func LoadPrivateKey(path string, passphrase string) (PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+
+	return DecryptKey(data, passphrase)
+}
@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testScryptN keeps the tests fast
+const testScryptN = 1 << 10
+
+func TestKeystoreEncryptDecrypt(t *testing.T) {
+	privKey := GeneratePrivateKey()
+
+	data, err := encryptKey(privKey, "secret", testScryptN)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), privKey.Hex())
+
+	decoded, err := DecryptKey(data, "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, privKey.Bytes(), decoded.Bytes())
+
+	_, err = DecryptKey(data, "wrong")
+	assert.Equal(t, ErrWrongPassphrase, err)
+
+	// The address is authenticated with the key
+	var f keystoreFile
+	assert.Nil(t, json.Unmarshal(data, &f))
+	f.Address = GeneratePrivateKey().PublicKey().Address().String()
+	tampered, err := json.Marshal(f)
+	assert.Nil(t, err)
+	_, err = DecryptKey(tampered, "secret")
+	assert.NotNil(t, err)
+
+	// A file can not ask for an arbitrary cost
+	for _, params := range [][3]int{
+		{1 << 22, 8, 1},
+		{1 << 20, 64, 1}, // 8 GiB
+		{1 << 15, 8, 1 << 20},
+		{1 << 15, 0, 1},
+	} {
+		assert.Nil(t, json.Unmarshal(data, &f))
+		f.KDFParams.N, f.KDFParams.R, f.KDFParams.P = params[0], params[1], params[2]
+		expensive, err := json.Marshal(f)
+		assert.Nil(t, err)
+		_, err = DecryptKey(expensive, "secret")
+		assert.NotNil(t, err, "%v", params)
+	}
+}
+
+func TestSaveLoadPrivateKey(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	path := filepath.Join(t.TempDir(), "validator.json")
+
+	assert.Nil(t, SavePrivateKey(path, privKey, "secret"))
+
+	loaded, err := LoadPrivateKey(path, "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, privKey.PublicKey().Address(), loaded.PublicKey().Address())
+
+	_, err = LoadPrivateKey(path, "wrong")
+	assert.Equal(t, ErrWrongPassphrase, err)
+
+	_, err = LoadPrivateKey(filepath.Join(t.TempDir(), "missing.json"), "secret")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,342 @@
+package crypto
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// Schnorr multi-signatures over P-256. A set of signers makes one signature
+// of a digest that verifies against the sum of their public keys, so
+// verifying the signatures of N signers costs about one verification.
+//
+// Signing takes three rounds between the signers:
+//
+//  1. each signer draws a MultiSigNonce and publishes its Commitment
+//  2. once every commitment is known, each signer publishes its Point and
+//     AggregateNonces checks them against the commitments and sums them
+//  3. each signer publishes SignMulti of the digest, AggregateSignatures
+//     sums them into the MultiSignature
+//
+// Committing to the nonces first keeps the last signer from choosing its
+// nonce from the others'. A key only joins a set along with a proof of
+// possession of its private key, see ProvePossession, else a signer could
+// choose a key that cancels the others' out of the sum.
+//
+// The challenge of a signature is Digest(multiSigDomain, R ‖ X ‖ digest)
+// where R is the aggregated nonce point and X the aggregated key, both
+// compressed.
+
+const (
+	multiSigDomain        = "blockchain-from-scratch/multisig/v1"
+	nonceCommitmentDomain = "blockchain-from-scratch/multisig/nonce/v1"
+	possessionDomain      = "blockchain-from-scratch/multisig/pop/v1"
+)
+
+// MultiSignatureSize is the size of the encoding of a MultiSignature.
+const MultiSignatureSize = 1 + 2*scalarSize
+
+// MultiSignature is a Schnorr signature by the aggregate of public keys, see
+// AggregatePublicKeys.
+type MultiSignature struct {
+	// R is the compressed aggregated nonce point.
+	R []byte
+	S *big.Int
+}
+
+// Bytes returns R followed by S left padded to 32 bytes.
+func (sig MultiSignature) Bytes() ([]byte, error) {
+	if len(sig.R) != 1+scalarSize || sig.S == nil || sig.S.Sign() < 0 || sig.S.BitLen() > 8*scalarSize {
+		return nil, fmt.Errorf("invalid multi-signature")
+	}
+
+	b := make([]byte, MultiSignatureSize)
+	copy(b, sig.R)
+	sig.S.FillBytes(b[len(sig.R):])
+
+	return b, nil
+}
+
+// MultiSignatureFromBytes parses the encoding of Bytes.
+func MultiSignatureFromBytes(b []byte) (*MultiSignature, error) {
+	if len(b) != MultiSignatureSize {
+		return nil, fmt.Errorf("given multi-signature with length %d should be %d", len(b), MultiSignatureSize)
+	}
+	if _, _, err := p256Scheme.decompress(b[:1+scalarSize]); err != nil {
+		return nil, err
+	}
+
+	s := new(big.Int).SetBytes(b[1+scalarSize:])
+	if s.Cmp(p256Scheme.curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("multi-signature scalar out of range")
+	}
+
+	return &MultiSignature{R: append([]byte{}, b[:1+scalarSize]...), S: s}, nil
+}
+
+func (sig MultiSignature) GobEncode() ([]byte, error) {
+	return sig.Bytes()
+}
+
+func (sig *MultiSignature) GobDecode(b []byte) error {
+	decoded, err := MultiSignatureFromBytes(b)
+	if err != nil {
+		return err
+	}
+
+	*sig = *decoded
+	return nil
+}
+
+func (sig MultiSignature) MarshalJSON() ([]byte, error) {
+	b, err := sig.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(hex.EncodeToString(b))
+}
+
+func (sig *MultiSignature) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	return sig.GobDecode(b)
+}
+
+// Verify tells if the signature was made over the digest by the signers of
+// the aggregated key, s * G = R + c * X.
+func (sig MultiSignature) Verify(aggKey PublicKey, digest types.Hash) bool {
+	return sig.verifyWithChallenge(aggKey, challenge(sig.R, aggKey.Key, digest))
+}
+
+// challenge returns the challenge of a signature by the aggregated key x with
+// the aggregated nonce point r.
+func challenge(r, x []byte, digest types.Hash) *big.Int {
+	msg := make([]byte, 0, len(r)+len(x)+len(digest))
+	msg = append(msg, r...)
+	msg = append(msg, x...)
+	msg = append(msg, digest[:]...)
+	h := Digest(multiSigDomain, msg)
+
+	c := new(big.Int).SetBytes(h[:])
+	return c.Mod(c, p256Scheme.curve.Params().N)
+}
+
+// p256Point returns the point of a P-256 public key.
+func p256Point(k PublicKey) (*big.Int, *big.Int, error) {
+	if k.Algorithm != P256 {
+		return nil, nil, fmt.Errorf("multi-signatures need P-256 keys, given a %s key", k.Algorithm)
+	}
+	return p256Scheme.decompress(k.Key)
+}
+
+// AggregatePublicKeys returns the key the multi-signatures of the keys
+// verify against. Each key must have been checked with VerifyPossession.
+func AggregatePublicKeys(keys []PublicKey) (PublicKey, error) {
+	if len(keys) == 0 {
+		return PublicKey{}, fmt.Errorf("no public key to aggregate")
+	}
+
+	curve := p256Scheme.curve
+	x, y := new(big.Int), new(big.Int)
+	for _, k := range keys {
+		kx, ky, err := p256Point(k)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		x, y = curve.Add(x, y, kx, ky)
+	}
+	if isInfinity(x, y) {
+		return PublicKey{}, fmt.Errorf("aggregated public key is the point at infinity")
+	}
+
+	return PublicKey{Algorithm: P256, Key: elliptic.MarshalCompressed(curve, x, y)}, nil
+}
+
+// MultiSigNonce is the secret nonce of a signer for one multi-signature, it
+// must never be used twice.
+type MultiSigNonce struct {
+	k     *big.Int
+	point []byte
+}
+
+// NewMultiSigNonce draws a random nonce. Unlike the ECDSA nonces it is not
+// derived from the digest, the aggregated nonce depends on the other signers
+// and signing twice with one nonce under two aggregated nonces leaks the key.
+func NewMultiSigNonce() (*MultiSigNonce, error) {
+	key, err := p256Scheme.generateKey()
+	if err != nil {
+		return nil, err
+	}
+	k := key.(*ecdsaKey)
+
+	return &MultiSigNonce{
+		k:     k.d,
+		point: elliptic.MarshalCompressed(p256Scheme.curve, k.x, k.y),
+	}, nil
+}
+
+// Point returns the compressed nonce point, published in the second round.
+func (n *MultiSigNonce) Point() []byte {
+	return n.point
+}
+
+// Commitment returns the commitment to the nonce point, published in the
+// first round.
+func (n *MultiSigNonce) Commitment() types.Hash {
+	return NonceCommitment(n.point)
+}
+
+// NonceCommitment returns the commitment to a nonce point.
+func NonceCommitment(point []byte) types.Hash {
+	return Digest(nonceCommitmentDomain, point)
+}
+
+// AggregateNonces checks the nonce points of the signers against their
+// commitments and returns the compressed aggregated nonce point.
+func AggregateNonces(commitments []types.Hash, points [][]byte) ([]byte, error) {
+	if len(points) == 0 || len(points) != len(commitments) {
+		return nil, fmt.Errorf("given %d nonce points for %d commitments", len(points), len(commitments))
+	}
+
+	curve := p256Scheme.curve
+	x, y := new(big.Int), new(big.Int)
+	for i, point := range points {
+		if NonceCommitment(point) != commitments[i] {
+			return nil, fmt.Errorf("nonce point %d does not match its commitment", i)
+		}
+		px, py, err := p256Scheme.decompress(point)
+		if err != nil {
+			return nil, err
+		}
+		x, y = curve.Add(x, y, px, py)
+	}
+	if isInfinity(x, y) {
+		return nil, fmt.Errorf("aggregated nonce is the point at infinity")
+	}
+
+	return elliptic.MarshalCompressed(curve, x, y), nil
+}
+
+// SignMulti returns the partial signature of the digest by the key,
+// s = k + c * x. It uses up the nonce.
+func (k PrivateKey) SignMulti(nonce *MultiSigNonce, aggNonce []byte, aggKey PublicKey, digest types.Hash) (*big.Int, error) {
+	key, ok := k.key.(*ecdsaKey)
+	if !ok || key.scheme != p256Scheme {
+		return nil, fmt.Errorf("multi-signatures need P-256 keys, given a %s key", k.Algorithm())
+	}
+	if nonce.k == nil {
+		return nil, fmt.Errorf("multi-signature nonce already used")
+	}
+	if _, _, err := p256Scheme.decompress(aggNonce); err != nil {
+		return nil, err
+	}
+	if _, _, err := p256Point(aggKey); err != nil {
+		return nil, err
+	}
+
+	n := p256Scheme.curve.Params().N
+	s := challenge(aggNonce, aggKey.Key, digest)
+	s.Mul(s, key.d)
+	s.Add(s, nonce.k)
+	s.Mod(s, n)
+
+	nonce.k = nil
+	return s, nil
+}
+
+// VerifyPartialSignature tells if a partial signature was made by the key
+// with the nonce point, s * G = R_i + c * X_i. The aggregator uses it to
+// find the signers whose partial signature spoils the aggregate.
+func VerifyPartialSignature(pubKey PublicKey, point []byte, aggNonce []byte, aggKey PublicKey, digest types.Hash, s *big.Int) bool {
+	// The challenge is the one of the aggregate, not of the partial
+	// signature alone
+	sig := MultiSignature{R: point, S: s}
+	return sig.verifyWithChallenge(pubKey, challenge(aggNonce, aggKey.Key, digest))
+}
+
+// verifyWithChallenge tells if s * G = R + c * X.
+func (sig MultiSignature) verifyWithChallenge(pubKey PublicKey, c *big.Int) bool {
+	curve := p256Scheme.curve
+	if sig.S == nil {
+		return false
+	}
+
+	rx, ry, err := p256Scheme.decompress(sig.R)
+	if err != nil {
+		return false
+	}
+	xx, xy, err := p256Point(pubKey)
+	if err != nil {
+		return false
+	}
+	if sig.S.Sign() < 0 || sig.S.Cmp(curve.Params().N) >= 0 {
+		return false
+	}
+
+	lx, ly := curve.ScalarBaseMult(sig.S.FillBytes(make([]byte, scalarSize)))
+	cx, cy := curve.ScalarMult(xx, xy, c.FillBytes(make([]byte, scalarSize)))
+	px, py := curve.Add(rx, ry, cx, cy)
+
+	return lx.Cmp(px) == 0 && ly.Cmp(py) == 0
+}
+
+// AggregateSignatures returns the multi-signature of the partial signatures
+// made under the aggregated nonce.
+func AggregateSignatures(aggNonce []byte, partials []*big.Int) (*MultiSignature, error) {
+	if len(partials) == 0 {
+		return nil, fmt.Errorf("no partial signature to aggregate")
+	}
+	if _, _, err := p256Scheme.decompress(aggNonce); err != nil {
+		return nil, err
+	}
+
+	n := p256Scheme.curve.Params().N
+	s := new(big.Int)
+	for _, partial := range partials {
+		if partial == nil {
+			return nil, fmt.Errorf("missing partial signature")
+		}
+		s.Add(s, partial)
+	}
+
+	return &MultiSignature{R: append([]byte{}, aggNonce...), S: s.Mod(s, n)}, nil
+}
+
+// ProvePossession returns the proof that the holder of the public key of k
+// knows its private key, a Schnorr signature of the key by itself.
+func ProvePossession(k PrivateKey) (*MultiSignature, error) {
+	nonce, err := NewMultiSigNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey := k.PublicKey()
+	s, err := k.SignMulti(nonce, nonce.Point(), pubKey, possessionDigest(pubKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultiSignature{R: nonce.Point(), S: s}, nil
+}
+
+// VerifyPossession tells if the proof was made by ProvePossession with the
+// private key of the public key.
+func VerifyPossession(pubKey PublicKey, proof *MultiSignature) bool {
+	return proof != nil && proof.Verify(pubKey, possessionDigest(pubKey))
+}
+
+func possessionDigest(pubKey PublicKey) types.Hash {
+	return Digest(possessionDomain, pubKey.Key)
+}
@@ -0,0 +1,163 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// multiSign runs the three rounds of a multi-signature of the digest by the
+// keys.
+func multiSign(t *testing.T, keys []PrivateKey, digest types.Hash) (*MultiSignature, PublicKey) {
+	pubKeys := make([]PublicKey, len(keys))
+	nonces := make([]*MultiSigNonce, len(keys))
+	commitments := make([]types.Hash, len(keys))
+	points := make([][]byte, len(keys))
+	for i, k := range keys {
+		pubKeys[i] = k.PublicKey()
+
+		nonce, err := NewMultiSigNonce()
+		assert.Nil(t, err)
+		nonces[i] = nonce
+		commitments[i] = nonce.Commitment()
+	}
+	for i, nonce := range nonces {
+		points[i] = nonce.Point()
+	}
+
+	aggKey, err := AggregatePublicKeys(pubKeys)
+	assert.Nil(t, err)
+	aggNonce, err := AggregateNonces(commitments, points)
+	assert.Nil(t, err)
+
+	partials := make([]*big.Int, len(keys))
+	for i, k := range keys {
+		partials[i], err = k.SignMulti(nonces[i], aggNonce, aggKey, digest)
+		assert.Nil(t, err)
+		assert.True(t, VerifyPartialSignature(pubKeys[i], points[i], aggNonce, aggKey, digest, partials[i]))
+	}
+
+	sig, err := AggregateSignatures(aggNonce, partials)
+	assert.Nil(t, err)
+
+	return sig, aggKey
+}
+
+func generateKeys(n int) []PrivateKey {
+	keys := make([]PrivateKey, n)
+	for i := range keys {
+		keys[i] = GeneratePrivateKey()
+	}
+	return keys
+}
+
+func TestMultiSignature(t *testing.T) {
+	keys := generateKeys(5)
+	digest := sha256.Sum256([]byte("foo"))
+
+	sig, aggKey := multiSign(t, keys, digest)
+	assert.True(t, sig.Verify(aggKey, digest))
+	assert.False(t, sig.Verify(aggKey, sha256.Sum256([]byte("bar"))))
+
+	// A subset of the signers has another key
+	subKey, err := AggregatePublicKeys([]PublicKey{keys[0].PublicKey(), keys[1].PublicKey()})
+	assert.Nil(t, err)
+	assert.False(t, sig.Verify(subKey, digest))
+
+	b, err := sig.Bytes()
+	assert.Nil(t, err)
+	assert.Len(t, b, MultiSignatureSize)
+	decoded, err := MultiSignatureFromBytes(b)
+	assert.Nil(t, err)
+	assert.Equal(t, sig, decoded)
+	assert.True(t, decoded.Verify(aggKey, digest))
+}
+
+func TestMultiSignatureSingleSigner(t *testing.T) {
+	keys := generateKeys(1)
+	digest := sha256.Sum256([]byte("foo"))
+
+	sig, aggKey := multiSign(t, keys, digest)
+	assert.Equal(t, keys[0].PublicKey(), aggKey)
+	assert.True(t, sig.Verify(aggKey, digest))
+}
+
+func TestMultiSigNonceReuse(t *testing.T) {
+	k := GeneratePrivateKey()
+	digest := sha256.Sum256([]byte("foo"))
+
+	nonce, err := NewMultiSigNonce()
+	assert.Nil(t, err)
+	_, err = k.SignMulti(nonce, nonce.Point(), k.PublicKey(), digest)
+	assert.Nil(t, err)
+	_, err = k.SignMulti(nonce, nonce.Point(), k.PublicKey(), digest)
+	assert.NotNil(t, err)
+}
+
+func TestAggregateNoncesCommitment(t *testing.T) {
+	a, err := NewMultiSigNonce()
+	assert.Nil(t, err)
+	b, err := NewMultiSigNonce()
+	assert.Nil(t, err)
+
+	_, err = AggregateNonces([]types.Hash{a.Commitment(), b.Commitment()}, [][]byte{a.Point(), b.Point()})
+	assert.Nil(t, err)
+
+	// A point other than the committed one
+	_, err = AggregateNonces([]types.Hash{a.Commitment(), b.Commitment()}, [][]byte{a.Point(), a.Point()})
+	assert.NotNil(t, err)
+}
+
+func TestVerifyPartialSignature(t *testing.T) {
+	keys := generateKeys(2)
+	digest := sha256.Sum256([]byte("foo"))
+
+	a, err := NewMultiSigNonce()
+	assert.Nil(t, err)
+	b, err := NewMultiSigNonce()
+	assert.Nil(t, err)
+	aggKey, err := AggregatePublicKeys([]PublicKey{keys[0].PublicKey(), keys[1].PublicKey()})
+	assert.Nil(t, err)
+	aggNonce, err := AggregateNonces([]types.Hash{a.Commitment(), b.Commitment()}, [][]byte{a.Point(), b.Point()})
+	assert.Nil(t, err)
+
+	s, err := keys[0].SignMulti(a, aggNonce, aggKey, digest)
+	assert.Nil(t, err)
+	assert.True(t, VerifyPartialSignature(keys[0].PublicKey(), a.Point(), aggNonce, aggKey, digest, s))
+	assert.False(t, VerifyPartialSignature(keys[1].PublicKey(), a.Point(), aggNonce, aggKey, digest, s))
+	assert.False(t, VerifyPartialSignature(keys[0].PublicKey(), b.Point(), aggNonce, aggKey, digest, s))
+
+	// A bad partial signature spoils the aggregate
+	sig, err := AggregateSignatures(aggNonce, []*big.Int{s, big.NewInt(1)})
+	assert.Nil(t, err)
+	assert.False(t, sig.Verify(aggKey, digest))
+}
+
+func TestProvePossession(t *testing.T) {
+	k := GeneratePrivateKey()
+	proof, err := ProvePossession(k)
+	assert.Nil(t, err)
+	assert.True(t, VerifyPossession(k.PublicKey(), proof))
+
+	other := GeneratePrivateKey()
+	assert.False(t, VerifyPossession(other.PublicKey(), proof))
+	assert.False(t, VerifyPossession(k.PublicKey(), nil))
+
+	// A multi-signature of another digest is no proof
+	digest := sha256.Sum256([]byte("foo"))
+	sig, _ := multiSign(t, []PrivateKey{k}, digest)
+	assert.False(t, VerifyPossession(k.PublicKey(), sig))
+}
+
+func TestMultiSignatureOtherAlgorithm(t *testing.T) {
+	k, err := GenerateKey(Secp256k1)
+	assert.Nil(t, err)
+
+	_, err = ProvePossession(k)
+	assert.NotNil(t, err)
+	_, err = AggregatePublicKeys([]PublicKey{k.PublicKey()})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,80 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"math/big"
+)
+
+// nonceGenerator derives the nonces of a signature from the private key and
+// the digest as in RFC 6979 with HMAC-SHA256, so that signing the same digest
+// twice gives the same signature without relying on a random source.
+type nonceGenerator struct {
+	n    *big.Int
+	k, v []byte
+}
+
+func newNonceGenerator(priv *big.Int, digest []byte, n *big.Int) *nonceGenerator {
+	size := (n.BitLen() + 7) / 8
+	x := priv.FillBytes(make([]byte, size))
+	h1 := new(big.Int).Mod(bitsToInt(digest, n), n).FillBytes(make([]byte, size))
+
+	g := &nonceGenerator{
+		n: n,
+		k: make([]byte, sha256.Size),
+		v: make([]byte, sha256.Size),
+	}
+	for i := range g.v {
+		g.v[i] = 0x01
+	}
+
+	g.k = g.mac(g.v, []byte{0x00}, x, h1)
+	g.v = g.mac(g.v)
+	g.k = g.mac(g.v, []byte{0x01}, x, h1)
+	g.v = g.mac(g.v)
+
+	return g
+}
+
+func (g *nonceGenerator) mac(data ...[]byte) []byte {
+	h := hmac.New(sha256.New, g.k)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// next returns the next nonce in [1, n-1], the signer asks for another one
+// in the rare case where a nonce gives a zero r or s.
+func (g *nonceGenerator) next() *big.Int {
+	size := (g.n.BitLen() + 7) / 8
+
+	for {
+		t := []byte{}
+		for len(t) < size {
+			g.v = g.mac(g.v)
+			t = append(t, g.v...)
+		}
+
+		k := bitsToInt(t, g.n)
+		if k.Sign() > 0 && k.Cmp(g.n) < 0 {
+			// Prepare the state for a following call
+			g.k = g.mac(g.v, []byte{0x00})
+			g.v = g.mac(g.v)
+			return k
+		}
+
+		g.k = g.mac(g.v, []byte{0x00})
+		g.v = g.mac(g.v)
+	}
+}
+
+// bitsToInt returns the leftmost bits of b as an integer of the bit length of
+// n.
+func bitsToInt(b []byte, n *big.Int) *big.Int {
+	x := new(big.Int).SetBytes(b)
+	if excess := len(b)*8 - n.BitLen(); excess > 0 {
+		x.Rsh(x, uint(excess))
+	}
+	return x
+}
@@ -0,0 +1,144 @@
+package crypto
+
+import (
+	"crypto/elliptic"
+	"math/big"
+)
+
+// secp256k1Curve is the curve y² = x³ + 7 of Bitcoin. The curves of the
+// standard library all have a = -3, so its arithmetic is implemented here
+// with big.Int in affine coordinates. It is not constant time. The point at
+// infinity is (0, 0) as in the elliptic package.
+type secp256k1Curve struct {
+	params *elliptic.CurveParams
+}
+
+func hexInt(s string) *big.Int {
+	x, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("crypto: invalid hex integer " + s)
+	}
+	return x
+}
+
+var secp256k1 = &secp256k1Curve{
+	params: &elliptic.CurveParams{
+		P:       hexInt("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f"),
+		N:       hexInt("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141"),
+		B:       big.NewInt(7),
+		Gx:      hexInt("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"),
+		Gy:      hexInt("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"),
+		BitSize: 256,
+		Name:    "secp256k1",
+	},
+}
+
+func (c *secp256k1Curve) Params() *elliptic.CurveParams {
+	return c.params
+}
+
+func (c *secp256k1Curve) IsOnCurve(x, y *big.Int) bool {
+	p := c.params.P
+	if x.Sign() < 0 || x.Cmp(p) >= 0 || y.Sign() < 0 || y.Cmp(p) >= 0 {
+		return false
+	}
+
+	y2 := new(big.Int).Mul(y, y)
+	y2.Mod(y2, p)
+
+	return y2.Cmp(c.polynomial(x)) == 0
+}
+
+// polynomial returns x³ + 7.
+func (c *secp256k1Curve) polynomial(x *big.Int) *big.Int {
+	x3 := new(big.Int).Mul(x, x)
+	x3.Mul(x3, x)
+	x3.Add(x3, c.params.B)
+
+	return x3.Mod(x3, c.params.P)
+}
+
+func isInfinity(x, y *big.Int) bool {
+	return x.Sign() == 0 && y.Sign() == 0
+}
+
+func (c *secp256k1Curve) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	p := c.params.P
+
+	switch {
+	case isInfinity(x1, y1):
+		return new(big.Int).Set(x2), new(big.Int).Set(y2)
+	case isInfinity(x2, y2):
+		return new(big.Int).Set(x1), new(big.Int).Set(y1)
+	case x1.Cmp(x2) == 0:
+		if y1.Cmp(y2) == 0 {
+			return c.Double(x1, y1)
+		}
+		// P + (-P)
+		return new(big.Int), new(big.Int)
+	}
+
+	// λ = (y2 - y1) / (x2 - x1)
+	num := new(big.Int).Sub(y2, y1)
+	den := new(big.Int).Sub(x2, x1)
+	den.Mod(den, p)
+	lambda := num.Mul(num, den.ModInverse(den, p))
+	lambda.Mod(lambda, p)
+
+	return c.fromLambda(lambda, x1, y1, x2)
+}
+
+func (c *secp256k1Curve) Double(x1, y1 *big.Int) (*big.Int, *big.Int) {
+	p := c.params.P
+	if isInfinity(x1, y1) || y1.Sign() == 0 {
+		return new(big.Int), new(big.Int)
+	}
+
+	// λ = 3x² / 2y
+	num := new(big.Int).Mul(x1, x1)
+	num.Mul(num, big.NewInt(3))
+	den := new(big.Int).Lsh(y1, 1)
+	den.Mod(den, p)
+	lambda := num.Mul(num, den.ModInverse(den, p))
+	lambda.Mod(lambda, p)
+
+	return c.fromLambda(lambda, x1, y1, x1)
+}
+
+// fromLambda returns the sum of (x1, y1) and a point of abscissa x2 on the
+// line of slope λ through them.
+func (c *secp256k1Curve) fromLambda(lambda, x1, y1, x2 *big.Int) (*big.Int, *big.Int) {
+	p := c.params.P
+
+	// x3 = λ² - x1 - x2, y3 = λ(x1 - x3) - y1
+	x3 := new(big.Int).Mul(lambda, lambda)
+	x3.Sub(x3, x1)
+	x3.Sub(x3, x2)
+	x3.Mod(x3, p)
+
+	y3 := new(big.Int).Sub(x1, x3)
+	y3.Mul(y3, lambda)
+	y3.Sub(y3, y1)
+	y3.Mod(y3, p)
+
+	return x3, y3
+}
+
+func (c *secp256k1Curve) ScalarMult(x1, y1 *big.Int, k []byte) (*big.Int, *big.Int) {
+	x, y := new(big.Int), new(big.Int)
+
+	for _, b := range k {
+		for i := 7; i >= 0; i-- {
+			x, y = c.Double(x, y)
+			if b>>uint(i)&1 == 1 {
+				x, y = c.Add(x, y, x1, y1)
+			}
+		}
+	}
+
+	return x, y
+}
+
+func (c *secp256k1Curve) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return c.ScalarMult(c.params.Gx, c.params.Gy, k)
+}
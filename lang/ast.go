@@ -0,0 +1,98 @@
+package lang
+
+import "math/big"
+
+// Stmt is a statement of a program.
+type Stmt interface {
+	stmtPos() Pos
+}
+
+// Expr is an expression evaluating to a single value.
+type Expr interface {
+	exprPos() Pos
+}
+
+// Program is the root of the syntax tree.
+type Program struct {
+	Stmts []Stmt
+}
+
+// LetStmt declares a variable in the current block.
+type LetStmt struct {
+	Pos   Pos
+	Name  string
+	Value Expr
+}
+
+// AssignStmt assigns a declared variable.
+type AssignStmt struct {
+	Pos   Pos
+	Name  string
+	Value Expr
+}
+
+type IfStmt struct {
+	Pos  Pos
+	Cond Expr
+	Then []Stmt
+	Else []Stmt
+}
+
+type WhileStmt struct {
+	Pos  Pos
+	Cond Expr
+	Body []Stmt
+}
+
+// CallStmt is a call of a builtin without result.
+type CallStmt struct {
+	Call *CallExpr
+}
+
+type IntLit struct {
+	Pos   Pos
+	Value *big.Int
+}
+
+type StringLit struct {
+	Pos   Pos
+	Value string
+}
+
+type Ident struct {
+	Pos  Pos
+	Name string
+}
+
+type UnaryExpr struct {
+	Pos Pos
+	Op  string
+	X   Expr
+}
+
+type BinaryExpr struct {
+	Pos Pos
+	Op  string
+	X   Expr
+	Y   Expr
+}
+
+// CallExpr is a call of a builtin.
+type CallExpr struct {
+	Pos  Pos
+	Name string
+	Args []Expr
+}
+
+func (s *LetStmt) stmtPos() Pos    { return s.Pos }
+func (s *AssignStmt) stmtPos() Pos { return s.Pos }
+func (s *IfStmt) stmtPos() Pos     { return s.Pos }
+func (s *WhileStmt) stmtPos() Pos  { return s.Pos }
+func (s *CallStmt) stmtPos() Pos   { return s.Call.Pos }
+
+func (e *IntLit) exprPos() Pos     { return e.Pos }
+func (e *StringLit) exprPos() Pos  { return e.Pos }
+func (e *Ident) exprPos() Pos      { return e.Pos }
+func (e *UnaryExpr) exprPos() Pos  { return e.Pos }
+func (e *BinaryExpr) exprPos() Pos { return e.Pos }
+func (e *CallExpr) exprPos() Pos   { return e.Pos }
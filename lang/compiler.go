@@ -0,0 +1,445 @@
+package lang
+
+import "github.com/anthoai97/blockchain-from-scratch/core"
+
+const (
+	// maxStringLen is the number of bytes a single PACK can take from
+	// operands of one byte.
+	maxStringLen = 0xff
+	// maxCodeSize is the largest position a long label reference reaches.
+	maxCodeSize = 0xffff
+	// maxVariables is the number of memory slots of the VM.
+	maxVariables = 256
+)
+
+type builtin struct {
+	minArgs  int
+	maxArgs  int
+	hasValue bool
+}
+
+var builtins = map[string]builtin{
+	"get":      {minArgs: 1, maxArgs: 1, hasValue: true},
+	"calldata": {minArgs: 0, maxArgs: 0, hasValue: true},
+	"set":      {minArgs: 2, maxArgs: 2},
+	"delete":   {minArgs: 1, maxArgs: 1},
+	"log":      {minArgs: 1, maxArgs: 5},
+}
+
+type fixup struct {
+	pos   int
+	label int
+}
+
+type compiler struct {
+	code   []byte
+	labels []int
+	fixups []fixup
+	// longLabels makes label references wide enough for code larger than
+	// what a short reference reaches.
+	longLabels bool
+
+	scopes   []map[string]int
+	numSlots int
+}
+
+// Compile returns the bytecode of the source for the latest VM version. The
+// returned error is an *Error for invalid programs.
+func Compile(src string) ([]byte, error) {
+	prog, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := compile(prog, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(code) > maxSum {
+		return compile(prog, true)
+	}
+
+	return code, nil
+}
+
+func compile(prog *Program, longLabels bool) ([]byte, error) {
+	c := &compiler{longLabels: longLabels}
+
+	c.pushScope()
+	for _, stmt := range prog.Stmts {
+		if err := c.stmt(stmt); err != nil {
+			return nil, err
+		}
+	}
+	c.popScope()
+
+	// A jump to the end of the code needs an instruction to land on
+	for _, pos := range c.labels {
+		if pos == len(c.code) {
+			c.push(0)
+			break
+		}
+	}
+
+	if len(c.code) > maxCodeSize {
+		return nil, errorf(Pos{Line: 1, Col: 1}, "program of %d bytes is too large", len(c.code))
+	}
+
+	for _, f := range c.fixups {
+		pos := c.labels[f.label]
+		if c.longLabels {
+			c.patchSum(f.pos, pos>>8)
+			c.patchSum(f.pos+longLabelLow, pos&0xff)
+		} else {
+			c.patchSum(f.pos, pos)
+		}
+	}
+
+	return c.code, nil
+}
+
+// isOperandInstr tells if the byte reads as an instruction taking an
+// operand. The VM pairs such a byte with the byte before it, so it can only
+// be an operand itself if that byte is an operand too.
+func isOperandInstr(b byte) bool {
+	return core.Instruction(b).HasOperand()
+}
+
+func (c *compiler) emit(instrs ...core.Instruction) {
+	for _, instr := range instrs {
+		c.code = append(c.code, byte(instr))
+	}
+}
+
+// push pushes the byte, building it from two pushes when the byte would be
+// mistaken for an instruction.
+func (c *compiler) push(b byte) {
+	c.operand(core.InstrPush, b)
+}
+
+func (c *compiler) operand(instr core.Instruction, b byte) {
+	if isOperandInstr(b) {
+		c.code = append(c.code, b-1, byte(core.InstrPush), 1, byte(core.InstrPush), byte(core.InstrAdd))
+		return
+	}
+	c.code = append(c.code, b, byte(instr))
+}
+
+// pushBytes pushes the big endian number of the bytes, one byte at a time.
+func (c *compiler) pushBytes(b []byte) {
+	c.push(b[0])
+	for _, x := range b[1:] {
+		c.shiftByte()
+		c.push(x)
+		c.emit(core.InstrAdd)
+	}
+}
+
+// shiftByte multiplies the top of the stack by 256.
+func (c *compiler) shiftByte() {
+	c.push(16)
+	c.push(16)
+	c.emit(core.InstrMul, core.InstrMul)
+}
+
+func (c *compiler) newLabel() int {
+	c.labels = append(c.labels, -1)
+	return len(c.labels) - 1
+}
+
+func (c *compiler) setLabel(label int) {
+	c.labels[label] = len(c.code)
+}
+
+const (
+	// sumSize is the size of a number pushed as the sum of two operands.
+	sumSize = 5
+	// maxSum is the largest number pushed as the sum of two operands.
+	maxSum = 2 * 0xff
+	// longLabelLow is the offset of the low byte of a long label reference.
+	longLabelLow = sumSize + 6
+)
+
+// pushLabel pushes the position of the label, which is patched once all
+// labels are known. The operands are not known yet, so every byte of the
+// position is pushed as a sum of two operands that can always be chosen to
+// not read as instructions.
+func (c *compiler) pushLabel(label int) {
+	c.fixups = append(c.fixups, fixup{pos: len(c.code), label: label})
+	if c.longLabels {
+		c.pushSum()
+		c.shiftByte()
+		c.pushSum()
+		c.emit(core.InstrAdd)
+	} else {
+		c.pushSum()
+	}
+}
+
+func (c *compiler) pushSum() {
+	c.code = append(c.code, 0, byte(core.InstrPush), 0, byte(core.InstrPush), byte(core.InstrAdd))
+}
+
+// patchSum sets the operands of the sum at pos to add up to x.
+func (c *compiler) patchSum(pos int, x int) {
+	a := x
+	if a > 0xff {
+		a = 0xff
+	}
+	for isOperandInstr(byte(a)) || isOperandInstr(byte(x-a)) {
+		a--
+	}
+	c.code[pos] = byte(a)
+	c.code[pos+2] = byte(x - a)
+}
+
+func (c *compiler) pushScope() {
+	c.scopes = append(c.scopes, map[string]int{})
+}
+
+// popScope frees the slots of the variables of the innermost scope.
+func (c *compiler) popScope() {
+	c.numSlots -= len(c.scopes[len(c.scopes)-1])
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+func (c *compiler) lookup(name string) (int, bool) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if slot, ok := c.scopes[i][name]; ok {
+			return slot, true
+		}
+	}
+	return 0, false
+}
+
+func (c *compiler) block(stmts []Stmt) error {
+	c.pushScope()
+	defer c.popScope()
+
+	for _, stmt := range stmts {
+		if err := c.stmt(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *compiler) stmt(stmt Stmt) error {
+	switch s := stmt.(type) {
+	case *LetStmt:
+		scope := c.scopes[len(c.scopes)-1]
+		if _, ok := scope[s.Name]; ok {
+			return errorf(s.Pos, "%s redeclared in this block", s.Name)
+		}
+		if _, ok := builtins[s.Name]; ok {
+			return errorf(s.Pos, "%s is a builtin", s.Name)
+		}
+		if c.numSlots == maxVariables {
+			return errorf(s.Pos, "too many variables")
+		}
+
+		// The variable is not in scope of its own value
+		if err := c.expr(s.Value); err != nil {
+			return err
+		}
+		slot := c.numSlots
+		scope[s.Name] = slot
+		c.numSlots++
+
+		c.push(byte(slot))
+		c.emit(core.InstrMStore)
+
+	case *AssignStmt:
+		slot, ok := c.lookup(s.Name)
+		if !ok {
+			return errorf(s.Pos, "undefined variable %s", s.Name)
+		}
+		if err := c.expr(s.Value); err != nil {
+			return err
+		}
+		c.push(byte(slot))
+		c.emit(core.InstrMStore)
+
+	case *IfStmt:
+		elseLabel, endLabel := c.newLabel(), c.newLabel()
+
+		if err := c.expr(s.Cond); err != nil {
+			return err
+		}
+		c.emit(core.InstrNot)
+		c.pushLabel(elseLabel)
+		c.emit(core.InstrJumpI)
+
+		if err := c.block(s.Then); err != nil {
+			return err
+		}
+		c.pushLabel(endLabel)
+		c.emit(core.InstrJump)
+
+		c.setLabel(elseLabel)
+		if err := c.block(s.Else); err != nil {
+			return err
+		}
+		c.setLabel(endLabel)
+
+	case *WhileStmt:
+		startLabel, endLabel := c.newLabel(), c.newLabel()
+
+		c.setLabel(startLabel)
+		if err := c.expr(s.Cond); err != nil {
+			return err
+		}
+		c.emit(core.InstrNot)
+		c.pushLabel(endLabel)
+		c.emit(core.InstrJumpI)
+
+		if err := c.block(s.Body); err != nil {
+			return err
+		}
+		c.pushLabel(startLabel)
+		c.emit(core.InstrJump)
+
+		c.setLabel(endLabel)
+
+	case *CallStmt:
+		if builtins[s.Call.Name].hasValue {
+			return errorf(s.Call.Pos, "result of %s is not used", s.Call.Name)
+		}
+		return c.call(s.Call)
+	}
+
+	return nil
+}
+
+func (c *compiler) expr(expr Expr) error {
+	switch e := expr.(type) {
+	case *IntLit:
+		if e.Value.Sign() == 0 {
+			c.push(0)
+		} else {
+			c.pushBytes(e.Value.Bytes())
+		}
+
+	case *StringLit:
+		if len(e.Value) > maxStringLen {
+			return errorf(e.Pos, "string of %d bytes is longer than %d bytes", len(e.Value), maxStringLen)
+		}
+		for i := 0; i < len(e.Value); i++ {
+			c.operand(core.InstrByte, e.Value[i])
+		}
+		c.push(byte(len(e.Value)))
+		c.emit(core.InstrPack)
+
+	case *Ident:
+		slot, ok := c.lookup(e.Name)
+		if !ok {
+			return errorf(e.Pos, "undefined variable %s", e.Name)
+		}
+		c.push(byte(slot))
+		c.emit(core.InstrMLoad)
+
+	case *UnaryExpr:
+		if e.Op == "-" {
+			c.push(0)
+		}
+		if err := c.expr(e.X); err != nil {
+			return err
+		}
+		if e.Op == "-" {
+			c.emit(core.InstrSub)
+		} else {
+			c.emit(core.InstrNot)
+		}
+
+	case *BinaryExpr:
+		return c.binary(e)
+
+	case *CallExpr:
+		b, ok := builtins[e.Name]
+		if ok && !b.hasValue {
+			return errorf(e.Pos, "%s has no value", e.Name)
+		}
+		return c.call(e)
+	}
+
+	return nil
+}
+
+var binaryInstrs = map[string][]core.Instruction{
+	"+":  {core.InstrAdd},
+	"-":  {core.InstrSub},
+	"*":  {core.InstrMul},
+	"/":  {core.InstrDiv},
+	"%":  {core.InstrMod},
+	"==": {core.InstrEq},
+	"!=": {core.InstrEq, core.InstrNot},
+	"<":  {core.InstrLt},
+	">":  {core.InstrGt},
+	"<=": {core.InstrGt, core.InstrNot},
+	">=": {core.InstrLt, core.InstrNot},
+}
+
+func (c *compiler) binary(e *BinaryExpr) error {
+	// && and || normalize both operands to 0 or 1 before the bitwise op
+	logical := e.Op == "&&" || e.Op == "||"
+
+	if err := c.expr(e.X); err != nil {
+		return err
+	}
+	if logical {
+		c.emit(core.InstrNot, core.InstrNot)
+	}
+	if err := c.expr(e.Y); err != nil {
+		return err
+	}
+
+	switch e.Op {
+	case "&&":
+		c.emit(core.InstrNot, core.InstrNot, core.InstrAnd)
+	case "||":
+		c.emit(core.InstrNot, core.InstrNot, core.InstrOr)
+	default:
+		c.emit(binaryInstrs[e.Op]...)
+	}
+
+	return nil
+}
+
+func (c *compiler) call(e *CallExpr) error {
+	b, ok := builtins[e.Name]
+	if !ok {
+		return errorf(e.Pos, "undefined function %s", e.Name)
+	}
+	if len(e.Args) < b.minArgs || len(e.Args) > b.maxArgs {
+		return errorf(e.Pos, "wrong number of arguments for %s: %d", e.Name, len(e.Args))
+	}
+
+	// set takes the key first but STORE pops the key before the value
+	args := e.Args
+	if e.Name == "set" {
+		args = []Expr{e.Args[1], e.Args[0]}
+	}
+	for _, arg := range args {
+		if err := c.expr(arg); err != nil {
+			return err
+		}
+	}
+
+	switch e.Name {
+	case "get":
+		c.emit(core.InstrGet)
+	case "calldata":
+		c.emit(core.InstrCallData)
+	case "set":
+		c.emit(core.InstrStore)
+	case "delete":
+		c.emit(core.InstrDelete)
+	case "log":
+		c.push(byte(len(e.Args) - 1))
+		c.emit(core.InstrLog)
+	}
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package lang
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// run compiles and runs the source against the state.
+func run(t *testing.T, src string, state *core.State) *core.VM {
+	code, err := Compile(src)
+	assert.Nil(t, err)
+
+	vm := core.NewVM(code, state, core.DefaultTxGasLimit)
+	assert.Nil(t, vm.Run())
+
+	return vm
+}
+
+func word(x *big.Int) []byte {
+	return core.NewWordValue(x).Serialize()
+}
+
+// storedWord returns the decimal word stored under the key.
+func storedWord(t *testing.T, state *core.State, key string) string {
+	value, err := state.Get([]byte(key))
+	assert.Nil(t, err)
+	return new(big.Int).SetBytes(value).String()
+}
+
+func TestCompileCounter(t *testing.T) {
+	src := `
+		// count the runs
+		set("count", get("count") + 1);
+	`
+
+	state := core.NewState()
+	for i := 0; i < 3; i++ {
+		run(t, src, state)
+	}
+
+	value, err := state.Get([]byte("count"))
+	assert.Nil(t, err)
+	assert.Equal(t, word(big.NewInt(3)), value)
+}
+
+func TestCompileWhile(t *testing.T) {
+	src := `
+		let i = 0;
+		let sum = 0;
+		while i < 10 {
+			i = i + 1;
+			sum = sum + i;
+		}
+		set("sum", sum);
+	`
+
+	state := core.NewState()
+	run(t, src, state)
+	assert.Equal(t, "55", storedWord(t, state, "sum"))
+}
+
+func TestCompileIfElse(t *testing.T) {
+	src := `
+		let x = get("x");
+		if x > 500 {
+			set("r", 1);
+		} else if x > 100 {
+			set("r", 2);
+		} else {
+			set("r", 3);
+		}
+	`
+
+	for x, r := range map[int64]int64{1000: 1, 200: 2, 0: 3} {
+		state := core.NewState()
+		assert.Nil(t, state.Put([]byte("x"), word(big.NewInt(x))))
+
+		run(t, src, state)
+		assert.Equal(t, big.NewInt(r).String(), storedWord(t, state, "r"), x)
+	}
+}
+
+func TestCompileExpressions(t *testing.T) {
+	maxWord := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+	tests := map[string]*big.Int{
+		"1 + 2 * 3":      big.NewInt(7),
+		"(1 + 2) * 3":    big.NewInt(9),
+		"10 - 4 - 3":     big.NewInt(3),
+		"10 / 3":         big.NewInt(3),
+		"10 % 3":         big.NewInt(1),
+		"-1":             maxWord,
+		"0 - 1 + 2":      big.NewInt(1),
+		"2 == 2":         big.NewInt(1),
+		"2 != 2":         big.NewInt(0),
+		"1 < 2":          big.NewInt(1),
+		"1 > 2":          big.NewInt(0),
+		"2 <= 2":         big.NewInt(1),
+		"3 >= 4":         big.NewInt(0),
+		"!5":             big.NewInt(0),
+		"!0":             big.NewInt(1),
+		"2 && 3":         big.NewInt(1),
+		"2 && 0":         big.NewInt(0),
+		"0 || 4":         big.NewInt(1),
+		"1 < 2 && 3 < 4": big.NewInt(1),
+		"10 + 12":        big.NewInt(22),
+		"70000":          big.NewInt(70000),
+		"0x10000 + 1":    big.NewInt(0x10001),
+		"get(\"none\")":  big.NewInt(0),
+	}
+
+	for expr, expected := range tests {
+		state := core.NewState()
+		run(t, "set(\"r\", "+expr+");", state)
+		assert.Equal(t, expected.String(), storedWord(t, state, "r"), expr)
+	}
+}
+
+func TestCompileStrings(t *testing.T) {
+	state := core.NewState()
+	run(t, `
+		let key = "k";
+		set(key, "value");
+		set("empty", "");
+		set("escaped", "a\"b\\");
+	`+"set(\"feed\", \"\f\");", state)
+
+	value, err := state.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	value, err = state.Get([]byte("escaped"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a\"b\\"), value)
+
+	value, err = state.Get([]byte("feed"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("\f"), value)
+
+	value, err = state.Get([]byte("empty"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{}, value)
+}
+
+func TestCompileScopes(t *testing.T) {
+	state := core.NewState()
+	run(t, `
+		let x = 1;
+		if 1 {
+			let x = 2;
+			set("inner", x);
+			let y = 3;
+		}
+		if 1 {
+			// reuses the slot of y
+			let z = 0;
+			set("z", z);
+		}
+		set("outer", x);
+	`, state)
+
+	assert.Equal(t, "2", storedWord(t, state, "inner"))
+	assert.Equal(t, "1", storedWord(t, state, "outer"))
+	assert.Equal(t, "0", storedWord(t, state, "z"))
+}
+
+func TestCompileDeleteAndLog(t *testing.T) {
+	state := core.NewState()
+	assert.Nil(t, state.Put([]byte("old"), []byte{1}))
+
+	vm := run(t, `
+		delete("old");
+		log("data", 1, 2);
+	`, state)
+
+	_, err := state.Get([]byte("old"))
+	assert.NotNil(t, err)
+
+	logs := vm.Logs()
+	assert.Equal(t, 1, len(logs))
+	assert.Equal(t, []byte("data"), logs[0].Data)
+	assert.Equal(t, 2, len(logs[0].Topics))
+	assert.Equal(t, byte(2), logs[0].Topics[1][31])
+}
+
+func TestCompileLargeProgram(t *testing.T) {
+	// The body pushes the loop past what a single byte label reaches
+	body := strings.Repeat("sum = sum + 1000000;\n", 20)
+	src := `
+		let i = 0;
+		let sum = 0;
+		while i < 3 {
+			i = i + 1;
+			` + body + `
+		}
+		if sum == 60000000 {
+			set("sum", sum);
+		}
+	`
+
+	code, err := Compile(src)
+	assert.Nil(t, err)
+	assert.Greater(t, len(code), maxSum)
+
+	state := core.NewState()
+	run(t, src, state)
+	assert.Equal(t, "60000000", storedWord(t, state, "sum"))
+}
+
+func TestCompileJumpTargets(t *testing.T) {
+	// Moves the loop over every position a short label reaches, including
+	// the ones whose bytes read as instructions
+	for n := 0; n < 90; n++ {
+		src := "let i = 0;" + strings.Repeat("i = i;", n) + `
+			while i < 3 {
+				i = i + 1;
+			}
+			set("i", i);
+		`
+
+		state := core.NewState()
+		run(t, src, state)
+		assert.Equal(t, "3", storedWord(t, state, "i"), n)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := map[string]string{
+		"let x = 1":              "1:10: expected \";\", found end of file",
+		"let x = 1;\nlet x = 2;": "2:1: x redeclared in this block",
+		"x = 1;":                 "1:1: undefined variable x",
+		"set(\"a\", y);":         "1:10: undefined variable y",
+		"foo(1);":                "1:1: undefined function foo",
+		"set(1);":                "1:1: wrong number of arguments for set: 1",
+		"log(1, 2, 3, 4, 5, 6);": "1:1: wrong number of arguments for log: 6",
+		"get(1);":                "1:1: result of get is not used",
+		"let x = set(1, 2);":     "1:9: set has no value",
+		"let get = 1;":           "1:1: get is a builtin",
+		"if 1 {\n  set(1, 2);\n": "3:1: expected \"}\", found end of file",
+		"let s = \"abc":          "1:9: unterminated string",
+		"let x = 1 $ 2;":         "1:11: unexpected character '$'",
+		"let x = 12ab;":          "1:9: invalid integer \"12ab\"",
+		"let x = (1 + 2;":        "1:15: expected \")\", found \";\"",
+		"let x = ;":              "1:9: expected expression, found \";\"",
+		"1 + 2;":                 "1:1: expected statement, found \"1\"",
+		"x;":                     "1:2: expected \"=\" or \"(\", found \";\"",
+		"let s = \"" + strings.Repeat("a", 256) + "\";": "1:9: string of 256 bytes is longer than 255 bytes",
+		"let x = 0x1" + strings.Repeat("0", 64) + ";":   "1:9: integer 0x1" + strings.Repeat("0", 64) + " overflows 256 bits",
+	}
+
+	for src, expected := range tests {
+		_, err := Compile(src)
+		assert.Equal(t, expected, err.Error(), src)
+		assert.IsType(t, &Error{}, err)
+	}
+}
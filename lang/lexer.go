@@ -0,0 +1,231 @@
+// Package lang compiles a small contract language to VM bytecode.
+//
+//	let n = calldata();            // variables hold words or bytes
+//	let total = get("total") + n;  // storage is read with get
+//	while n > 0 {
+//	    n = n - 1;
+//	}
+//	if total > 100 {
+//	    set("total", 0);
+//	} else {
+//	    set("total", total);
+//	}
+//	delete("old");
+//	log(total, "added");           // data then up to 4 topics
+//
+// Integers are unsigned 256 bit words, comparisons and logical operators
+// evaluate to 0 or 1, and && and || always evaluate both operands. Strings
+// are byte slices of at most 255 bytes.
+package lang
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// Error is a compile error at a position of the source.
+type Error struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// Pos is a position in the source, lines and columns start at 1.
+type Pos struct {
+	Line int
+	Col  int
+}
+
+func errorf(pos Pos, format string, args ...any) *Error {
+	return &Error{
+		Line: pos.Line,
+		Col:  pos.Col,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+}
+
+type TokenType int
+
+const (
+	TokenEOF TokenType = iota
+	TokenIdent
+	TokenInt
+	TokenString
+	TokenKeyword
+	TokenOperator
+)
+
+var keywords = map[string]bool{
+	"let":   true,
+	"if":    true,
+	"else":  true,
+	"while": true,
+}
+
+// operators are matched longest first.
+var operators = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "!", "=",
+	"(", ")", "{", "}", ",", ";",
+}
+
+type Token struct {
+	Type TokenType
+	Text string
+	Pos  Pos
+}
+
+func (t Token) String() string {
+	if t.Type == TokenEOF {
+		return "end of file"
+	}
+	return fmt.Sprintf("%q", t.Text)
+}
+
+type lexer struct {
+	src  []rune
+	i    int
+	line int
+	col  int
+}
+
+// Lex splits the source into tokens, the last token is TokenEOF.
+func Lex(src string) ([]Token, error) {
+	l := &lexer{
+		src:  []rune(src),
+		line: 1,
+		col:  1,
+	}
+
+	tokens := []Token{}
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		if tok.Type == TokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) pos() Pos {
+	return Pos{Line: l.line, Col: l.col}
+}
+
+func (l *lexer) peek(offset int) rune {
+	if l.i+offset >= len(l.src) {
+		return 0
+	}
+	return l.src[l.i+offset]
+}
+
+func (l *lexer) advance() rune {
+	r := l.src[l.i]
+	l.i++
+	if r == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return r
+}
+
+func (l *lexer) skipSpaceAndComments() {
+	for l.i < len(l.src) {
+		switch r := l.peek(0); {
+		case unicode.IsSpace(r):
+			l.advance()
+		case r == '/' && l.peek(1) == '/':
+			for l.i < len(l.src) && l.peek(0) != '\n' {
+				l.advance()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) next() (Token, error) {
+	l.skipSpaceAndComments()
+
+	pos := l.pos()
+	if l.i >= len(l.src) {
+		return Token{Type: TokenEOF, Pos: pos}, nil
+	}
+
+	r := l.peek(0)
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		start := l.i
+		for l.i < len(l.src) && (l.peek(0) == '_' || unicode.IsLetter(l.peek(0)) || unicode.IsDigit(l.peek(0))) {
+			l.advance()
+		}
+		text := string(l.src[start:l.i])
+		if keywords[text] {
+			return Token{Type: TokenKeyword, Text: text, Pos: pos}, nil
+		}
+		return Token{Type: TokenIdent, Text: text, Pos: pos}, nil
+
+	case unicode.IsDigit(r):
+		start := l.i
+		for l.i < len(l.src) && (unicode.IsDigit(l.peek(0)) || unicode.IsLetter(l.peek(0))) {
+			l.advance()
+		}
+		return Token{Type: TokenInt, Text: string(l.src[start:l.i]), Pos: pos}, nil
+
+	case r == '"':
+		return l.string(pos)
+	}
+
+	for _, op := range operators {
+		if l.hasPrefix(op) {
+			for range op {
+				l.advance()
+			}
+			return Token{Type: TokenOperator, Text: op, Pos: pos}, nil
+		}
+	}
+
+	return Token{}, errorf(pos, "unexpected character %q", r)
+}
+
+func (l *lexer) hasPrefix(s string) bool {
+	for i, r := range []rune(s) {
+		if l.peek(i) != r {
+			return false
+		}
+	}
+	return true
+}
+
+// string reads a string literal, the only escapes are \" and \\.
+func (l *lexer) string(pos Pos) (Token, error) {
+	l.advance()
+
+	var text []rune
+	for {
+		if l.i >= len(l.src) || l.peek(0) == '\n' {
+			return Token{}, errorf(pos, "unterminated string")
+		}
+
+		r := l.advance()
+		switch r {
+		case '"':
+			return Token{Type: TokenString, Text: string(text), Pos: pos}, nil
+		case '\\':
+			if l.i >= len(l.src) || (l.peek(0) != '"' && l.peek(0) != '\\') {
+				return Token{}, errorf(l.pos(), "invalid escape in string")
+			}
+			text = append(text, l.advance())
+		default:
+			text = append(text, r)
+		}
+	}
+}
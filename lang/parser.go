@@ -0,0 +1,296 @@
+package lang
+
+import (
+	"math/big"
+)
+
+// binaryPrecedence orders the binary operators, higher binds tighter.
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, ">": 4, "<=": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+type parser struct {
+	tokens []Token
+	i      int
+}
+
+// Parse returns the syntax tree of the source.
+func Parse(src string) (*Program, error) {
+	tokens, err := Lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	prog := &Program{}
+	for p.peek().Type != TokenEOF {
+		stmt, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		prog.Stmts = append(prog.Stmts, stmt)
+	}
+
+	return prog, nil
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() Token {
+	tok := p.tokens[p.i]
+	if tok.Type != TokenEOF {
+		p.i++
+	}
+	return tok
+}
+
+func (p *parser) is(typ TokenType, text string) bool {
+	tok := p.peek()
+	return tok.Type == typ && tok.Text == text
+}
+
+func (p *parser) expect(typ TokenType, text string) (Token, error) {
+	tok := p.next()
+	if tok.Type != typ || tok.Text != text {
+		return tok, errorf(tok.Pos, "expected %q, found %s", text, tok)
+	}
+	return tok, nil
+}
+
+func (p *parser) expectIdent() (Token, error) {
+	tok := p.next()
+	if tok.Type != TokenIdent {
+		return tok, errorf(tok.Pos, "expected identifier, found %s", tok)
+	}
+	return tok, nil
+}
+
+func (p *parser) parseStmt() (Stmt, error) {
+	tok := p.peek()
+
+	switch {
+	case tok.Type == TokenKeyword && tok.Text == "let":
+		p.next()
+		name, err := p.expectIdent()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(TokenOperator, "="); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(TokenOperator, ";"); err != nil {
+			return nil, err
+		}
+		return &LetStmt{Pos: tok.Pos, Name: name.Text, Value: value}, nil
+
+	case tok.Type == TokenKeyword && tok.Text == "if":
+		return p.parseIf()
+
+	case tok.Type == TokenKeyword && tok.Text == "while":
+		p.next()
+		cond, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		return &WhileStmt{Pos: tok.Pos, Cond: cond, Body: body}, nil
+
+	case tok.Type == TokenIdent:
+		p.next()
+		if p.is(TokenOperator, "=") {
+			p.next()
+			value, err := p.parseExpr(1)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(TokenOperator, ";"); err != nil {
+				return nil, err
+			}
+			return &AssignStmt{Pos: tok.Pos, Name: tok.Text, Value: value}, nil
+		}
+
+		if !p.is(TokenOperator, "(") {
+			return nil, errorf(p.peek().Pos, "expected \"=\" or \"(\", found %s", p.peek())
+		}
+		call, err := p.parseCall(tok)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(TokenOperator, ";"); err != nil {
+			return nil, err
+		}
+		return &CallStmt{Call: call}, nil
+	}
+
+	return nil, errorf(tok.Pos, "expected statement, found %s", tok)
+}
+
+func (p *parser) parseIf() (Stmt, error) {
+	tok := p.next()
+
+	cond, err := p.parseExpr(1)
+	if err != nil {
+		return nil, err
+	}
+	then, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &IfStmt{Pos: tok.Pos, Cond: cond, Then: then}
+	if !p.is(TokenKeyword, "else") {
+		return stmt, nil
+	}
+	p.next()
+
+	// else if chains nest in the else block
+	if p.is(TokenKeyword, "if") {
+		elseIf, err := p.parseIf()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Else = []Stmt{elseIf}
+		return stmt, nil
+	}
+
+	stmt.Else, err = p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+func (p *parser) parseBlock() ([]Stmt, error) {
+	if _, err := p.expect(TokenOperator, "{"); err != nil {
+		return nil, err
+	}
+
+	stmts := []Stmt{}
+	for !p.is(TokenOperator, "}") {
+		if p.peek().Type == TokenEOF {
+			return nil, errorf(p.peek().Pos, "expected \"}\", found %s", p.peek())
+		}
+		stmt, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	p.next()
+
+	return stmts, nil
+}
+
+// parseExpr parses binary expressions of at least the given precedence.
+func (p *parser) parseExpr(precedence int) (Expr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		prec, ok := binaryPrecedence[tok.Text]
+		if tok.Type != TokenOperator || !ok || prec < precedence {
+			return x, nil
+		}
+		p.next()
+
+		y, err := p.parseExpr(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &BinaryExpr{Pos: tok.Pos, Op: tok.Text, X: x, Y: y}
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.is(TokenOperator, "!") || p.is(TokenOperator, "-") {
+		tok := p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Pos: tok.Pos, Op: tok.Text, X: x}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.next()
+
+	switch tok.Type {
+	case TokenInt:
+		v, ok := new(big.Int).SetString(tok.Text, 0)
+		if !ok || v.Sign() < 0 {
+			return nil, errorf(tok.Pos, "invalid integer %q", tok.Text)
+		}
+		if v.BitLen() > 256 {
+			return nil, errorf(tok.Pos, "integer %s overflows 256 bits", tok.Text)
+		}
+		return &IntLit{Pos: tok.Pos, Value: v}, nil
+
+	case TokenString:
+		return &StringLit{Pos: tok.Pos, Value: tok.Text}, nil
+
+	case TokenIdent:
+		if p.is(TokenOperator, "(") {
+			return p.parseCall(tok)
+		}
+		return &Ident{Pos: tok.Pos, Name: tok.Text}, nil
+
+	case TokenOperator:
+		if tok.Text == "(" {
+			x, err := p.parseExpr(1)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(TokenOperator, ")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+
+	return nil, errorf(tok.Pos, "expected expression, found %s", tok)
+}
+
+func (p *parser) parseCall(name Token) (*CallExpr, error) {
+	if _, err := p.expect(TokenOperator, "("); err != nil {
+		return nil, err
+	}
+
+	call := &CallExpr{Pos: name.Pos, Name: name.Text}
+	for !p.is(TokenOperator, ")") {
+		if len(call.Args) > 0 {
+			if _, err := p.expect(TokenOperator, ","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+	}
+	p.next()
+
+	return call, nil
+}
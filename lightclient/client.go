@@ -0,0 +1,174 @@
+package lightclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/anthoai97/blockchain-from-scratch/core"
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// ErrNotProven is returned by HasTransaction when the provider claims the
+// transaction is not in the block, a claim the client can not verify.
+var ErrNotProven = errors.New("transaction inclusion not proven")
+
+// Provider is a source of headers and proofs, usually a full node. GetHeaders
+// returns an empty slice when it has no header past from.
+type Provider interface {
+	GetHeaders(from, to uint32) ([]*core.SignedHeader, error)
+	GetTxProof(height uint32, hash types.Hash) (*core.TxProof, error)
+	GetStateProof(height uint32, key []byte) (*core.StateProof, error)
+}
+
+// Client follows the chain by only syncing headers. Every header is checked
+// against its validator signature and the hash of the previous header, the
+// content of the blocks is then verified with merkle proofs.
+type Client struct {
+	lock       sync.RWMutex
+	provider   Provider
+	validators map[types.Address]bool
+	headers    []*core.Header
+}
+
+// NewClient returns a light client starting from a trusted genesis header,
+// only headers signed by one of the trusted validators are accepted.
+func NewClient(genesis *core.Header, validators []crypto.PublicKey, p Provider) (*Client, error) {
+	if len(validators) == 0 {
+		return nil, fmt.Errorf("light client needs at least one trusted validator")
+	}
+
+	c := &Client{
+		provider:   p,
+		validators: make(map[types.Address]bool),
+		headers:    []*core.Header{genesis},
+	}
+
+	for _, v := range validators {
+		c.validators[v.Address()] = true
+	}
+
+	return c, nil
+}
+
+func (c *Client) Height() uint32 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return uint32(len(c.headers) - 1)
+}
+
+func (c *Client) GetHeader(height uint32) (*core.Header, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if int(height) >= len(c.headers) {
+		return nil, fmt.Errorf("given height (%d) too hight", height)
+	}
+
+	return c.headers[height], nil
+}
+
+// Sync fetches headers from the provider until it has no newer ones.
+func (c *Client) Sync() error {
+	for {
+		from := c.Height() + 1
+		headers, err := c.provider.GetHeaders(from, ^uint32(0))
+		if err != nil {
+			return err
+		}
+
+		if len(headers) == 0 {
+			return nil
+		}
+
+		for _, h := range headers {
+			if err := c.AddHeader(h); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// AddHeader verifies the header and appends it to the chain of headers.
+func (c *Client) AddHeader(h *core.SignedHeader) error {
+	if err := h.Verify(); err != nil {
+		return err
+	}
+
+	if !c.validators[h.Validator.Address()] {
+		return fmt.Errorf("header (%d) is signed by an unknown validator (%s)", h.Height, h.Validator.Address())
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	prevHeader := c.headers[len(c.headers)-1]
+	if h.Height != prevHeader.Height+1 {
+		return fmt.Errorf("header with height (%d) does not follow current height (%d)", h.Height, prevHeader.Height)
+	}
+
+	if hash := (core.BlockHasher{}).Hash(prevHeader); hash != h.PrevBlockHash {
+		return fmt.Errorf("the hash of previous header (%s) is invalid", h.PrevBlockHash)
+	}
+
+	c.headers = append(c.headers, h.Header)
+
+	return nil
+}
+
+// HasTransaction tells if the transaction with the given hash is included in
+// the block at the given height, true is only returned with a verified proof.
+// Only inclusion can be proven, when the provider answers core.ErrTxNotFound
+// ErrNotProven is returned since nothing backs the absence, any other error
+// of the provider is returned as is.
+func (c *Client) HasTransaction(height uint32, hash types.Hash) (bool, error) {
+	header, err := c.GetHeader(height)
+	if err != nil {
+		return false, err
+	}
+
+	proof, err := c.provider.GetTxProof(height, hash)
+	if errors.Is(err, core.ErrTxNotFound) {
+		return false, fmt.Errorf("transaction (%s) at height (%d): %w", hash, height, ErrNotProven)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if proof.TxHash != hash {
+		return false, fmt.Errorf("provider returned a proof for transaction (%s)", proof.TxHash)
+	}
+
+	if !proof.Verify(header.DataHash) {
+		return false, fmt.Errorf("invalid proof for transaction (%s) at height (%d)", hash, height)
+	}
+
+	return true, nil
+}
+
+// GetState returns the value of the key in the state after executing the
+// block at the given height.
+func (c *Client) GetState(height uint32, key []byte) ([]byte, error) {
+	header, err := c.GetHeader(height)
+	if err != nil {
+		return nil, err
+	}
+
+	proof, err := c.provider.GetStateProof(height, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(proof.Key, key) {
+		return nil, fmt.Errorf("provider returned a proof for key (%s)", proof.Key)
+	}
+
+	if !proof.Verify(header.StateRoot) {
+		return nil, fmt.Errorf("invalid proof for key (%s) at height (%d)", key, height)
+	}
+
+	return proof.Value, nil
+}
@@ -0,0 +1,244 @@
+package lightclient
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/core"
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// storeCode stores 5 under the key FOO: push 5, byte 'F', byte 'O', byte 'O',
+// push 3, pack, store
+var storeCode = []byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
+
+// fooKey is the key of FOO in the state, scripts write to the storage of the
+// zero address.
+var fooKey = core.StorageKey(types.Address{}, []byte("FOO"))
+
+func newChain(t *testing.T) (*core.Blockchain, *core.Header) {
+	genesis, err := core.NewBlock(&core.Header{Version: 1}, nil)
+	assert.Nil(t, err)
+
+	bc, err := core.NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	return bc, genesis.Header
+}
+
+func addBlock(t *testing.T, bc *core.Blockchain, privKey crypto.PrivateKey, txx ...*core.Transaction) *core.Block {
+	prevHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+
+	b, err := core.NewBlockFromPrevHeader(prevHeader, txx)
+	assert.Nil(t, err)
+
+	state, receipts, err := bc.ExecuteBlock(b)
+	assert.Nil(t, err)
+	b.StateRoot = state.Root()
+	b.LogsBloom = core.LogsBloom(receipts)
+
+	assert.Nil(t, b.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(b))
+
+	return b
+}
+
+func newTx(t *testing.T, data []byte) *core.Transaction {
+	tx := core.NewTransaction(data)
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	return tx
+}
+
+func TestClientSync(t *testing.T) {
+	bc, genesis := newChain(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	for i := 0; i < 10; i++ {
+		addBlock(t, bc, privKey, newTx(t, []byte("foo")))
+	}
+
+	c, err := NewClient(genesis, []crypto.PublicKey{privKey.PublicKey()}, NewChainProvider(bc))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Sync())
+	assert.Equal(t, bc.Height(), c.Height())
+
+	addBlock(t, bc, privKey)
+	assert.Nil(t, c.Sync())
+	assert.Equal(t, uint32(11), c.Height())
+}
+
+func TestClientRejectsUnknownValidator(t *testing.T) {
+	bc, genesis := newChain(t)
+	addBlock(t, bc, crypto.GeneratePrivateKey())
+
+	c, err := NewClient(genesis, []crypto.PublicKey{crypto.GeneratePrivateKey().PublicKey()}, NewChainProvider(bc))
+	assert.Nil(t, err)
+	assert.NotNil(t, c.Sync())
+	assert.Equal(t, uint32(0), c.Height())
+}
+
+func TestNewClientWithoutValidators(t *testing.T) {
+	bc, genesis := newChain(t)
+
+	_, err := NewClient(genesis, nil, NewChainProvider(bc))
+	assert.NotNil(t, err)
+}
+
+func TestClientRejectsForgedChain(t *testing.T) {
+	bc, genesis := newChain(t)
+	privKey := crypto.GeneratePrivateKey()
+	addBlock(t, bc, privKey)
+
+	// A chain built on the same genesis and signed by an unknown key
+	forged, forgedGenesis := newChain(t)
+	assert.Equal(t, genesis, forgedGenesis)
+	forger := crypto.GeneratePrivateKey()
+	for i := 0; i < 3; i++ {
+		addBlock(t, forged, forger, newTx(t, storeCode))
+	}
+
+	c, err := NewClient(genesis, []crypto.PublicKey{privKey.PublicKey()}, NewChainProvider(forged))
+	assert.Nil(t, err)
+	assert.NotNil(t, c.Sync())
+	assert.Equal(t, uint32(0), c.Height())
+
+	c, err = NewClient(genesis, []crypto.PublicKey{privKey.PublicKey()}, NewChainProvider(bc))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Sync())
+	assert.Equal(t, uint32(1), c.Height())
+}
+
+func TestClientRejectsInvalidHeaders(t *testing.T) {
+	bc, genesis := newChain(t)
+	privKey := crypto.GeneratePrivateKey()
+	b := addBlock(t, bc, privKey)
+
+	c, err := NewClient(genesis, []crypto.PublicKey{privKey.PublicKey()}, NewChainProvider(bc))
+	assert.Nil(t, err)
+
+	// Signed by someone else than the validator
+	h := b.SignedHeader()
+	otherKey := crypto.GeneratePrivateKey().PublicKey()
+	assert.NotNil(t, c.AddHeader(&core.SignedHeader{Header: h.Header, Validator: otherKey, Signature: h.Signature}))
+	assert.NotNil(t, c.AddHeader(&core.SignedHeader{Header: h.Header, Validator: h.Validator}))
+
+	// Validly signed but not linked to the genesis
+	unlinked := &core.Header{Version: 1, Height: 1, PrevBlockHash: types.RandomHash()}
+	sig, err := privKey.SignDigest(unlinked.SigningDigest())
+	assert.Nil(t, err)
+	assert.NotNil(t, c.AddHeader(&core.SignedHeader{Header: unlinked, Validator: privKey.PublicKey(), Signature: sig}))
+
+	// The validator signature does not cover a tampered header
+	tampered := *h.Header
+	tampered.StateRoot = types.RandomHash()
+	assert.NotNil(t, c.AddHeader(&core.SignedHeader{Header: &tampered, Validator: h.Validator, Signature: h.Signature}))
+
+	assert.Nil(t, c.AddHeader(h))
+	assert.NotNil(t, c.AddHeader(h))
+}
+
+func TestClientProofs(t *testing.T) {
+	bc, genesis := newChain(t)
+	privKey := crypto.GeneratePrivateKey()
+	tx := newTx(t, storeCode)
+	addBlock(t, bc, privKey, newTx(t, []byte("foo")), tx)
+
+	c, err := NewClient(genesis, []crypto.PublicKey{privKey.PublicKey()}, NewChainProvider(bc))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Sync())
+
+	ok, err := c.HasTransaction(1, tx.Hash(core.TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = c.HasTransaction(1, types.RandomHash())
+	assert.ErrorIs(t, err, ErrNotProven)
+	assert.False(t, ok)
+
+	value, err := c.GetState(1, fooKey)
+	assert.Nil(t, err)
+	assert.Equal(t, append(make([]byte, 31), 5), value)
+
+	_, err = c.GetState(0, fooKey)
+	assert.NotNil(t, err)
+
+	_, err = c.GetState(2, fooKey)
+	assert.NotNil(t, err)
+}
+
+type failingProvider struct {
+	*ChainProvider
+}
+
+func (p failingProvider) GetTxProof(height uint32, hash types.Hash) (*core.TxProof, error) {
+	return nil, fmt.Errorf("provider unavailable")
+}
+
+func TestClientHasTransactionProviderError(t *testing.T) {
+	bc, genesis := newChain(t)
+	privKey := crypto.GeneratePrivateKey()
+	tx := newTx(t, storeCode)
+	addBlock(t, bc, privKey, tx)
+
+	c, err := NewClient(genesis, []crypto.PublicKey{privKey.PublicKey()}, failingProvider{NewChainProvider(bc)})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Sync())
+
+	ok, err := c.HasTransaction(1, tx.Hash(core.TxHasher{}))
+	assert.NotNil(t, err)
+	assert.False(t, ok)
+}
+
+type hidingProvider struct {
+	*ChainProvider
+}
+
+func (p hidingProvider) GetTxProof(height uint32, hash types.Hash) (*core.TxProof, error) {
+	return nil, core.ErrTxNotFound
+}
+
+func TestClientHasTransactionNotProven(t *testing.T) {
+	bc, genesis := newChain(t)
+	privKey := crypto.GeneratePrivateKey()
+	tx := newTx(t, storeCode)
+	addBlock(t, bc, privKey, tx)
+
+	c, err := NewClient(genesis, []crypto.PublicKey{privKey.PublicKey()}, hidingProvider{NewChainProvider(bc)})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Sync())
+
+	// The provider hides an included transaction, absence is never verified
+	ok, err := c.HasTransaction(1, tx.Hash(core.TxHasher{}))
+	assert.ErrorIs(t, err, ErrNotProven)
+	assert.False(t, ok)
+}
+
+type lyingProvider struct {
+	*ChainProvider
+}
+
+func (p lyingProvider) GetStateProof(height uint32, key []byte) (*core.StateProof, error) {
+	proof, err := p.ChainProvider.GetStateProof(height, key)
+	if err != nil {
+		return nil, err
+	}
+	proof.Value = []byte("lie")
+	return proof, nil
+}
+
+func TestClientRejectsInvalidProof(t *testing.T) {
+	bc, genesis := newChain(t)
+	privKey := crypto.GeneratePrivateKey()
+	addBlock(t, bc, privKey, newTx(t, storeCode))
+
+	c, err := NewClient(genesis, []crypto.PublicKey{privKey.PublicKey()}, lyingProvider{NewChainProvider(bc)})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Sync())
+
+	_, err = c.GetState(1, fooKey)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,109 @@
+package lightclient
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/anthoai97/blockchain-from-scratch/core"
+	"github.com/anthoai97/blockchain-from-scratch/network"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// PeerProvider requests headers and proofs from a full node over the network.
+type PeerProvider struct {
+	server  *network.Server
+	peer    network.NetAddr
+	timeout time.Duration
+}
+
+func NewPeerProvider(s *network.Server, peer network.NetAddr, timeout time.Duration) *PeerProvider {
+	return &PeerProvider{
+		server:  s,
+		peer:    peer,
+		timeout: timeout,
+	}
+}
+
+func (p *PeerProvider) GetHeaders(from, to uint32) ([]*core.SignedHeader, error) {
+	resp, err := p.request(network.MessageTypeGetHeaders, &network.GetHeadersMessage{From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+
+	headersMessage, ok := resp.(*network.HeadersMessage)
+	if !ok {
+		return nil, fmt.Errorf("peer %s answered with %T instead of headers", p.peer, resp)
+	}
+
+	return headersMessage.Headers, nil
+}
+
+func (p *PeerProvider) GetTxProof(height uint32, hash types.Hash) (*core.TxProof, error) {
+	proofMessage, err := p.getProof(&network.GetProofMessage{
+		Type:   network.ProofTypeTx,
+		Height: height,
+		TxHash: hash,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if proofMessage.TxProof == nil {
+		return nil, fmt.Errorf("peer %s returned no transaction proof", p.peer)
+	}
+
+	return proofMessage.TxProof, nil
+}
+
+func (p *PeerProvider) GetStateProof(height uint32, key []byte) (*core.StateProof, error) {
+	proofMessage, err := p.getProof(&network.GetProofMessage{
+		Type:   network.ProofTypeState,
+		Height: height,
+		Key:    key,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if proofMessage.StateProof == nil {
+		return nil, fmt.Errorf("peer %s returned no state proof", p.peer)
+	}
+
+	return proofMessage.StateProof, nil
+}
+
+func (p *PeerProvider) getProof(getProofMessage *network.GetProofMessage) (*network.ProofMessage, error) {
+	resp, err := p.request(network.MessageTypeGetProof, getProofMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	proofMessage, ok := resp.(*network.ProofMessage)
+	if !ok {
+		return nil, fmt.Errorf("peer %s answered with %T instead of a proof", p.peer, resp)
+	}
+
+	if proofMessage.NotFound {
+		return nil, fmt.Errorf("peer %s: %w", p.peer, core.ErrTxNotFound)
+	}
+
+	if proofMessage.Error != "" {
+		return nil, fmt.Errorf("peer %s: %s", p.peer, proofMessage.Error)
+	}
+
+	return proofMessage, nil
+}
+
+func (p *PeerProvider) request(t network.MessageType, data any) (any, error) {
+	msg, err := network.NewGobMessage(t, data)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.server.Request(p.peer, msg, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
@@ -0,0 +1,63 @@
+package lightclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/network"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerProvider(t *testing.T) {
+	trLight := network.NewLocalTransport("LIGHT")
+	trFull := network.NewLocalTransport("FULL")
+	trLight.Connect(trFull)
+	trFull.Connect(trLight)
+
+	privKey := crypto.GeneratePrivateKey()
+	full, err := network.NewServer(network.ServerOpts{
+		ID:         "FULL",
+		Logger:     log.NewNopLogger(),
+		Transport:  trFull,
+		Transports: []network.Transport{trFull},
+		BlockTime:  10 * time.Millisecond,
+		PrivateKey: &privKey,
+	})
+	assert.Nil(t, err)
+
+	light, err := network.NewServer(network.ServerOpts{
+		ID:         "LIGHT",
+		Logger:     log.NewNopLogger(),
+		Transport:  trLight,
+		Transports: []network.Transport{trLight},
+	})
+	assert.Nil(t, err)
+
+	go full.Start()
+	go light.Start()
+
+	// Let the full node produce a few blocks
+	time.Sleep(100 * time.Millisecond)
+
+	p := NewPeerProvider(light, trFull.Addr(), time.Second)
+
+	genesis, err := p.GetHeaders(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(genesis))
+
+	c, err := NewClient(genesis[0].Header, []crypto.PublicKey{privKey.PublicKey()}, p)
+	assert.Nil(t, err)
+	assert.Nil(t, c.Sync())
+	assert.True(t, c.Height() > 0)
+
+	// Blocks are empty so there is nothing to prove
+	ok, err := c.HasTransaction(1, types.RandomHash())
+	assert.ErrorIs(t, err, ErrNotProven)
+	assert.False(t, ok)
+
+	_, err = c.GetState(1, fooKey)
+	assert.NotNil(t, err)
+}
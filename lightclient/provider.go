@@ -0,0 +1,33 @@
+package lightclient
+
+import (
+	"github.com/anthoai97/blockchain-from-scratch/core"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+// ChainProvider serves headers and proofs from a local blockchain.
+type ChainProvider struct {
+	chain *core.Blockchain
+}
+
+func NewChainProvider(bc *core.Blockchain) *ChainProvider {
+	return &ChainProvider{
+		chain: bc,
+	}
+}
+
+func (p *ChainProvider) GetHeaders(from, to uint32) ([]*core.SignedHeader, error) {
+	if from > p.chain.Height() {
+		return []*core.SignedHeader{}, nil
+	}
+
+	return p.chain.GetHeaders(from, to)
+}
+
+func (p *ChainProvider) GetTxProof(height uint32, hash types.Hash) (*core.TxProof, error) {
+	return p.chain.GetTxProof(height, hash)
+}
+
+func (p *ChainProvider) GetStateProof(height uint32, key []byte) (*core.StateProof, error) {
+	return p.chain.GetStateProof(height, key)
+}
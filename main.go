@@ -3,10 +3,14 @@ package main
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
+	"github.com/anthoai97/blockchain-from-scratch/asm"
 	"github.com/anthoai97/blockchain-from-scratch/core"
 	"github.com/anthoai97/blockchain-from-scratch/crypto"
 	"github.com/anthoai97/blockchain-from-scratch/network"
@@ -19,7 +23,11 @@ import (
 // Transaction
 // KeyPair
 
+var keystorePath = flag.String("keystore", "", "keystore file of the validator key, created when missing, the passphrase is read from VALIDATOR_PASSPHRASE")
+
 func main() {
+	flag.Parse()
+
 	trLocal := network.NewLocalTransport("LOCAL")
 	trRemoteA := network.NewLocalTransport("REMOTE_A")
 	trRemoteB := network.NewLocalTransport("REMOTE_B")
@@ -58,11 +66,36 @@ func main() {
 	// 	go lateServer.Start()
 	// }()
 
-	privKey := crypto.GeneratePrivateKey()
+	privKey, err := validatorKey(*keystorePath, os.Getenv("VALIDATOR_PASSPHRASE"))
+	if err != nil {
+		log.Fatal(err)
+	}
 	localServer := makeServer("LOCAL", trLocal, &privKey)
 	localServer.Start()
 }
 
+// validatorKey loads the validator key from the keystore file, a new key is
+// saved to it when the file does not exist. Without keystore a new key is
+// used for every run.
+func validatorKey(path, passphrase string) (crypto.PrivateKey, error) {
+	if path == "" {
+		return crypto.GeneratePrivateKey(), nil
+	}
+
+	privKey, err := crypto.LoadPrivateKey(path, passphrase)
+	if !errors.Is(err, os.ErrNotExist) {
+		return privKey, err
+	}
+
+	privKey = crypto.GeneratePrivateKey()
+	if err := crypto.SavePrivateKey(path, privKey, passphrase); err != nil {
+		return crypto.PrivateKey{}, err
+	}
+	logrus.WithField("address", privKey.PublicKey().Address()).Info("created validator keystore ", path)
+
+	return privKey, nil
+}
+
 func makeServer(id string, tr network.Transport, pk *crypto.PrivateKey) *network.Server {
 	opts := network.ServerOpts{
 		Transport:  tr,
@@ -87,9 +120,23 @@ func initRemoteServers(trs []network.Transport) {
 	}
 }
 
+// storeFoo stores 5 under the key FOO
+const storeFoo = `
+	PUSH 5
+	BYTE 'F'
+	BYTE 'O'
+	BYTE 'O'
+	PUSH 3
+	PACK
+	STORE
+`
+
 func sendTransaction(tr network.Transport, to network.NetAddr) error {
 	privKey := crypto.GeneratePrivateKey()
-	data := []byte{0x03, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x0d, 0x05, 0x0a, 0x0f}
+	data, err := asm.Assemble(storeFoo)
+	if err != nil {
+		return err
+	}
 	tx := core.NewTransaction(data)
 	tx.Sign(privKey)
 	buf := &bytes.Buffer{}
@@ -99,7 +146,12 @@ func sendTransaction(tr network.Transport, to network.NetAddr) error {
 
 	msg := network.NewMessage(network.MessageTypeTx, buf.Bytes())
 
-	return tr.SendMessage(to, msg.Bytes())
+	payload, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return tr.SendMessage(to, payload)
 }
 
 func sendGetStatusMessage(tr network.Transport, to network.NetAddr) error {
@@ -114,5 +166,10 @@ func sendGetStatusMessage(tr network.Transport, to network.NetAddr) error {
 
 	msg := network.NewMessage(network.MessageTypeGetStatus, buf.Bytes())
 
-	return tr.SendMessage(to, msg.Bytes())
+	payload, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return tr.SendMessage(to, payload)
 }
@@ -0,0 +1,131 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Every message on the wire is framed as
+//
+//	magic(4) version(1) type(1) flags(1) id(u64) length(u32) checksum(4)
+//	payload(length)
+//
+// where integers are big endian and the checksum is the first 4 bytes of the
+// sha256 of the payload. The id correlates a response, which has the response
+// flag set, with its request. The frame header is checked before any byte of
+// the payload is read.
+const (
+	ProtocolVersion byte = 2
+
+	frameHeaderSize = 4 + 1 + 1 + 1 + 8 + 4 + 4
+
+	frameFlagResponse byte = 0x1
+)
+
+var frameMagic = [4]byte{'B', 'F', 'S', 0x00}
+
+// maxPayloadSize is the maximum payload size per message type, a message type
+// that is not listed here is unknown.
+var maxPayloadSize = map[MessageType]uint32{
+	MessageTypeTx:         1 << 16,
+	MessageTypeBlock:      1 << 23,
+	MessageTypeGetBlocks:  1 << 10,
+	MessageTypeStatus:     1 << 10,
+	MessageTypeGetStatus:  1 << 10,
+	MessageTypeGetHeaders: 1 << 10,
+	MessageTypeHeaders:    1 << 21,
+	MessageTypeGetProof:   1 << 16,
+	MessageTypeProof:      1 << 20,
+}
+
+func frameChecksum(payload []byte) [4]byte {
+	var sum [4]byte
+	h := sha256.Sum256(payload)
+	copy(sum[:], h[:4])
+	return sum
+}
+
+// EncodeFrame returns the framed bytes of the message.
+func EncodeFrame(msg *Message) ([]byte, error) {
+	max, ok := maxPayloadSize[msg.Header]
+	if !ok {
+		return nil, fmt.Errorf("invalid message header %x", msg.Header)
+	}
+	if uint64(len(msg.Data)) > uint64(max) {
+		return nil, fmt.Errorf("message payload of %d bytes exceeds %d bytes for type %x", len(msg.Data), max, msg.Header)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, frameHeaderSize+len(msg.Data)))
+	buf.Write(frameMagic[:])
+	buf.WriteByte(ProtocolVersion)
+	buf.WriteByte(byte(msg.Header))
+
+	var flags byte
+	if msg.Response {
+		flags |= frameFlagResponse
+	}
+	buf.WriteByte(flags)
+
+	fields := make([]byte, 8+4)
+	binary.BigEndian.PutUint64(fields[:8], msg.ID)
+	binary.BigEndian.PutUint32(fields[8:], uint32(len(msg.Data)))
+	buf.Write(fields)
+
+	checksum := frameChecksum(msg.Data)
+	buf.Write(checksum[:])
+	buf.Write(msg.Data)
+
+	return buf.Bytes(), nil
+}
+
+// DecodeFrame reads one framed message from r. Frames with a wrong magic, an
+// unknown version or type, or a payload larger than allowed for their type
+// are rejected before reading the payload.
+func DecodeFrame(r io.Reader) (*Message, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read frame header: %s", err)
+	}
+
+	if !bytes.Equal(header[:4], frameMagic[:]) {
+		return nil, fmt.Errorf("invalid frame magic %x", header[:4])
+	}
+
+	if version := header[4]; version != ProtocolVersion {
+		return nil, fmt.Errorf("unsupported protocol version %d", version)
+	}
+
+	msgType := MessageType(header[5])
+	max, ok := maxPayloadSize[msgType]
+	if !ok {
+		return nil, fmt.Errorf("invalid message header %x", msgType)
+	}
+
+	flags := header[6]
+	if flags&^frameFlagResponse != 0 {
+		return nil, fmt.Errorf("invalid frame flags %x", flags)
+	}
+
+	length := binary.BigEndian.Uint32(header[15:19])
+	if length > max {
+		return nil, fmt.Errorf("message payload of %d bytes exceeds %d bytes for type %x", length, max, msgType)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("failed to read frame payload: %s", err)
+	}
+
+	if checksum := frameChecksum(payload); !bytes.Equal(checksum[:], header[19:23]) {
+		return nil, fmt.Errorf("invalid frame checksum for type %x", msgType)
+	}
+
+	msg := NewMessage(msgType, payload)
+	msg.ID = binary.BigEndian.Uint64(header[7:15])
+	msg.Response = flags&frameFlagResponse != 0
+
+	return msg, nil
+}
@@ -0,0 +1,76 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameEncodeDecode(t *testing.T) {
+	msg := NewMessage(MessageTypeTx, []byte("foo"))
+	msg.ID = 42
+	msg.Response = true
+	b, err := msg.Bytes()
+	assert.Nil(t, err)
+	assert.Equal(t, frameHeaderSize+3, len(b))
+
+	decoded, err := DecodeFrame(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, msg, decoded)
+}
+
+func TestFrameEncodeTooLarge(t *testing.T) {
+	msg := NewMessage(MessageTypeStatus, make([]byte, maxPayloadSize[MessageTypeStatus]+1))
+	_, err := msg.Bytes()
+	assert.NotNil(t, err)
+
+	_, err = NewMessage(MessageType(0xff), nil).Bytes()
+	assert.NotNil(t, err)
+}
+
+func TestFrameDecodeRejects(t *testing.T) {
+	valid, err := NewMessage(MessageTypeTx, []byte("foo")).Bytes()
+	assert.Nil(t, err)
+
+	frame := func(modify func(b []byte)) []byte {
+		b := make([]byte, len(valid))
+		copy(b, valid)
+		modify(b)
+		return b
+	}
+
+	cases := map[string][]byte{
+		"magic":    frame(func(b []byte) { b[0] = 'X' }),
+		"version":  frame(func(b []byte) { b[4] = ProtocolVersion + 1 }),
+		"type":     frame(func(b []byte) { b[5] = 0xff }),
+		"flags":    frame(func(b []byte) { b[6] = 0xff }),
+		"checksum": frame(func(b []byte) { b[len(b)-1] ^= 0xff }),
+		"short":    valid[:len(valid)-1],
+		"header":   valid[:frameHeaderSize-1],
+	}
+
+	for name, b := range cases {
+		_, err := DecodeFrame(bytes.NewReader(b))
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestFrameDecodeOversizedBeforePayload(t *testing.T) {
+	// Only the frame header is sent, an oversized frame must be rejected
+	// without waiting for its payload.
+	header := make([]byte, frameHeaderSize)
+	copy(header, frameMagic[:])
+	header[4] = ProtocolVersion
+	header[5] = byte(MessageTypeTx)
+	binary.BigEndian.PutUint32(header[15:19], maxPayloadSize[MessageTypeTx]+1)
+
+	_, err := DecodeFrame(bytes.NewReader(header))
+	assert.Contains(t, err.Error(), "exceeds")
+}
+
+func TestDefaultRPCDecodeFuncRejectsGarbage(t *testing.T) {
+	_, err := DefaultRPCDecodeFunc(RPC{From: "A", Payload: bytes.NewReader([]byte("garbage that is no frame"))})
+	assert.NotNil(t, err)
+}
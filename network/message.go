@@ -1,5 +1,10 @@
 package network
 
+import (
+	"github.com/anthoai97/blockchain-from-scratch/core"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
 type GetStatusMessage struct{}
 
 type StatusMessage struct {
@@ -8,3 +13,41 @@ type StatusMessage struct {
 	Version       uint32
 	CurrentHeight uint32
 }
+
+// GetHeadersMessage requests the signed headers in the range [From, To].
+type GetHeadersMessage struct {
+	From uint32
+	To   uint32
+}
+
+type HeadersMessage struct {
+	Headers []*core.SignedHeader
+}
+
+type ProofType byte
+
+const (
+	ProofTypeTx    ProofType = 0x1
+	ProofTypeState ProofType = 0x2
+)
+
+// GetProofMessage requests a merkle proof of a transaction (TxHash) or of a
+// state key (Key) at the given block height.
+type GetProofMessage struct {
+	Type   ProofType
+	Height uint32
+	TxHash types.Hash
+	Key    []byte
+}
+
+// ProofMessage is the answer to a GetProofMessage, Error is set when the full
+// node could not build the proof and NotFound when the block does not
+// contain the transaction.
+type ProofMessage struct {
+	Type       ProofType
+	Height     uint32
+	TxProof    *core.TxProof
+	StateProof *core.StateProof
+	Error      string
+	NotFound   bool
+}
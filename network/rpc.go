@@ -13,11 +13,15 @@ import (
 type MessageType byte
 
 const (
-	MessageTypeTx        MessageType = 0x1
-	MessageTypeBlock     MessageType = 0x2
-	MessageTypeGetBlocks MessageType = 0x3
-	MessageTypeStatus    MessageType = 0x4
-	MessageTypeGetStatus MessageType = 0x5
+	MessageTypeTx         MessageType = 0x1
+	MessageTypeBlock      MessageType = 0x2
+	MessageTypeGetBlocks  MessageType = 0x3
+	MessageTypeStatus     MessageType = 0x4
+	MessageTypeGetStatus  MessageType = 0x5
+	MessageTypeGetHeaders MessageType = 0x6
+	MessageTypeHeaders    MessageType = 0x7
+	MessageTypeGetProof   MessageType = 0x8
+	MessageTypeProof      MessageType = 0x9
 )
 
 type RPC struct {
@@ -28,6 +32,10 @@ type RPC struct {
 type Message struct {
 	Header MessageType
 	Data   []byte
+	// ID correlates a response with the request it answers, 0 when the
+	// sender does not wait for a response.
+	ID       uint64
+	Response bool
 }
 
 func NewMessage(t MessageType, data []byte) *Message {
@@ -37,10 +45,19 @@ func NewMessage(t MessageType, data []byte) *Message {
 	}
 }
 
-func (msg *Message) Bytes() []byte {
-	buf := &bytes.Buffer{}
-	gob.NewEncoder(buf).Encode(msg)
-	return buf.Bytes()
+// NewGobMessage returns a message with the gob encoding of data as payload.
+func NewGobMessage(t MessageType, data any) (*Message, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(data); err != nil {
+		return nil, err
+	}
+
+	return NewMessage(t, buf.Bytes()), nil
+}
+
+// Bytes returns the message framed for the wire.
+func (msg *Message) Bytes() ([]byte, error) {
+	return EncodeFrame(msg)
 }
 
 type RPCHandler interface {
@@ -48,15 +65,17 @@ type RPCHandler interface {
 }
 
 type DecodedMessage struct {
-	From NetAddr
-	Data any
+	From      NetAddr
+	RequestID uint64
+	Response  bool
+	Data      any
 }
 
 type RPCDecodeFunc func(RPC) (*DecodedMessage, error)
 
 func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
-	msg := Message{}
-	if err := gob.NewDecoder(rpc.Payload).Decode(&msg); err != nil {
+	msg, err := DecodeFrame(rpc.Payload)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode messsage from %s: %s", rpc.From, err)
 	}
 
@@ -73,8 +92,10 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 		}
 
 		return &DecodedMessage{
-			From: rpc.From,
-			Data: tx,
+			From:      rpc.From,
+			RequestID: msg.ID,
+			Response:  msg.Response,
+			Data:      tx,
 		}, nil
 
 	case MessageTypeBlock:
@@ -84,14 +105,18 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 		}
 
 		return &DecodedMessage{
-			From: rpc.From,
-			Data: block,
+			From:      rpc.From,
+			RequestID: msg.ID,
+			Response:  msg.Response,
+			Data:      block,
 		}, nil
 
 	case MessageTypeGetStatus:
 		return &DecodedMessage{
-			From: rpc.From,
-			Data: &GetStatusMessage{},
+			From:      rpc.From,
+			RequestID: msg.ID,
+			Response:  msg.Response,
+			Data:      &GetStatusMessage{},
 		}, nil
 
 	case MessageTypeStatus:
@@ -102,8 +127,66 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 		}
 
 		return &DecodedMessage{
-			From: rpc.From,
-			Data: statusMessage,
+			From:      rpc.From,
+			RequestID: msg.ID,
+			Response:  msg.Response,
+			Data:      statusMessage,
+		}, nil
+
+	case MessageTypeGetHeaders:
+		getHeadersMessage := new(GetHeadersMessage)
+
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getHeadersMessage); err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From:      rpc.From,
+			RequestID: msg.ID,
+			Response:  msg.Response,
+			Data:      getHeadersMessage,
+		}, nil
+
+	case MessageTypeHeaders:
+		headersMessage := new(HeadersMessage)
+
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(headersMessage); err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From:      rpc.From,
+			RequestID: msg.ID,
+			Response:  msg.Response,
+			Data:      headersMessage,
+		}, nil
+
+	case MessageTypeGetProof:
+		getProofMessage := new(GetProofMessage)
+
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getProofMessage); err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From:      rpc.From,
+			RequestID: msg.ID,
+			Response:  msg.Response,
+			Data:      getProofMessage,
+		}, nil
+
+	case MessageTypeProof:
+		proofMessage := new(ProofMessage)
+
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(proofMessage); err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From:      rpc.From,
+			RequestID: msg.ID,
+			Response:  msg.Response,
+			Data:      proofMessage,
 		}, nil
 
 	default:
@@ -111,6 +194,9 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 	}
 }
 
+// RPCProcessor processes the messages in the loop of Server.Start, the
+// responses to Server.Request are delivered by the same loop so
+// ProcessMessage must not wait on a request.
 type RPCProcessor interface {
 	ProcessMessage(*DecodedMessage) error
 }
@@ -2,14 +2,14 @@ package network
 
 import (
 	"bytes"
-	"encoding/gob"
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/anthoai97/blockchain-from-scratch/core"
 	"github.com/anthoai97/blockchain-from-scratch/crypto"
-	"github.com/anthoai97/blockchain-from-scratch/types"
 	"github.com/go-kit/log"
 )
 
@@ -24,6 +24,16 @@ type ServerOpts struct {
 	Transport     Transport
 	BlockTime     time.Duration
 	PrivateKey    *crypto.PrivateKey
+	// KeystorePath is the keystore file the private key is loaded from with
+	// KeystorePassphrase when PrivateKey is nil, see crypto.LoadPrivateKey.
+	KeystorePath       string
+	KeystorePassphrase string
+	// Executor runs the code of the transactions of the chain, the stack VM
+	// when nil. Every node of a network must use the same one.
+	Executor core.Executor
+	// ChainParams are committed in the genesis block, nodes with different
+	// ones are on different chains.
+	ChainParams core.ChainParams
 }
 
 type Server struct {
@@ -33,6 +43,18 @@ type Server struct {
 	isValidator bool
 	rpcCh       chan RPC
 	quitCh      chan struct{}
+
+	requestLock   sync.Mutex
+	lastRequestID uint64
+	// inflight holds the requests waiting for a response by request id
+	inflight map[uint64]inflightRequest
+}
+
+// inflightRequest is a request waiting for the response of the peer it was
+// sent to.
+type inflightRequest struct {
+	to     NetAddr
+	respCh chan *DecodedMessage
 }
 
 func NewServer(opts ServerOpts) (*Server, error) {
@@ -45,15 +67,30 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		opts.RPCDecodeFunc = DefaultRPCDecodeFunc
 	}
 
+	if opts.PrivateKey == nil && opts.KeystorePath != "" {
+		privKey, err := crypto.LoadPrivateKey(opts.KeystorePath, opts.KeystorePassphrase)
+		if err != nil {
+			return nil, err
+		}
+		opts.PrivateKey = &privKey
+	}
+
 	if opts.Logger == nil {
 		opts.Logger = log.NewLogfmtLogger(os.Stderr)
 		opts.Logger = log.With(opts.Logger, "ID", opts.ID)
 	}
 
-	chain, err := core.NewBlockchain(opts.Logger, genesisBlock())
+	genesis, err := genesisBlock(opts.ChainParams)
 	if err != nil {
 		return nil, err
 	}
+	chain, err := core.NewBlockchain(opts.Logger, genesis)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Executor != nil {
+		chain.SetExecutor(opts.Executor)
+	}
 
 	s := &Server{
 		ServerOpts:  opts,
@@ -62,6 +99,7 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		isValidator: opts.PrivateKey != nil,
 		rpcCh:       make(chan RPC),
 		quitCh:      make(chan struct{}),
+		inflight:    make(map[uint64]inflightRequest),
 	}
 
 	// If we dont got any Processor from the server options, we going to use the server dafault
@@ -86,6 +124,11 @@ free:
 			msg, err := s.RPCDecodeFunc(rpc)
 			if err != nil {
 				s.Logger.Log("err", err)
+				continue
+			}
+
+			if msg.Response && s.deliverResponse(msg) {
+				continue
 			}
 
 			if err := s.RPCProcessor.ProcessMessage(msg); err != nil {
@@ -102,6 +145,95 @@ free:
 	s.Logger.Log("msg", "Server is shutting down")
 }
 
+// Request sends the message to the peer and waits for its response, only a
+// response from that peer is accepted. An error is returned when no response
+// arrived before the timeout.
+//
+// Responses are delivered by the loop of Start, Request must not be called
+// from ProcessMessage or it blocks that loop and always times out.
+func (s *Server) Request(to NetAddr, msg *Message, timeout time.Duration) (*DecodedMessage, error) {
+	respCh := make(chan *DecodedMessage, 1)
+
+	s.requestLock.Lock()
+	s.lastRequestID++
+	id := s.lastRequestID
+	s.inflight[id] = inflightRequest{to: to, respCh: respCh}
+	s.requestLock.Unlock()
+
+	defer func() {
+		s.requestLock.Lock()
+		delete(s.inflight, id)
+		s.requestLock.Unlock()
+	}()
+
+	req := *msg
+	req.ID = id
+	req.Response = false
+
+	payload, err := req.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Transport.SendMessage(to, payload); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-respCh:
+		return resp, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("request (%d) to %s timed out after %s", id, to, timeout)
+	}
+}
+
+// InflightRequests returns the number of requests waiting for a response.
+func (s *Server) InflightRequests() int {
+	s.requestLock.Lock()
+	defer s.requestLock.Unlock()
+
+	return len(s.inflight)
+}
+
+// deliverResponse hands the response to the request waiting for it, it returns
+// false if no request is waiting for the response. A response from another
+// peer than the one the request was sent to is dropped, the request keeps
+// waiting.
+func (s *Server) deliverResponse(msg *DecodedMessage) bool {
+	s.requestLock.Lock()
+	req, ok := s.inflight[msg.RequestID]
+	if ok && req.to != msg.From {
+		s.requestLock.Unlock()
+		s.Logger.Log("msg", "dropped response from unexpected peer", "from", msg.From, "to", req.to, "request", msg.RequestID)
+		return true
+	}
+	delete(s.inflight, msg.RequestID)
+	s.requestLock.Unlock()
+
+	if ok {
+		req.respCh <- msg
+	}
+
+	return ok
+}
+
+// respond sends data as the response to the request with the given id.
+func (s *Server) respond(to NetAddr, requestID uint64, t MessageType, data any) error {
+	msg, err := NewGobMessage(t, data)
+	if err != nil {
+		return err
+	}
+	msg.ID = requestID
+	msg.Response = true
+
+	payload, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return s.Transport.SendMessage(to, payload)
+}
+
 func (s *Server) broadcastTx(tx *core.Transaction) error {
 	buf := &bytes.Buffer{}
 
@@ -111,7 +243,12 @@ func (s *Server) broadcastTx(tx *core.Transaction) error {
 
 	msg := NewMessage(MessageTypeTx, buf.Bytes())
 
-	return s.broadcast(msg.Bytes())
+	payload, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return s.broadcast(payload)
 }
 
 func (s *Server) broadcastBlock(b *core.Block) error {
@@ -123,7 +260,12 @@ func (s *Server) broadcastBlock(b *core.Block) error {
 
 	msg := NewMessage(MessageTypeBlock, buf.Bytes())
 
-	return s.broadcast(msg.Bytes())
+	payload, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return s.broadcast(payload)
 }
 
 func (s *Server) createNewBlock() error {
@@ -132,13 +274,20 @@ func (s *Server) createNewBlock() error {
 		return err
 	}
 
-	txx := s.memPool.Pending()
+	txx := s.selectTransactions()
 
 	block, err := core.NewBlockFromPrevHeader(currentHeader, txx)
 	if err != nil {
 		return err
 	}
 
+	state, receipts, err := s.chain.ExecuteBlock(block)
+	if err != nil {
+		return err
+	}
+	block.StateRoot = state.Root()
+	block.LogsBloom = core.LogsBloom(receipts)
+
 	if err := block.Sign(*s.PrivateKey); err != nil {
 		return err
 	}
@@ -147,24 +296,42 @@ func (s *Server) createNewBlock() error {
 		return err
 	}
 
-	s.memPool.ClearPending()
+	s.memPool.RemovePending(txx)
 
 	go s.broadcastBlock(block)
 
 	return nil
 }
 
-func genesisBlock() *core.Block {
+// selectTransactions returns the pending transactions, in the order they were
+// first seen, as long as they fit in the block gas limit.
+func (s *Server) selectTransactions() []*core.Transaction {
+	var (
+		txx   = []*core.Transaction{}
+		gas   uint64
+		limit = s.chain.BlockGasLimit()
+	)
+
+	for _, tx := range s.memPool.Pending() {
+		if tx.Gas() > limit-gas {
+			continue
+		}
+
+		gas += tx.Gas()
+		txx = append(txx, tx)
+	}
+
+	return txx
+}
+
+func genesisBlock(params core.ChainParams) (*core.Block, error) {
 	header := &core.Header{
 		Version:   1,
-		DataHash:  types.Hash{},
 		Height:    0,
 		Timestamp: 00000,
 	}
 
-	b, _ := core.NewBlock(header, nil)
-
-	return b
+	return core.NewGenesisBlock(header, params)
 }
 
 func (s *Server) validatorLoop() {
@@ -185,9 +352,17 @@ func (s *Server) ProcessMessage(msg *DecodedMessage) error {
 	case *core.Block:
 		return s.processBlock(t)
 	case *GetStatusMessage:
-		return s.processGetStatusMessage(msg.From, t)
+		return s.processGetStatusMessage(msg.From, msg.RequestID, t)
 	case *StatusMessage:
 		return s.processStatusMessage(msg.From, t)
+	case *GetHeadersMessage:
+		return s.processGetHeadersMessage(msg.From, msg.RequestID, t)
+	case *HeadersMessage:
+		return s.processHeadersMessage(msg.From, t)
+	case *GetProofMessage:
+		return s.processGetProofMessage(msg.From, msg.RequestID, t)
+	case *ProofMessage:
+		return s.processProofMessage(msg.From, t)
 	}
 
 	return nil
@@ -220,10 +395,22 @@ func (s *Server) processTransaction(tx *core.Transaction) error {
 		return nil
 	}
 
+	from, err := tx.SenderKey()
+	if err != nil {
+		return err
+	}
+	if !s.chain.AllowsSignatureAlgorithm(from.Algorithm) {
+		return fmt.Errorf("transaction (%s) is signed with a %s key which the chain does not allow", hash, from.Algorithm)
+	}
+
 	if err := tx.Verify(); err != nil {
 		return err
 	}
 
+	if tx.Gas() > s.chain.BlockGasLimit() {
+		return fmt.Errorf("transaction (%s) gas limit (%d) exceeds the block gas limit", hash, tx.Gas())
+	}
+
 	// s.Logger.Log("msg", "adding new tx to mempool", "hash", hash, "mempoolLength", s.memPool.PendingCount())
 
 	go s.broadcastTx(tx)
@@ -231,7 +418,7 @@ func (s *Server) processTransaction(tx *core.Transaction) error {
 	return s.memPool.Add(tx)
 }
 
-func (s *Server) processGetStatusMessage(from NetAddr, data *GetStatusMessage) error {
+func (s *Server) processGetStatusMessage(from NetAddr, requestID uint64, data *GetStatusMessage) error {
 	fmt.Printf("=> received Getstatus msg from %s => %+v\n", from, data)
 
 	statusMessage := &StatusMessage{
@@ -239,18 +426,62 @@ func (s *Server) processGetStatusMessage(from NetAddr, data *GetStatusMessage) e
 		ID:            s.ID,
 	}
 
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(statusMessage); err != nil {
-		return err
+	return s.respond(from, requestID, MessageTypeStatus, statusMessage)
+}
+
+func (s *Server) processStatusMessage(from NetAddr, data *StatusMessage) error {
+	fmt.Printf("=> received GetStatus response msg from %s => %+v\n", from, data)
+
+	return nil
+}
+
+func (s *Server) processGetHeadersMessage(from NetAddr, requestID uint64, data *GetHeadersMessage) error {
+	headers := []*core.SignedHeader{}
+
+	// A peer asking past our height gets an empty answer
+	if data.From <= s.chain.Height() {
+		var err error
+		headers, err = s.chain.GetHeaders(data.From, data.To)
+		if err != nil {
+			return err
+		}
 	}
 
-	msg := NewMessage(MessageTypeStatus, buf.Bytes())
+	return s.respond(from, requestID, MessageTypeHeaders, &HeadersMessage{Headers: headers})
+}
+
+func (s *Server) processHeadersMessage(from NetAddr, data *HeadersMessage) error {
+	s.Logger.Log("msg", "received headers", "from", from, "count", len(data.Headers))
 
-	return s.Transport.SendMessage(from, msg.Bytes())
+	return nil
 }
 
-func (s *Server) processStatusMessage(from NetAddr, data *StatusMessage) error {
-	fmt.Printf("=> received GetStatus response msg from %s => %+v\n", from, data)
+func (s *Server) processGetProofMessage(from NetAddr, requestID uint64, data *GetProofMessage) error {
+	proofMessage := &ProofMessage{
+		Type:   data.Type,
+		Height: data.Height,
+	}
+
+	var err error
+	switch data.Type {
+	case ProofTypeTx:
+		proofMessage.TxProof, err = s.chain.GetTxProof(data.Height, data.TxHash)
+	case ProofTypeState:
+		proofMessage.StateProof, err = s.chain.GetStateProof(data.Height, data.Key)
+	default:
+		err = fmt.Errorf("invalid proof type %x", data.Type)
+	}
+
+	if err != nil {
+		proofMessage.Error = err.Error()
+		proofMessage.NotFound = errors.Is(err, core.ErrTxNotFound)
+	}
+
+	return s.respond(from, requestID, MessageTypeProof, proofMessage)
+}
+
+func (s *Server) processProofMessage(from NetAddr, data *ProofMessage) error {
+	s.Logger.Log("msg", "received proof", "from", from, "height", data.Height, "err", data.Error)
 
 	return nil
 }
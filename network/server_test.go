@@ -0,0 +1,133 @@
+package network
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T, tr Transport) *Server {
+	s, err := NewServer(ServerOpts{
+		ID:         string(tr.Addr()),
+		Logger:     log.NewNopLogger(),
+		Transport:  tr,
+		Transports: []Transport{tr},
+	})
+	assert.Nil(t, err)
+
+	return s
+}
+
+func TestServerRequest(t *testing.T) {
+	tra := NewLocalTransport("A")
+	trb := NewLocalTransport("B")
+	tra.Connect(trb)
+	trb.Connect(tra)
+
+	a := newTestServer(t, tra)
+	b := newTestServer(t, trb)
+	go a.Start()
+	go b.Start()
+
+	for i := 0; i < 10; i++ {
+		msg, err := NewGobMessage(MessageTypeGetStatus, &GetStatusMessage{})
+		assert.Nil(t, err)
+
+		resp, err := a.Request(trb.Addr(), msg, time.Second)
+		assert.Nil(t, err)
+		assert.True(t, resp.Response)
+		assert.Equal(t, trb.Addr(), resp.From)
+
+		status, ok := resp.Data.(*StatusMessage)
+		assert.True(t, ok)
+		assert.Equal(t, "B", status.ID)
+		assert.Equal(t, uint32(0), status.CurrentHeight)
+	}
+
+	assert.Equal(t, 0, a.InflightRequests())
+}
+
+func TestServerRequestTimeout(t *testing.T) {
+	tra := NewLocalTransport("A")
+	trb := NewLocalTransport("B")
+	tra.Connect(trb)
+	trb.Connect(tra)
+
+	// B is never started so it never answers
+	a := newTestServer(t, tra)
+	go a.Start()
+
+	msg, err := NewGobMessage(MessageTypeGetStatus, &GetStatusMessage{})
+	assert.Nil(t, err)
+
+	_, err = a.Request(trb.Addr(), msg, 50*time.Millisecond)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, a.InflightRequests())
+
+	_, err = a.Request("unknown", msg, 50*time.Millisecond)
+	assert.NotNil(t, err)
+}
+
+func TestServerRequestSpoofedResponse(t *testing.T) {
+	tra := NewLocalTransport("A")
+	trb := NewLocalTransport("B")
+	trc := NewLocalTransport("C")
+	tra.Connect(trb)
+	trb.Connect(tra)
+	tra.Connect(trc)
+	trc.Connect(tra)
+
+	// B is never started so only C answers
+	a := newTestServer(t, tra)
+	go a.Start()
+
+	msg, err := NewGobMessage(MessageTypeGetStatus, &GetStatusMessage{})
+	assert.Nil(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := a.Request(trb.Addr(), msg, 200*time.Millisecond)
+		errCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// C answers the request sent to B with its id
+	spoofed, err := NewGobMessage(MessageTypeStatus, &StatusMessage{ID: "C"})
+	assert.Nil(t, err)
+	spoofed.ID = 1
+	spoofed.Response = true
+	payload, err := spoofed.Bytes()
+	assert.Nil(t, err)
+	assert.Nil(t, trc.SendMessage(tra.Addr(), payload))
+
+	assert.NotNil(t, <-errCh)
+	assert.Equal(t, 0, a.InflightRequests())
+}
+
+func TestServerKeystore(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	path := filepath.Join(t.TempDir(), "validator.json")
+	assert.Nil(t, crypto.SavePrivateKey(path, privKey, "secret"))
+
+	tr := NewLocalTransport("A")
+	opts := ServerOpts{
+		Logger:             log.NewNopLogger(),
+		Transport:          tr,
+		Transports:         []Transport{tr},
+		KeystorePath:       path,
+		KeystorePassphrase: "secret",
+	}
+
+	s, err := NewServer(opts)
+	assert.Nil(t, err)
+	assert.True(t, s.isValidator)
+	assert.Equal(t, privKey.PublicKey().Address(), s.PrivateKey.PublicKey().Address())
+
+	opts.KeystorePassphrase = "wrong"
+	_, err = NewServer(opts)
+	assert.NotNil(t, err)
+}
@@ -46,6 +46,14 @@ func (p *TxPool) Pending() []*core.Transaction {
 	return p.pending.txx.Data
 }
 
+// RemovePending removes the given transactions from the pending ones, they
+// stay known to the pool.
+func (p *TxPool) RemovePending(txx []*core.Transaction) {
+	for _, tx := range txx {
+		p.pending.Remove(tx.Hash(core.TxHasher{}))
+	}
+}
+
 func (p *TxPool) ClearPending() {
 	p.pending.Clear()
 }
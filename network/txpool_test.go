@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/anthoai97/blockchain-from-scratch/core"
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
 	"github.com/anthoai97/blockchain-from-scratch/util"
 	"github.com/stretchr/testify/assert"
 )
@@ -38,6 +40,29 @@ func TestTxPoolAdd(t *testing.T) {
 	}
 }
 
+func TestTxPoolSameDataDifferentTxs(t *testing.T) {
+	p := NewTxPool(10)
+	privKey := crypto.GeneratePrivateKey()
+
+	// The same call data to two contracts
+	a := core.NewCallTransaction(types.Address{1}, []byte("foo"))
+	assert.Nil(t, a.Sign(privKey))
+	b := core.NewCallTransaction(types.Address{2}, []byte("foo"))
+	assert.Nil(t, b.Sign(privKey))
+
+	// The same call data from another sender
+	c := core.NewCallTransaction(types.Address{1}, []byte("foo"))
+	assert.Nil(t, c.Sign(crypto.GeneratePrivateKey()))
+
+	for _, tx := range []*core.Transaction{a, b, c} {
+		p.Add(tx)
+	}
+	assert.Equal(t, 3, p.PendingCount())
+	for _, tx := range []*core.Transaction{a, b, c} {
+		assert.True(t, p.Contains(tx.Hash(core.TxHasher{})))
+	}
+}
+
 func TestTxPoolMaxLength(t *testing.T) {
 	maxLen := 10
 	p := NewTxPool(maxLen)
@@ -105,3 +130,16 @@ func TestTxSortedMapRemove(t *testing.T) {
 	assert.Equal(t, m.Count(), 0)
 	assert.False(t, m.Contains(tx.Hash(core.TxHasher{})))
 }
+
+func TestTxPoolRemovePending(t *testing.T) {
+	p := NewTxPool(10)
+	txA := util.NewRandomTransaction(100)
+	txB := util.NewRandomTransaction(100)
+	p.Add(txA)
+	p.Add(txB)
+
+	p.RemovePending([]*core.Transaction{txA})
+	assert.Equal(t, 1, p.PendingCount())
+	assert.Equal(t, txB, p.Pending()[0])
+	assert.True(t, p.Contains(txA.Hash(core.TxHasher{})))
+}
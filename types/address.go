@@ -2,6 +2,7 @@ package types
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 )
 
@@ -19,6 +20,20 @@ func (a Address) ToSlice() []byte {
 	return b
 }
 
+func (a Address) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+func (a *Address) UnmarshalJSON(data []byte) error {
+	b, err := hexFromJSON(data, 20)
+	if err != nil || b == nil {
+		return err
+	}
+
+	*a = AddressFromBytes(b)
+	return nil
+}
+
 func AddressFromBytes(b []byte) Address {
 	if len(b) != 20 {
 		msg := fmt.Sprintf("given bytes with length %d should be 20", len(b))
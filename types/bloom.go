@@ -0,0 +1,73 @@
+package types
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+const (
+	BloomSize = 256
+	// bloomHashes is the number of bits set for every added entry
+	bloomHashes = 3
+)
+
+// Bloom is a 2048 bit bloom filter. Test may report an entry that was never
+// added but never misses one that was.
+type Bloom [BloomSize]uint8
+
+// Add sets the bits of the entry, every bit is taken from 2 bytes of the
+// sha256 of the entry.
+func (b *Bloom) Add(entry []byte) {
+	for _, bit := range bloomBits(entry) {
+		b[BloomSize-1-bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Test tells if the entry may have been added.
+func (b Bloom) Test(entry []byte) bool {
+	for _, bit := range bloomBits(entry) {
+		if b[BloomSize-1-bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Or adds all the entries of other to b.
+func (b *Bloom) Or(other Bloom) {
+	for i := range b {
+		b[i] |= other[i]
+	}
+}
+
+func bloomBits(entry []byte) [bloomHashes]uint {
+	var (
+		h    = sha256.Sum256(entry)
+		bits [bloomHashes]uint
+	)
+
+	for i := range bits {
+		bits[i] = (uint(h[2*i])<<8 | uint(h[2*i+1])) % (8 * BloomSize)
+	}
+
+	return bits
+}
+
+func (b Bloom) String() string {
+	return hex.EncodeToString(b[:])
+}
+
+func (b Bloom) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+func (b *Bloom) UnmarshalJSON(data []byte) error {
+	v, err := hexFromJSON(data, BloomSize)
+	if err != nil || v == nil {
+		return err
+	}
+
+	copy(b[:], v)
+	return nil
+}
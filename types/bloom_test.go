@@ -0,0 +1,35 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloom(t *testing.T) {
+	var b Bloom
+	assert.False(t, b.Test([]byte("foo")))
+
+	b.Add([]byte("foo"))
+	assert.True(t, b.Test([]byte("foo")))
+	assert.False(t, b.Test([]byte("bar")))
+
+	var other Bloom
+	other.Add([]byte("bar"))
+	b.Or(other)
+	assert.True(t, b.Test([]byte("foo")))
+	assert.True(t, b.Test([]byte("bar")))
+}
+
+func TestBloomJSON(t *testing.T) {
+	var b Bloom
+	b.Add([]byte("foo"))
+
+	data, err := json.Marshal(b)
+	assert.Nil(t, err)
+
+	var decoded Bloom
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, b, decoded)
+}
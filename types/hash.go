@@ -3,6 +3,7 @@ package types
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 )
 
@@ -29,6 +30,20 @@ func (h Hash) String() string {
 	return hex.EncodeToString(h.ToSlice())
 }
 
+func (h Hash) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.String())
+}
+
+func (h *Hash) UnmarshalJSON(data []byte) error {
+	b, err := hexFromJSON(data, 32)
+	if err != nil || b == nil {
+		return err
+	}
+
+	*h = HashFromBytes(b)
+	return nil
+}
+
 func HashFromBytes(b []byte) Hash {
 	if len(b) != 32 {
 		msg := fmt.Sprintf("give bytes with length %d should be 32", len(b))
@@ -52,3 +67,27 @@ func RandomBytes(size int) []byte {
 func RandomHash() Hash {
 	return HashFromBytes(RandomBytes(32))
 }
+
+// hexFromJSON decodes a JSON hex string of the given length in bytes. A JSON
+// null returns nil without error.
+func hexFromJSON(data []byte, size int) ([]byte, error) {
+	if string(data) == "null" {
+		return nil, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) != size {
+		return nil, fmt.Errorf("given hex with length %d should be %d", len(b), size)
+	}
+
+	return b, nil
+}
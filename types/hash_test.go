@@ -0,0 +1,35 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashJSON(t *testing.T) {
+	h := RandomHash()
+	b, err := json.Marshal(h)
+	assert.Nil(t, err)
+	assert.Equal(t, `"`+h.String()+`"`, string(b))
+
+	var decoded Hash
+	assert.Nil(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, h, decoded)
+
+	assert.NotNil(t, json.Unmarshal([]byte(`"00ff"`), &decoded))
+	assert.NotNil(t, json.Unmarshal([]byte(`"zz"`), &decoded))
+}
+
+func TestAddressJSON(t *testing.T) {
+	a := AddressFromBytes(RandomBytes(20))
+	b, err := json.Marshal(a)
+	assert.Nil(t, err)
+	assert.Equal(t, `"`+a.String()+`"`, string(b))
+
+	var decoded Address
+	assert.Nil(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, a, decoded)
+
+	assert.NotNil(t, json.Unmarshal([]byte(`"00ff"`), &decoded))
+}
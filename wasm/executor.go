@@ -0,0 +1,224 @@
+package wasm
+
+import (
+	"fmt"
+
+	"github.com/anthoai97/blockchain-from-scratch/core"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+)
+
+const (
+	gasStorageGet    uint64 = 50
+	gasStoragePut    uint64 = 100
+	gasStorageDelete uint64 = 50
+	gasStorePerByte  uint64 = 10
+	gasCallData      uint64 = 3
+	gasCopyPerByte   uint64 = 1
+	gasLog           uint64 = 100
+	gasLogPerTopic   uint64 = 100
+	gasLogPerByte    uint64 = 2
+	gasReturn        uint64 = 3
+
+	// maxLogTopics is the same bound as the one of the stack VM.
+	maxLogTopics = 4
+)
+
+// Executor runs WebAssembly modules, it ignores the VM version of the code.
+// The exported function "main" of the module is run with the host functions
+// of the "env" module:
+//
+//	storage_get(keyPtr, keyLen, valPtr, valCap i32) i32
+//	    copies at most valCap bytes of the value of the key to valPtr and
+//	    returns the length of the value, -1 when the key does not exist
+//	storage_put(keyPtr, keyLen, valPtr, valLen i32)
+//	storage_delete(keyPtr, keyLen i32)
+//	calldata_size() i32
+//	calldata_copy(ptr i32)
+//	emit_log(topicsPtr, topicCount, dataPtr, dataLen i32)
+//	    topics are 32 bytes each
+//	set_return(ptr, len i32)
+//	revert(ptr, len i32)
+//	    stops the execution with core.ErrReverted and the bytes as return
+//	    data
+//
+// Calls to other contracts are not supported.
+type Executor struct{}
+
+// execution is the environment of the host functions.
+type execution struct {
+	*core.Execution
+	storage    core.ContractState
+	logs       []*core.Log
+	returnData []byte
+}
+
+func (Executor) Execute(e *core.Execution) (core.ExecutionResult, error) {
+	if e.Tracer != nil {
+		return core.ExecutionResult{}, fmt.Errorf("wasm code: %w", core.ErrNotTraceable)
+	}
+
+	m, err := Decode(e.Code)
+	if err != nil {
+		return core.ExecutionResult{}, err
+	}
+	main, ok := m.Exports["main"]
+	if !ok {
+		return core.ExecutionResult{}, fmt.Errorf("module does not export main")
+	}
+	if ft, _ := m.FuncType(main); ft != (FuncType{}) {
+		return core.ExecutionResult{}, fmt.Errorf("main must not have parameters or results")
+	}
+
+	ex := &execution{Execution: e, storage: e.Storage()}
+	inst, err := Instantiate(m, ex.hostFuncs(), e.GasLimit)
+	if err != nil {
+		return core.ExecutionResult{}, err
+	}
+
+	_, err = inst.Invoke("main")
+
+	result := core.ExecutionResult{GasUsed: inst.GasUsed()}
+	switch err {
+	case nil:
+		result.Logs = ex.logs
+		result.ReturnData = ex.returnData
+	case core.ErrReverted:
+		result.ReturnData = ex.returnData
+	}
+
+	return result, err
+}
+
+func (ex *execution) hostFuncs() map[string]HostFunc {
+	return map[string]HostFunc{
+		"env.storage_get":    {Type: FuncType{Params: 4, Results: 1}, Call: ex.storageGet},
+		"env.storage_put":    {Type: FuncType{Params: 4}, Call: ex.storagePut},
+		"env.storage_delete": {Type: FuncType{Params: 2}, Call: ex.storageDelete},
+		"env.calldata_size":  {Type: FuncType{Results: 1}, Call: ex.callDataSize},
+		"env.calldata_copy":  {Type: FuncType{Params: 1}, Call: ex.callDataCopy},
+		"env.emit_log":       {Type: FuncType{Params: 4}, Call: ex.emitLog},
+		"env.set_return":     {Type: FuncType{Params: 2}, Call: ex.setReturn},
+		"env.revert":         {Type: FuncType{Params: 2}, Call: ex.revert},
+	}
+}
+
+func (ex *execution) storageGet(inst *Instance, args []uint32) (uint32, error) {
+	if err := inst.UseGas(gasStorageGet); err != nil {
+		return 0, err
+	}
+	key, err := inst.Read(args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := ex.storage.Get(key)
+	if err != nil {
+		return 0xffffffff, nil
+	}
+
+	n := uint32(len(value))
+	if n > args[3] {
+		n = args[3]
+	}
+	if err := inst.UseGas(uint64(n) * gasCopyPerByte); err != nil {
+		return 0, err
+	}
+	if err := inst.Write(args[2], value[:n]); err != nil {
+		return 0, err
+	}
+
+	return uint32(len(value)), nil
+}
+
+func (ex *execution) storagePut(inst *Instance, args []uint32) (uint32, error) {
+	if err := inst.UseGas(gasStoragePut + (uint64(args[1])+uint64(args[3]))*gasStorePerByte); err != nil {
+		return 0, err
+	}
+	key, err := inst.Read(args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+	value, err := inst.Read(args[2], args[3])
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, ex.storage.Put(key, value)
+}
+
+func (ex *execution) storageDelete(inst *Instance, args []uint32) (uint32, error) {
+	if err := inst.UseGas(gasStorageDelete); err != nil {
+		return 0, err
+	}
+	key, err := inst.Read(args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, ex.storage.Delete(key)
+}
+
+func (ex *execution) callDataSize(inst *Instance, args []uint32) (uint32, error) {
+	if err := inst.UseGas(gasCallData); err != nil {
+		return 0, err
+	}
+	return uint32(len(ex.CallData)), nil
+}
+
+func (ex *execution) callDataCopy(inst *Instance, args []uint32) (uint32, error) {
+	if err := inst.UseGas(gasCallData + uint64(len(ex.CallData))*gasCopyPerByte); err != nil {
+		return 0, err
+	}
+	return 0, inst.Write(args[0], ex.CallData)
+}
+
+func (ex *execution) emitLog(inst *Instance, args []uint32) (uint32, error) {
+	if args[1] > maxLogTopics {
+		return 0, core.ErrTooManyTopics
+	}
+	gas := gasLog + uint64(args[1])*gasLogPerTopic + uint64(args[3])*gasLogPerByte
+	if err := inst.UseGas(gas); err != nil {
+		return 0, err
+	}
+
+	raw, err := inst.Read(args[0], args[1]*32)
+	if err != nil {
+		return 0, err
+	}
+	data, err := inst.Read(args[2], args[3])
+	if err != nil {
+		return 0, err
+	}
+
+	topics := make([]types.Hash, args[1])
+	for i := range topics {
+		copy(topics[i][:], raw[i*32:])
+	}
+
+	ex.logs = append(ex.logs, &core.Log{
+		Address: ex.Address,
+		Topics:  topics,
+		Data:    data,
+	})
+	return 0, nil
+}
+
+func (ex *execution) setReturn(inst *Instance, args []uint32) (uint32, error) {
+	if err := inst.UseGas(gasReturn + uint64(args[1])*gasCopyPerByte); err != nil {
+		return 0, err
+	}
+	data, err := inst.Read(args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+
+	ex.returnData = data
+	return 0, nil
+}
+
+func (ex *execution) revert(inst *Instance, args []uint32) (uint32, error) {
+	if _, err := ex.setReturn(inst, args); err != nil {
+		return 0, err
+	}
+	return 0, core.ErrReverted
+}
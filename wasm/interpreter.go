@@ -0,0 +1,655 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/anthoai97/blockchain-from-scratch/core"
+)
+
+const (
+	opUnreachable = 0x00
+	opNop         = 0x01
+	opBlock       = 0x02
+	opLoop        = 0x03
+	opIf          = 0x04
+	opElse        = 0x05
+	opEnd         = 0x0b
+	opBr          = 0x0c
+	opBrIf        = 0x0d
+	opReturn      = 0x0f
+	opCall        = 0x10
+	opDrop        = 0x1a
+	opSelect      = 0x1b
+	opLocalGet    = 0x20
+	opLocalSet    = 0x21
+	opLocalTee    = 0x22
+	opI32Load     = 0x28
+	opI32Load8U   = 0x2d
+	opI32Store    = 0x36
+	opI32Store8   = 0x3a
+	opMemorySize  = 0x3f
+	opI32Const    = 0x41
+	opI32Eqz      = 0x45
+	opI32Eq       = 0x46
+	opI32Ne       = 0x47
+	opI32LtS      = 0x48
+	opI32LtU      = 0x49
+	opI32GtS      = 0x4a
+	opI32GtU      = 0x4b
+	opI32LeS      = 0x4c
+	opI32LeU      = 0x4d
+	opI32GeS      = 0x4e
+	opI32GeU      = 0x4f
+	opI32Add      = 0x6a
+	opI32Sub      = 0x6b
+	opI32Mul      = 0x6c
+	opI32DivS     = 0x6d
+	opI32DivU     = 0x6e
+	opI32RemS     = 0x6f
+	opI32RemU     = 0x70
+	opI32And      = 0x71
+	opI32Or       = 0x72
+	opI32Xor      = 0x73
+	opI32Shl      = 0x74
+	opI32ShrS     = 0x75
+	opI32ShrU     = 0x76
+
+	blockTypeEmpty = 0x40
+)
+
+const (
+	// maxLocals bounds the locals of a function besides its parameters.
+	maxLocals = 1024
+	// maxStack bounds the values on the stack of a single call.
+	maxStack = 1024
+	// maxCallDepth bounds the nested calls between functions.
+	maxCallDepth = 256
+	// gasPerInstruction is the cost of every executed instruction.
+	gasPerInstruction = 1
+)
+
+var (
+	// ErrUnreachable is returned when executing the unreachable instruction.
+	ErrUnreachable = errors.New("unreachable executed")
+	// ErrOutOfBounds is returned when accessing memory outside of the memory.
+	ErrOutOfBounds = errors.New("out of bounds memory access")
+)
+
+// HostFunc is a function of the host that a module can import. Its result
+// is ignored when the type has none.
+type HostFunc struct {
+	Type FuncType
+	Call func(inst *Instance, args []uint32) (uint32, error)
+}
+
+// block is a block, loop or if of a function body, its positions are the ones
+// of the else and end instructions, else is -1 when there is none.
+type block struct {
+	results int
+	elsePos int
+	end     int
+}
+
+// Instance is a module ready to run, with its memory and the gas used by
+// its calls.
+type Instance struct {
+	module   *Module
+	memory   []byte
+	imports  []HostFunc
+	blocks   []map[int]block
+	gasLimit uint64
+	gasUsed  uint64
+	depth    int
+}
+
+// Instantiate resolves the imports of the module against the host
+// functions, keyed by "module.name", and initializes its memory.
+func Instantiate(m *Module, host map[string]HostFunc, gasLimit uint64) (*Instance, error) {
+	inst := &Instance{
+		module:   m,
+		memory:   make([]byte, int(m.Pages)*pageSize),
+		gasLimit: gasLimit,
+	}
+
+	for _, imp := range m.Imports {
+		f, ok := host[imp.Module+"."+imp.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown import %s.%s", imp.Module, imp.Name)
+		}
+		if f.Type != m.Types[imp.Type] {
+			return nil, fmt.Errorf("import %s.%s has the wrong type", imp.Module, imp.Name)
+		}
+		inst.imports = append(inst.imports, f)
+	}
+
+	for i, f := range m.Funcs {
+		blocks, err := scan(f.Body)
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %s", len(m.Imports)+i, err)
+		}
+		inst.blocks = append(inst.blocks, blocks)
+	}
+
+	for _, d := range m.Data {
+		copy(inst.memory[d.Offset:], d.Init)
+	}
+
+	return inst, nil
+}
+
+// Memory returns the memory of the instance.
+func (inst *Instance) Memory() []byte {
+	return inst.memory
+}
+
+// Read returns a copy of the bytes of the memory at ptr.
+func (inst *Instance) Read(ptr, size uint32) ([]byte, error) {
+	if uint64(ptr)+uint64(size) > uint64(len(inst.memory)) {
+		return nil, ErrOutOfBounds
+	}
+	return append([]byte{}, inst.memory[ptr:ptr+size]...), nil
+}
+
+// Write copies the bytes to the memory at ptr.
+func (inst *Instance) Write(ptr uint32, b []byte) error {
+	if uint64(ptr)+uint64(len(b)) > uint64(len(inst.memory)) {
+		return ErrOutOfBounds
+	}
+	copy(inst.memory[ptr:], b)
+	return nil
+}
+
+// GasUsed returns the gas used so far, it equals the gas limit when the
+// execution ran out of gas.
+func (inst *Instance) GasUsed() uint64 {
+	return inst.gasUsed
+}
+
+// UseGas charges gas, host functions use it for their cost.
+func (inst *Instance) UseGas(gas uint64) error {
+	if gas > inst.gasLimit-inst.gasUsed {
+		inst.gasUsed = inst.gasLimit
+		return core.ErrOutOfGas
+	}
+	inst.gasUsed += gas
+	return nil
+}
+
+// Invoke calls the exported function with the arguments and returns its
+// results.
+func (inst *Instance) Invoke(name string, args ...uint32) (results []uint32, err error) {
+	idx, ok := inst.module.Exports[name]
+	if !ok {
+		return nil, fmt.Errorf("no exported function %s", name)
+	}
+	ft, err := inst.module.FuncType(idx)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != ft.Params {
+		return nil, fmt.Errorf("function %s takes %d arguments", name, ft.Params)
+	}
+
+	// Traps unwind the Go stack of the interpreter up to here
+	defer func() {
+		if r := recover(); r != nil {
+			t, ok := r.(trap)
+			if !ok {
+				panic(r)
+			}
+			results, err = nil, t.err
+		}
+	}()
+
+	return inst.call(idx, args), nil
+}
+
+// trap aborts the execution with the error.
+type trap struct {
+	err error
+}
+
+func fail(err error) {
+	panic(trap{err})
+}
+
+func (inst *Instance) call(idx uint32, args []uint32) []uint32 {
+	if int(idx) < len(inst.imports) {
+		f := inst.imports[idx]
+		result, err := f.Call(inst, args)
+		if err != nil {
+			fail(err)
+		}
+		if f.Type.Results == 0 {
+			return nil
+		}
+		return []uint32{result}
+	}
+
+	if inst.depth >= maxCallDepth {
+		fail(core.ErrStackOverflow)
+	}
+	inst.depth++
+	defer func() { inst.depth-- }()
+
+	fnIdx := int(idx) - len(inst.imports)
+	f := inst.module.Funcs[fnIdx]
+	ft := inst.module.Types[f.Type]
+
+	fr := &frame{
+		inst:   inst,
+		body:   f.Body,
+		blocks: inst.blocks[fnIdx],
+		locals: append(append([]uint32{}, args...), make([]uint32, f.Locals)...),
+	}
+	fr.labels = []label{{arity: ft.Results, target: len(f.Body)}}
+	fr.run()
+
+	return fr.popN(ft.Results)
+}
+
+// label is the target of a branch. A branch to a block continues after its
+// end and a branch to a loop at its start.
+type label struct {
+	height int
+	arity  int
+	target int
+	loop   bool
+}
+
+type frame struct {
+	inst   *Instance
+	body   []byte
+	blocks map[int]block
+	locals []uint32
+	stack  []uint32
+	labels []label
+	pc     int
+}
+
+func (f *frame) push(v uint32) {
+	if len(f.stack) >= maxStack {
+		fail(core.ErrStackOverflow)
+	}
+	f.stack = append(f.stack, v)
+}
+
+func (f *frame) pop() uint32 {
+	if len(f.stack) == 0 {
+		fail(core.ErrStackUnderflow)
+	}
+	v := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+	return v
+}
+
+func (f *frame) popN(n int) []uint32 {
+	if len(f.stack) < n {
+		fail(core.ErrStackUnderflow)
+	}
+	values := append([]uint32{}, f.stack[len(f.stack)-n:]...)
+	f.stack = f.stack[:len(f.stack)-n]
+	return values
+}
+
+func (f *frame) pushBool(b bool) {
+	if b {
+		f.push(1)
+	} else {
+		f.push(0)
+	}
+}
+
+func (f *frame) u32() uint32 {
+	v, n, err := readU32(f.body[f.pc:])
+	if err != nil {
+		fail(err)
+	}
+	f.pc += n
+	return v
+}
+
+func (f *frame) local(idx uint32) *uint32 {
+	if int(idx) >= len(f.locals) {
+		fail(fmt.Errorf("unknown local %d", idx))
+	}
+	return &f.locals[idx]
+}
+
+// address returns the address of a memory access of size bytes.
+func (f *frame) address(size uint32) uint32 {
+	f.u32() // alignment
+	offset := f.u32()
+	addr := uint64(f.pop()) + uint64(offset)
+	if addr+uint64(size) > uint64(len(f.inst.memory)) {
+		fail(ErrOutOfBounds)
+	}
+	return uint32(addr)
+}
+
+// branch continues at the label depth levels up.
+func (f *frame) branch(depth uint32) {
+	if int(depth) >= len(f.labels) {
+		fail(fmt.Errorf("invalid branch depth %d", depth))
+	}
+	l := f.labels[len(f.labels)-1-int(depth)]
+
+	values := f.popN(l.arity)
+	if len(f.stack) < l.height {
+		fail(core.ErrStackUnderflow)
+	}
+	f.stack = append(f.stack[:l.height], values...)
+
+	if l.loop {
+		f.labels = f.labels[:len(f.labels)-int(depth)]
+	} else {
+		f.labels = f.labels[:len(f.labels)-1-int(depth)]
+	}
+	f.pc = l.target
+}
+
+func (f *frame) run() {
+	for f.pc < len(f.body) && len(f.labels) > 0 {
+		if err := f.inst.UseGas(gasPerInstruction); err != nil {
+			fail(err)
+		}
+
+		pos := f.pc
+		op := f.body[f.pc]
+		f.pc++
+
+		switch op {
+		case opUnreachable:
+			fail(ErrUnreachable)
+
+		case opNop:
+
+		case opBlock, opLoop:
+			b := f.blocks[pos]
+			f.pc++ // block type
+			l := label{height: len(f.stack), arity: b.results, target: b.end + 1}
+			if op == opLoop {
+				l = label{height: len(f.stack), target: f.pc, loop: true}
+			}
+			f.labels = append(f.labels, l)
+
+		case opIf:
+			b := f.blocks[pos]
+			f.pc++ // block type
+			cond := f.pop()
+			f.labels = append(f.labels, label{height: len(f.stack), arity: b.results, target: b.end + 1})
+			if cond == 0 {
+				if b.elsePos >= 0 {
+					f.pc = b.elsePos + 1
+				} else {
+					f.pc = b.end
+				}
+			}
+
+		case opElse:
+			// The then branch is done, skip to the end of the if
+			f.branch(0)
+
+		case opEnd:
+			l := f.labels[len(f.labels)-1]
+			f.labels = f.labels[:len(f.labels)-1]
+			if len(f.labels) == 0 {
+				f.pc = l.target
+			}
+
+		case opBr:
+			f.branch(f.u32())
+
+		case opBrIf:
+			depth := f.u32()
+			if f.pop() != 0 {
+				f.branch(depth)
+			}
+
+		case opReturn:
+			f.branch(uint32(len(f.labels) - 1))
+
+		case opCall:
+			idx := f.u32()
+			ft, err := f.inst.module.FuncType(idx)
+			if err != nil {
+				fail(err)
+			}
+			for _, v := range f.inst.call(idx, f.popN(ft.Params)) {
+				f.push(v)
+			}
+
+		case opDrop:
+			f.pop()
+
+		case opSelect:
+			cond, b, a := f.pop(), f.pop(), f.pop()
+			if cond != 0 {
+				f.push(a)
+			} else {
+				f.push(b)
+			}
+
+		case opLocalGet:
+			f.push(*f.local(f.u32()))
+
+		case opLocalSet:
+			idx := f.u32()
+			*f.local(idx) = f.pop()
+
+		case opLocalTee:
+			idx := f.u32()
+			v := f.pop()
+			*f.local(idx) = v
+			f.push(v)
+
+		case opI32Load:
+			addr := f.address(4)
+			m := f.inst.memory
+			f.push(uint32(m[addr]) | uint32(m[addr+1])<<8 | uint32(m[addr+2])<<16 | uint32(m[addr+3])<<24)
+
+		case opI32Load8U:
+			f.push(uint32(f.inst.memory[f.address(1)]))
+
+		case opI32Store:
+			v := f.pop()
+			addr := f.address(4)
+			m := f.inst.memory
+			m[addr], m[addr+1], m[addr+2], m[addr+3] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
+
+		case opI32Store8:
+			v := f.pop()
+			f.inst.memory[f.address(1)] = byte(v)
+
+		case opMemorySize:
+			f.pc++ // memory index
+			f.push(uint32(len(f.inst.memory) / pageSize))
+
+		case opI32Const:
+			v, n, err := readI32(f.body[f.pc:])
+			if err != nil {
+				fail(err)
+			}
+			f.pc += n
+			f.push(uint32(v))
+
+		case opI32Eqz:
+			f.pushBool(f.pop() == 0)
+
+		default:
+			if op >= opI32Eq && op <= opI32ShrU {
+				b, a := f.pop(), f.pop()
+				f.binary(op, a, b)
+				continue
+			}
+			fail(core.ErrInvalidOpcode)
+		}
+	}
+}
+
+func (f *frame) binary(op byte, a, b uint32) {
+	switch op {
+	case opI32Eq:
+		f.pushBool(a == b)
+	case opI32Ne:
+		f.pushBool(a != b)
+	case opI32LtS:
+		f.pushBool(int32(a) < int32(b))
+	case opI32LtU:
+		f.pushBool(a < b)
+	case opI32GtS:
+		f.pushBool(int32(a) > int32(b))
+	case opI32GtU:
+		f.pushBool(a > b)
+	case opI32LeS:
+		f.pushBool(int32(a) <= int32(b))
+	case opI32LeU:
+		f.pushBool(a <= b)
+	case opI32GeS:
+		f.pushBool(int32(a) >= int32(b))
+	case opI32GeU:
+		f.pushBool(a >= b)
+	case opI32Add:
+		f.push(a + b)
+	case opI32Sub:
+		f.push(a - b)
+	case opI32Mul:
+		f.push(a * b)
+	case opI32DivS, opI32DivU, opI32RemS, opI32RemU:
+		if b == 0 {
+			fail(core.ErrDivisionByZero)
+		}
+		switch op {
+		case opI32DivS:
+			if int32(a) == -1<<31 && int32(b) == -1 {
+				fail(fmt.Errorf("integer overflow"))
+			}
+			f.push(uint32(int32(a) / int32(b)))
+		case opI32DivU:
+			f.push(a / b)
+		case opI32RemS:
+			if int32(b) == -1 {
+				f.push(0)
+			} else {
+				f.push(uint32(int32(a) % int32(b)))
+			}
+		case opI32RemU:
+			f.push(a % b)
+		}
+	case opI32And:
+		f.push(a & b)
+	case opI32Or:
+		f.push(a | b)
+	case opI32Xor:
+		f.push(a ^ b)
+	case opI32Shl:
+		f.push(a << (b % 32))
+	case opI32ShrS:
+		f.push(uint32(int32(a) >> (b % 32)))
+	case opI32ShrU:
+		f.push(a >> (b % 32))
+	default:
+		fail(core.ErrInvalidOpcode)
+	}
+}
+
+// scan checks that every instruction of the body is supported and matches
+// the blocks with their else and end.
+func scan(body []byte) (map[int]block, error) {
+	var (
+		blocks = map[int]block{}
+		open   []int
+		ifs    = map[int]bool{}
+	)
+
+	for pc := 0; pc < len(body); {
+		pos := pc
+		op := body[pc]
+		pc++
+
+		size, err := immediateSize(op, body[pc:])
+		if err != nil {
+			return nil, fmt.Errorf("at %d: %s", pos, err)
+		}
+
+		switch op {
+		case opBlock, opLoop, opIf:
+			results := 0
+			if body[pc] == typeI32 {
+				results = 1
+			} else if body[pc] != blockTypeEmpty {
+				return nil, fmt.Errorf("at %d: unsupported block type 0x%02x", pos, body[pc])
+			}
+			blocks[pos] = block{results: results, elsePos: -1}
+			ifs[pos] = op == opIf
+			open = append(open, pos)
+
+		case opElse:
+			if len(open) == 0 || !ifs[open[len(open)-1]] || blocks[open[len(open)-1]].elsePos >= 0 {
+				return nil, fmt.Errorf("at %d: else without if", pos)
+			}
+			b := blocks[open[len(open)-1]]
+			b.elsePos = pos
+			blocks[open[len(open)-1]] = b
+
+		case opEnd:
+			if len(open) == 0 {
+				// The end of the function body
+				if pc != len(body) {
+					return nil, fmt.Errorf("at %d: code after the end of the function", pos)
+				}
+				return blocks, nil
+			}
+			b := blocks[open[len(open)-1]]
+			b.end = pos
+			blocks[open[len(open)-1]] = b
+			open = open[:len(open)-1]
+		}
+
+		pc += size
+	}
+
+	return nil, fmt.Errorf("missing end of the function")
+}
+
+// immediateSize returns the size of the immediates of the instruction.
+func immediateSize(op byte, b []byte) (int, error) {
+	u32s := func(n int) (int, error) {
+		size := 0
+		for i := 0; i < n; i++ {
+			_, s, err := readU32(b[size:])
+			if err != nil {
+				return 0, err
+			}
+			size += s
+		}
+		return size, nil
+	}
+
+	switch {
+	case op == opBlock || op == opLoop || op == opIf:
+		if len(b) < 1 {
+			return 0, errUnexpectedEnd
+		}
+		return 1, nil
+	case op == opBr || op == opBrIf || op == opCall || op == opLocalGet || op == opLocalSet || op == opLocalTee:
+		return u32s(1)
+	case op == opI32Load || op == opI32Load8U || op == opI32Store || op == opI32Store8:
+		return u32s(2)
+	case op == opMemorySize:
+		if len(b) < 1 || b[0] != 0 {
+			return 0, fmt.Errorf("invalid memory index")
+		}
+		return 1, nil
+	case op == opI32Const:
+		_, size, err := readI32(b)
+		return size, err
+	case op == opUnreachable || op == opNop || op == opElse || op == opEnd || op == opReturn ||
+		op == opDrop || op == opSelect || op == opI32Eqz || (op >= opI32Eq && op <= opI32GeU) ||
+		(op >= opI32Add && op <= opI32ShrU):
+		return 0, nil
+	}
+
+	return 0, fmt.Errorf("unsupported instruction 0x%02x", op)
+}
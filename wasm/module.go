@@ -0,0 +1,531 @@
+// Package wasm runs a subset of WebAssembly as contract code, see Executor.
+//
+// Only i32 values are supported. A module has at most one memory, its
+// functions may only import the host functions of the "env" module and it
+// exports a function "main" without parameters and results that is run for
+// every transaction.
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var magic = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+const (
+	sectionType     = 1
+	sectionImport   = 2
+	sectionFunction = 3
+	sectionMemory   = 5
+	sectionExport   = 7
+	sectionCode     = 10
+	sectionData     = 11
+
+	typeI32  = 0x7f
+	typeFunc = 0x60
+
+	externFunc = 0x00
+
+	// maxPages bounds the memory of a module, a page is 64 KiB.
+	maxPages = 16
+	pageSize = 1 << 16
+)
+
+var errUnexpectedEnd = errors.New("unexpected end of module")
+
+// FuncType is the signature of a function, every parameter and result is an
+// i32.
+type FuncType struct {
+	Params  int
+	Results int
+}
+
+// Import is a function imported from the host.
+type Import struct {
+	Module string
+	Name   string
+	Type   uint32
+}
+
+type Function struct {
+	Type uint32
+	// Locals is the number of locals besides the parameters.
+	Locals int
+	Body   []byte
+}
+
+type DataSegment struct {
+	Offset uint32
+	Init   []byte
+}
+
+type Module struct {
+	Types   []FuncType
+	Imports []Import
+	Funcs   []Function
+	// Pages is the initial number of pages of the memory.
+	Pages   uint32
+	Exports map[string]uint32
+	Data    []DataSegment
+}
+
+// FuncType returns the type of the function, imports are numbered before
+// the functions of the module.
+func (m *Module) FuncType(idx uint32) (FuncType, error) {
+	var typeIdx uint32
+
+	switch {
+	case int(idx) < len(m.Imports):
+		typeIdx = m.Imports[idx].Type
+	case int(idx) < len(m.Imports)+len(m.Funcs):
+		typeIdx = m.Funcs[int(idx)-len(m.Imports)].Type
+	default:
+		return FuncType{}, fmt.Errorf("unknown function %d", idx)
+	}
+
+	return m.Types[typeIdx], nil
+}
+
+type reader struct {
+	b []byte
+	i int
+}
+
+func (r *reader) done() bool {
+	return r.i >= len(r.b)
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.done() {
+		return 0, errUnexpectedEnd
+	}
+	b := r.b[r.i]
+	r.i++
+	return b, nil
+}
+
+func (r *reader) bytes(n uint32) ([]byte, error) {
+	if uint64(n) > uint64(len(r.b)-r.i) {
+		return nil, errUnexpectedEnd
+	}
+	b := r.b[r.i : r.i+int(n)]
+	r.i += int(n)
+	return b, nil
+}
+
+func (r *reader) u32() (uint32, error) {
+	v, n, err := readU32(r.b[r.i:])
+	r.i += n
+	return v, err
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.u32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(n)
+	return string(b), err
+}
+
+// readU32 reads an unsigned LEB128 number and returns it with its size.
+func readU32(b []byte) (uint32, int, error) {
+	var v uint64
+	for i := 0; i < 5; i++ {
+		if i >= len(b) {
+			return 0, i, errUnexpectedEnd
+		}
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i]&0x80 == 0 {
+			if v > 0xffffffff {
+				return 0, i + 1, fmt.Errorf("integer too large")
+			}
+			return uint32(v), i + 1, nil
+		}
+	}
+	return 0, 5, fmt.Errorf("integer too large")
+}
+
+// readI32 reads a signed LEB128 number and returns it with its size.
+func readI32(b []byte) (int32, int, error) {
+	var v int64
+	for i := 0; i < 5; i++ {
+		if i >= len(b) {
+			return 0, i, errUnexpectedEnd
+		}
+		v |= int64(b[i]&0x7f) << (7 * i)
+		if b[i]&0x80 == 0 {
+			shift := 7 * (i + 1)
+			if shift < 64 && b[i]&0x40 != 0 {
+				v |= -1 << shift
+			}
+			if v < -1<<31 || v > 1<<31-1 {
+				return 0, i + 1, fmt.Errorf("integer too large")
+			}
+			return int32(v), i + 1, nil
+		}
+	}
+	return 0, 5, fmt.Errorf("integer too large")
+}
+
+// Decode parses the binary encoding of a module and checks that it only
+// uses the supported subset.
+func Decode(b []byte) (*Module, error) {
+	if !bytes.HasPrefix(b, magic) {
+		return nil, fmt.Errorf("not a wasm module")
+	}
+
+	m := &Module{Exports: map[string]uint32{}}
+	r := &reader{b: b, i: len(magic)}
+
+	var (
+		funcTypes []uint32
+		last      byte
+	)
+
+	for !r.done() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		content, err := r.bytes(size)
+		if err != nil {
+			return nil, err
+		}
+
+		// Custom sections may appear anywhere, the others are ordered
+		if id == 0 {
+			continue
+		}
+		if id <= last {
+			return nil, fmt.Errorf("section %d out of order", id)
+		}
+		last = id
+
+		s := &reader{b: content}
+		switch id {
+		case sectionType:
+			err = decodeTypes(s, m)
+		case sectionImport:
+			err = decodeImports(s, m)
+		case sectionFunction:
+			funcTypes, err = decodeVector(s, func(s *reader) (uint32, error) { return s.u32() })
+		case sectionMemory:
+			err = decodeMemory(s, m)
+		case sectionExport:
+			err = decodeExports(s, m)
+		case sectionCode:
+			err = decodeCode(s, m, funcTypes)
+		case sectionData:
+			err = decodeData(s, m)
+		default:
+			err = fmt.Errorf("unsupported section %d", id)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("section %d: %s", id, err)
+		}
+		if !s.done() {
+			return nil, fmt.Errorf("section %d: trailing bytes", id)
+		}
+	}
+
+	if len(funcTypes) != len(m.Funcs) {
+		return nil, fmt.Errorf("%d functions declared but %d bodies", len(funcTypes), len(m.Funcs))
+	}
+
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func decodeVector(r *reader, decode func(*reader) (uint32, error)) ([]uint32, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	v := []uint32{}
+	for i := uint32(0); i < n; i++ {
+		x, err := decode(r)
+		if err != nil {
+			return nil, err
+		}
+		v = append(v, x)
+	}
+
+	return v, nil
+}
+
+// valueTypes reads a vector of value types and returns its length, only i32
+// is supported.
+func valueTypes(r *reader) (int, error) {
+	n, err := r.u32()
+	if err != nil {
+		return 0, err
+	}
+	for i := uint32(0); i < n; i++ {
+		t, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if t != typeI32 {
+			return 0, fmt.Errorf("unsupported value type 0x%02x", t)
+		}
+	}
+	return int(n), nil
+}
+
+func decodeTypes(r *reader, m *Module) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < n; i++ {
+		form, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if form != typeFunc {
+			return fmt.Errorf("invalid type form 0x%02x", form)
+		}
+
+		var ft FuncType
+		if ft.Params, err = valueTypes(r); err != nil {
+			return err
+		}
+		if ft.Results, err = valueTypes(r); err != nil {
+			return err
+		}
+		if ft.Results > 1 {
+			return fmt.Errorf("multiple results are not supported")
+		}
+		m.Types = append(m.Types, ft)
+	}
+
+	return nil
+}
+
+func decodeImports(r *reader, m *Module) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < n; i++ {
+		var imp Import
+		if imp.Module, err = r.name(); err != nil {
+			return err
+		}
+		if imp.Name, err = r.name(); err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if kind != externFunc {
+			return fmt.Errorf("only functions can be imported")
+		}
+		if imp.Type, err = r.u32(); err != nil {
+			return err
+		}
+		m.Imports = append(m.Imports, imp)
+	}
+
+	return nil
+}
+
+func decodeMemory(r *reader, m *Module) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return fmt.Errorf("exactly one memory is supported")
+	}
+
+	flags, err := r.byte()
+	if err != nil {
+		return err
+	}
+	if m.Pages, err = r.u32(); err != nil {
+		return err
+	}
+	if flags == 1 {
+		if _, err := r.u32(); err != nil {
+			return err
+		}
+	} else if flags != 0 {
+		return fmt.Errorf("invalid memory limits")
+	}
+
+	if m.Pages > maxPages {
+		return fmt.Errorf("memory of %d pages exceeds %d pages", m.Pages, maxPages)
+	}
+
+	return nil
+}
+
+func decodeExports(r *reader, m *Module) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < n; i++ {
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		idx, err := r.u32()
+		if err != nil {
+			return err
+		}
+		// Only exported functions are of interest
+		if kind == externFunc {
+			m.Exports[name] = idx
+		}
+	}
+
+	return nil
+}
+
+func decodeCode(r *reader, m *Module, funcTypes []uint32) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if int(n) != len(funcTypes) {
+		return fmt.Errorf("%d functions declared but %d bodies", len(funcTypes), n)
+	}
+
+	for i := uint32(0); i < n; i++ {
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		body, err := r.bytes(size)
+		if err != nil {
+			return err
+		}
+
+		f := Function{Type: funcTypes[i]}
+		b := &reader{b: body}
+
+		groups, err := b.u32()
+		if err != nil {
+			return err
+		}
+		for g := uint32(0); g < groups; g++ {
+			count, err := b.u32()
+			if err != nil {
+				return err
+			}
+			t, err := b.byte()
+			if err != nil {
+				return err
+			}
+			if t != typeI32 {
+				return fmt.Errorf("unsupported value type 0x%02x", t)
+			}
+			if uint64(f.Locals)+uint64(count) > maxLocals {
+				return fmt.Errorf("too many locals")
+			}
+			f.Locals += int(count)
+		}
+
+		f.Body = body[b.i:]
+		m.Funcs = append(m.Funcs, f)
+	}
+
+	return nil
+}
+
+func decodeData(r *reader, m *Module) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < n; i++ {
+		memIdx, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if memIdx != 0 {
+			return fmt.Errorf("only active segments of memory 0 are supported")
+		}
+
+		// The offset is a constant expression: i32.const n end
+		op, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if op != opI32Const {
+			return fmt.Errorf("data offset must be an i32 constant")
+		}
+		offset, size, err := readI32(r.b[r.i:])
+		if err != nil {
+			return err
+		}
+		r.i += size
+		if end, err := r.byte(); err != nil || end != opEnd {
+			return fmt.Errorf("data offset must be an i32 constant")
+		}
+
+		size32, err := r.u32()
+		if err != nil {
+			return err
+		}
+		init, err := r.bytes(size32)
+		if err != nil {
+			return err
+		}
+
+		m.Data = append(m.Data, DataSegment{Offset: uint32(offset), Init: init})
+	}
+
+	return nil
+}
+
+// validate checks the references between the parts of the module.
+func (m *Module) validate() error {
+	for _, imp := range m.Imports {
+		if int(imp.Type) >= len(m.Types) {
+			return fmt.Errorf("import %s.%s has unknown type %d", imp.Module, imp.Name, imp.Type)
+		}
+	}
+
+	for i, f := range m.Funcs {
+		if int(f.Type) >= len(m.Types) {
+			return fmt.Errorf("function %d has unknown type %d", i, f.Type)
+		}
+	}
+
+	for name, idx := range m.Exports {
+		if int(idx) >= len(m.Imports)+len(m.Funcs) {
+			return fmt.Errorf("export %s has unknown function %d", name, idx)
+		}
+	}
+
+	for _, d := range m.Data {
+		if uint64(d.Offset)+uint64(len(d.Init)) > uint64(m.Pages)*pageSize {
+			return fmt.Errorf("data segment out of memory")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,422 @@
+package wasm
+
+import (
+	"testing"
+
+	"github.com/anthoai97/blockchain-from-scratch/core"
+	"github.com/anthoai97/blockchain-from-scratch/crypto"
+	"github.com/anthoai97/blockchain-from-scratch/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func uleb(v uint32) []byte {
+	b := []byte{}
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func sleb(v int32) []byte {
+	b := []byte{}
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	b := []byte{}
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func vec(items ...[]byte) []byte {
+	return concat(uleb(uint32(len(items))), concat(items...))
+}
+
+func name(s string) []byte {
+	return concat(uleb(uint32(len(s))), []byte(s))
+}
+
+func section(id byte, content []byte) []byte {
+	return concat([]byte{id}, uleb(uint32(len(content))), content)
+}
+
+func i32s(n int) []byte {
+	b := uleb(uint32(n))
+	for i := 0; i < n; i++ {
+		b = append(b, typeI32)
+	}
+	return b
+}
+
+// i32Const returns the instruction pushing v.
+func i32Const(v int32) []byte {
+	return concat([]byte{opI32Const}, sleb(v))
+}
+
+type testFunc struct {
+	params, results, locals int
+	// body is the code of the function without the final end
+	body   []byte
+	export string
+}
+
+// buildModule returns a module with one page of memory that imports the
+// host functions of the executor with the given names, they are numbered
+// before the functions.
+func buildModule(imports []string, funcs []testFunc, data []byte) []byte {
+	hostFuncs := (&execution{}).hostFuncs()
+
+	var types, imps, decls, exports, codes [][]byte
+	for _, imp := range imports {
+		ft := hostFuncs["env."+imp].Type
+		imps = append(imps, concat(name("env"), name(imp), []byte{externFunc}, uleb(uint32(len(types)))))
+		types = append(types, concat([]byte{typeFunc}, i32s(ft.Params), i32s(ft.Results)))
+	}
+	for i, f := range funcs {
+		decls = append(decls, uleb(uint32(len(types))))
+		types = append(types, concat([]byte{typeFunc}, i32s(f.params), i32s(f.results)))
+
+		if f.export != "" {
+			exports = append(exports, concat(name(f.export), []byte{externFunc}, uleb(uint32(len(imports)+i))))
+		}
+
+		locals := vec()
+		if f.locals > 0 {
+			locals = vec(concat(uleb(uint32(f.locals)), []byte{typeI32}))
+		}
+		body := concat(locals, f.body, []byte{opEnd})
+		codes = append(codes, concat(uleb(uint32(len(body))), body))
+	}
+
+	m := concat(magic,
+		section(sectionType, vec(types...)),
+		section(sectionImport, vec(imps...)),
+		section(sectionFunction, vec(decls...)),
+		section(sectionMemory, vec([]byte{0x00, 0x01})),
+		section(sectionExport, vec(exports...)),
+		section(sectionCode, vec(codes...)),
+	)
+	if data != nil {
+		m = append(m, section(sectionData, vec(concat([]byte{0x00}, i32Const(0), []byte{opEnd}, name(string(data)))))...)
+	}
+
+	return m
+}
+
+// invoke runs the exported function f of a module made of the functions.
+func invoke(t *testing.T, funcs []testFunc, args ...uint32) ([]uint32, error) {
+	m, err := Decode(buildModule(nil, funcs, nil))
+	assert.Nil(t, err)
+	inst, err := Instantiate(m, nil, 100_000)
+	assert.Nil(t, err)
+
+	return inst.Invoke("f", args...)
+}
+
+func TestDecode(t *testing.T) {
+	b := buildModule([]string{"storage_get"}, []testFunc{{export: "main"}}, []byte("hi"))
+	m, err := Decode(b)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []Import{{Module: "env", Name: "storage_get", Type: 0}}, m.Imports)
+	assert.Equal(t, FuncType{Params: 4, Results: 1}, m.Types[0])
+	assert.Equal(t, uint32(1), m.Exports["main"])
+	assert.Equal(t, uint32(1), m.Pages)
+	assert.Equal(t, []DataSegment{{Offset: 0, Init: []byte("hi")}}, m.Data)
+	assert.Equal(t, []byte{opEnd}, m.Funcs[0].Body)
+
+	_, err = Decode([]byte("not wasm"))
+	assert.NotNil(t, err)
+
+	_, err = Decode(b[:len(b)-1])
+	assert.NotNil(t, err)
+
+	// Sections out of order
+	_, err = Decode(concat(magic, section(sectionCode, vec()), section(sectionType, vec())))
+	assert.NotNil(t, err)
+
+	// i64 is not supported
+	_, err = Decode(concat(magic, section(sectionType, vec([]byte{typeFunc, 1, 0x7e, 0}))))
+	assert.NotNil(t, err)
+
+	// Too much memory
+	_, err = Decode(concat(magic, section(sectionMemory, vec([]byte{0x00, maxPages + 1}))))
+	assert.NotNil(t, err)
+
+	// Unsupported instructions are found when instantiating
+	m, err = Decode(buildModule(nil, []testFunc{{body: []byte{0xfc, 0x00}, export: "main"}}, nil))
+	assert.Nil(t, err)
+	_, err = Instantiate(m, nil, 100)
+	assert.NotNil(t, err)
+}
+
+func TestInstantiateImports(t *testing.T) {
+	m, err := Decode(buildModule([]string{"storage_get"}, []testFunc{{export: "main"}}, nil))
+	assert.Nil(t, err)
+
+	_, err = Instantiate(m, nil, 100)
+	assert.NotNil(t, err)
+
+	wrongType := map[string]HostFunc{"env.storage_get": {Type: FuncType{Params: 1}}}
+	_, err = Instantiate(m, wrongType, 100)
+	assert.NotNil(t, err)
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		op     byte
+		a, b   int32
+		result int32
+	}{
+		{opI32Add, 2, 3, 5},
+		{opI32Sub, 2, 3, -1},
+		{opI32Mul, -4, 3, -12},
+		{opI32DivS, -7, 2, -3},
+		{opI32DivU, 7, 2, 3},
+		{opI32RemS, -7, 2, -1},
+		{opI32RemU, 7, 2, 1},
+		{opI32And, 6, 3, 2},
+		{opI32Or, 6, 3, 7},
+		{opI32Xor, 6, 3, 5},
+		{opI32Shl, 1, 33, 2},
+		{opI32ShrS, -8, 1, -4},
+		{opI32ShrU, -8, 28, 15},
+		{opI32Eq, 3, 3, 1},
+		{opI32Ne, 3, 3, 0},
+		{opI32LtS, -1, 0, 1},
+		{opI32LtU, -1, 0, 0},
+		{opI32GtS, -1, 0, 0},
+		{opI32GtU, -1, 0, 1},
+		{opI32LeS, 3, 3, 1},
+		{opI32LeU, 4, 3, 0},
+		{opI32GeS, 3, 4, 0},
+		{opI32GeU, 4, 3, 1},
+	}
+
+	for _, tt := range tests {
+		f := testFunc{
+			params: 2, results: 1, export: "f",
+			body: []byte{opLocalGet, 0, opLocalGet, 1, tt.op},
+		}
+		results, err := invoke(t, []testFunc{f}, uint32(tt.a), uint32(tt.b))
+		assert.Nil(t, err)
+		assert.Equal(t, []uint32{uint32(tt.result)}, results, "op 0x%02x", tt.op)
+	}
+
+	div := testFunc{results: 1, export: "f", body: concat(i32Const(1), i32Const(0), []byte{opI32DivU})}
+	_, err := invoke(t, []testFunc{div})
+	assert.Equal(t, core.ErrDivisionByZero, err)
+}
+
+func TestControlFlow(t *testing.T) {
+	// f(n) = 1 + 2 + ... + n with a loop
+	sum := testFunc{
+		params: 1, results: 1, locals: 1, export: "f",
+		body: concat(
+			[]byte{opBlock, blockTypeEmpty},
+			[]byte{opLoop, blockTypeEmpty},
+			[]byte{opLocalGet, 0, opI32Eqz, opBrIf, 1},
+			[]byte{opLocalGet, 1, opLocalGet, 0, opI32Add, opLocalSet, 1},
+			[]byte{opLocalGet, 0}, i32Const(1), []byte{opI32Sub, opLocalSet, 0},
+			[]byte{opBr, 0},
+			[]byte{opEnd, opEnd},
+			[]byte{opLocalGet, 1},
+		),
+	}
+	results, err := invoke(t, []testFunc{sum}, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{5050}, results)
+
+	// f(x) = x < 10 ? 1 : 2 with if else
+	ifElse := testFunc{
+		params: 1, results: 1, export: "f",
+		body: concat(
+			[]byte{opLocalGet, 0}, i32Const(10), []byte{opI32LtS},
+			[]byte{opIf, typeI32}, i32Const(1), []byte{opElse}, i32Const(2), []byte{opEnd},
+		),
+	}
+	for x, expected := range map[uint32]uint32{3: 1, 12: 2} {
+		results, err := invoke(t, []testFunc{ifElse}, x)
+		assert.Nil(t, err)
+		assert.Equal(t, []uint32{expected}, results)
+	}
+
+	// f(x) = fact(x) with a recursive call and an early return
+	fact := testFunc{
+		params: 1, results: 1,
+		body: concat(
+			[]byte{opLocalGet, 0, opI32Eqz, opIf, blockTypeEmpty}, i32Const(1), []byte{opReturn, opEnd},
+			[]byte{opLocalGet, 0, opLocalGet, 0}, i32Const(1), []byte{opI32Sub, opCall, 1, opI32Mul},
+		),
+	}
+	call := testFunc{params: 1, results: 1, export: "f", body: []byte{opLocalGet, 0, opCall, 1}}
+	results, err = invoke(t, []testFunc{call, fact}, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{120}, results)
+
+	// Unbounded recursion
+	loop := testFunc{export: "f", body: []byte{opCall, 0}}
+	_, err = invoke(t, []testFunc{loop})
+	assert.Equal(t, core.ErrStackOverflow, err)
+
+	unreachable := testFunc{export: "f", body: []byte{opUnreachable}}
+	_, err = invoke(t, []testFunc{unreachable})
+	assert.Equal(t, ErrUnreachable, err)
+
+	underflow := testFunc{export: "f", body: []byte{opDrop}}
+	_, err = invoke(t, []testFunc{underflow})
+	assert.Equal(t, core.ErrStackUnderflow, err)
+}
+
+func TestMemory(t *testing.T) {
+	f := testFunc{
+		results: 1, export: "f",
+		body: concat(
+			i32Const(8), i32Const(0x01020304), []byte{opI32Store, 2, 0},
+			i32Const(4), []byte{opI32Load8U, 0, 5},
+			i32Const(0), []byte{opI32Load, 2, 8},
+			[]byte{opI32Add},
+		),
+	}
+	results, err := invoke(t, []testFunc{f})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{0x03 + 0x01020304}, results)
+
+	oob := testFunc{results: 1, export: "f", body: concat(i32Const(pageSize-3), []byte{opI32Load, 2, 0})}
+	_, err = invoke(t, []testFunc{oob})
+	assert.Equal(t, ErrOutOfBounds, err)
+}
+
+func TestGas(t *testing.T) {
+	m, err := Decode(buildModule(nil, []testFunc{{export: "f", body: []byte{opLoop, blockTypeEmpty, opBr, 0, opEnd}}}, nil))
+	assert.Nil(t, err)
+	inst, err := Instantiate(m, nil, 1000)
+	assert.Nil(t, err)
+
+	_, err = inst.Invoke("f")
+	assert.Equal(t, core.ErrOutOfGas, err)
+	assert.Equal(t, uint64(1000), inst.GasUsed())
+}
+
+// counterModule stores the call data under the key "k" and increments the
+// counter stored under "n" as a single byte, it returns the new counter. It
+// reverts with "no" on empty call data.
+func counterModule() []byte {
+	const (
+		storageGet = iota
+		storagePut
+		callDataSize
+		callDataCopy
+		emitLog
+		setReturn
+		revert
+	)
+	imports := []string{"storage_get", "storage_put", "calldata_size", "calldata_copy", "emit_log", "set_return", "revert"}
+
+	// Memory: "k" at 0, "n" at 1, "no" at 2, the counter at 16, the call
+	// data at 32
+	main := testFunc{
+		locals: 1, export: "main",
+		body: concat(
+			[]byte{opCall, callDataSize, opLocalTee, 0, opI32Eqz, opIf, blockTypeEmpty},
+			i32Const(2), i32Const(2), []byte{opCall, revert},
+			[]byte{opEnd},
+			i32Const(32), []byte{opCall, callDataCopy},
+			i32Const(0), i32Const(1), i32Const(32), []byte{opLocalGet, 0, opCall, storagePut},
+			i32Const(1), i32Const(1), i32Const(16), i32Const(1), []byte{opCall, storageGet, opDrop},
+			i32Const(16), i32Const(16), []byte{opI32Load8U, 0, 0}, i32Const(1), []byte{opI32Add, opI32Store8, 0, 0},
+			i32Const(1), i32Const(1), i32Const(16), i32Const(1), []byte{opCall, storagePut},
+			i32Const(0), i32Const(0), i32Const(16), i32Const(1), []byte{opCall, emitLog},
+			i32Const(16), i32Const(1), []byte{opCall, setReturn},
+		),
+	}
+
+	return buildModule(imports, []testFunc{main}, []byte("knno"))
+}
+
+func TestExecutor(t *testing.T) {
+	state := core.NewState()
+	addr := types.Address{1}
+	execute := func(callData []byte) (core.ExecutionResult, error) {
+		return Executor{}.Execute(&core.Execution{
+			Code:     counterModule(),
+			CallData: callData,
+			Address:  addr,
+			State:    state,
+			GasLimit: 10_000,
+		})
+	}
+
+	result, err := execute([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, result.ReturnData)
+	assert.Len(t, result.Logs, 1)
+	assert.Equal(t, addr, result.Logs[0].Address)
+	assert.Equal(t, []byte{1}, result.Logs[0].Data)
+
+	result, err = execute([]byte("world"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, result.ReturnData)
+	assert.NotZero(t, result.GasUsed)
+
+	storage := (&core.Execution{State: state, Address: addr}).Storage()
+	value, err := storage.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("world"), value)
+
+	result, err = execute(nil)
+	assert.Equal(t, core.ErrReverted, err)
+	assert.Equal(t, []byte("no"), result.ReturnData)
+
+	_, err = Executor{}.Execute(&core.Execution{Code: counterModule(), Tracer: core.NewStepLogger()})
+	assert.ErrorIs(t, err, core.ErrNotTraceable)
+}
+
+func TestBlockchainWithExecutor(t *testing.T) {
+	genesis, err := core.NewBlock(&core.Header{Version: 1}, nil)
+	assert.Nil(t, err)
+	bc, err := core.NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+	bc.SetExecutor(Executor{})
+
+	privKey := crypto.GeneratePrivateKey()
+	deploy := core.NewDeployTransaction(counterModule())
+	assert.Nil(t, deploy.Sign(privKey))
+	result, err := bc.Call(deploy, bc.Height())
+	assert.Nil(t, err)
+	assert.Nil(t, result.Err)
+
+	// Calls are run on a copy of the state with the deploy transaction
+	// executed, deploy on the chain first
+	prevHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	b, err := core.NewBlockFromPrevHeader(prevHeader, []*core.Transaction{deploy})
+	assert.Nil(t, err)
+	state, receipts, err := bc.ExecuteBlock(b)
+	assert.Nil(t, err)
+	b.StateRoot = state.Root()
+	b.LogsBloom = core.LogsBloom(receipts)
+	assert.Nil(t, b.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(b))
+
+	call := core.NewCallTransaction(receipts[0].ContractAddress, []byte("hi"))
+	result, err = bc.Call(call, bc.Height())
+	assert.Nil(t, err)
+	assert.Nil(t, result.Err)
+	assert.Equal(t, []byte{1}, result.ReturnData)
+	assert.Len(t, result.Logs, 1)
+}